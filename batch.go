@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Batch editing. One hand-written change set (keys to set, keys to delete) is
+// applied to a list of "owner/repo:env" (or "owner/repo") targets, with a
+// per-target preview and report.
+
+// BatchChangeSet is the change set applied to every target
+type BatchChangeSet struct {
+	SetVariables    map[string]string `json:"set_variables"`
+	SetSecrets      map[string]string `json:"set_secrets"`
+	DeleteVariables []string          `json:"delete_variables"`
+	DeleteSecrets   []string          `json:"delete_secrets"`
+}
+
+func (s *BatchChangeSet) empty() bool {
+	return len(s.SetVariables) == 0 && len(s.SetSecrets) == 0 && len(s.DeleteVariables) == 0 && len(s.DeleteSecrets) == 0
+}
+
+// normalize uppercases key names like GitHub does, rejects invalid names and
+// keys given twice, and drops repeated deletes
+func (s *BatchChangeSet) normalize() error {
+	var err error
+	if s.SetVariables, err = normalizeKeyValues("variable", s.SetVariables); err != nil {
+		return err
+	}
+	if s.SetSecrets, err = normalizeKeyValues("secret", s.SetSecrets); err != nil {
+		return err
+	}
+	if s.DeleteVariables, err = normalizeKeyNames("variable", s.DeleteVariables, s.SetVariables); err != nil {
+		return err
+	}
+	if s.DeleteSecrets, err = normalizeKeyNames("secret", s.DeleteSecrets, s.SetSecrets); err != nil {
+		return err
+	}
+	return nil
+}
+
+func normalizeKeyValues(keyType string, values map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(values))
+	for _, name := range sortedKeys(values) {
+		upper := strings.ToUpper(name)
+		if !isValidKeyName(upper) {
+			return nil, fmt.Errorf("invalid %s name %q", keyType, name)
+		}
+		if _, exists := normalized[upper]; exists {
+			return nil, fmt.Errorf("%s %s is set more than once", keyType, upper)
+		}
+		normalized[upper] = values[name]
+	}
+	return normalized, nil
+}
+
+func normalizeKeyNames(keyType string, names []string, set map[string]string) ([]string, error) {
+	normalized := []string{}
+	for _, name := range names {
+		upper := strings.ToUpper(name)
+		if !isValidKeyName(upper) {
+			return nil, fmt.Errorf("invalid %s name %q", keyType, name)
+		}
+		if _, exists := set[upper]; exists {
+			return nil, fmt.Errorf("%s %s is both set and deleted", keyType, upper)
+		}
+		if !contains(normalized, upper) {
+			normalized = append(normalized, upper)
+		}
+	}
+	return normalized, nil
+}
+
+// uniqueTargets drops repeated targets. Owner, repository and environment
+// names are case-insensitive, so "Acme/API:Prod" repeats "acme/api:prod".
+func uniqueTargets(targets []string) []string {
+	seen := make(map[string]bool, len(targets))
+	unique := []string{}
+	for _, target := range targets {
+		key := strings.ToLower(strings.TrimSpace(target))
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, strings.TrimSpace(target))
+		}
+	}
+	return unique
+}
+
+// BatchKeyChange is one key of a target
+type BatchKeyChange struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // "create", "update", "unchanged", "delete", "absent"
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	loc    KeyLocation
+	value  string
+}
+
+// BatchTargetReport is the plan or result for one target
+type BatchTargetReport struct {
+	Target  string           `json:"target"`
+	Status  string           `json:"status"` // "planned", "applied", "partial", "failed", "unchanged"
+	Changes []BatchKeyChange `json:"changes"`
+	Applied int              `json:"applied"`
+	Failed  int              `json:"failed"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// planBatchTarget compares the change set with the current keys of a target
+func planBatchTarget(client *github.Client, ctx context.Context, target string, changes *BatchChangeSet) ([]BatchKeyChange, error) {
+	variables, secrets, err := listTargetKeys(client, ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		current[strings.ToUpper(variable.Name)] = variable.Value
+	}
+
+	planned := []BatchKeyChange{}
+	add := func(keyType, name, action, value string) {
+		loc, _ := targetLocation(target, keyType, name)
+		planned = append(planned, BatchKeyChange{Type: keyType, Name: name, Action: action, loc: loc, value: value})
+	}
+
+	for _, name := range sortedKeys(changes.SetVariables) {
+		value := changes.SetVariables[name]
+		existing, exists := current[name]
+		switch {
+		case !exists:
+			add("variable", name, "create", value)
+		case existing == value:
+			add("variable", name, "unchanged", value)
+		default:
+			add("variable", name, "update", value)
+		}
+	}
+	for _, name := range sortedKeys(changes.SetSecrets) {
+		action := "create"
+		if hasSecret(secrets, name) {
+			action = "update"
+		}
+		add("secret", name, action, changes.SetSecrets[name])
+	}
+	for _, name := range changes.DeleteVariables {
+		action := "absent"
+		if _, exists := current[name]; exists {
+			action = "delete"
+		}
+		add("variable", name, action, "")
+	}
+	for _, name := range changes.DeleteSecrets {
+		action := "absent"
+		if hasSecret(secrets, name) {
+			action = "delete"
+		}
+		add("secret", name, action, "")
+	}
+	return planned, nil
+}
+
+// applyBatchTarget writes the planned changes of one target
+func applyBatchTarget(client *github.Client, ctx context.Context, report *BatchTargetReport) {
+	for i, change := range report.Changes {
+		var err error
+		switch change.Action {
+		case "create", "update":
+			err = writeKey(client, ctx, change.loc, change.value)
+		case "delete":
+			err = deleteKey(client, ctx, change.loc)
+		default:
+			continue
+		}
+		if err != nil {
+			report.Changes[i].Status = "failed"
+			report.Changes[i].Error = err.Error()
+			report.Failed++
+			continue
+		}
+		report.Changes[i].Status = "applied"
+		report.Applied++
+	}
+
+	switch {
+	case report.Failed == 0 && report.Applied == 0:
+		report.Status = "unchanged"
+	case report.Failed == 0:
+		report.Status = "applied"
+	case report.Applied == 0:
+		report.Status = "failed"
+	default:
+		report.Status = "partial"
+	}
+}
+
+func batchEdit(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Targets []string `json:"targets"`
+		BatchChangeSet
+		DryRun bool `json:"dry_run"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one target is required"})
+		return
+	}
+	if req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The change set is empty"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Targets = uniqueTargets(req.Targets)
+	for _, target := range req.Targets {
+		if _, err := targetLocation(target, "variable", ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	// Plan every target before writing anything
+	reports := make([]BatchTargetReport, len(req.Targets))
+	violations := []PolicyViolation{}
+	for i, target := range req.Targets {
+		reports[i] = BatchTargetReport{Target: target, Status: "planned", Changes: []BatchKeyChange{}}
+		changes, err := planBatchTarget(client, ctx, target, &req.BatchChangeSet)
+		if err != nil {
+			reports[i].Status = "failed"
+			reports[i].Error = err.Error()
+			continue
+		}
+		reports[i].Changes = changes
+		for _, change := range changes {
+			if change.loc.Scope != "environment" {
+				continue
+			}
+			switch change.Action {
+			case "create", "update":
+				violations = append(violations, activePolicy.checkKey(change.loc.Environment, change.Type, change.Name)...)
+			case "delete":
+				violations = append(violations, activePolicy.checkDelete(change.loc.Environment, change.Type, change.Name)...)
+			}
+		}
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"targets": reports, "violations": violations})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	// Fail fast on targets the token can't write
+	locations := []KeyLocation{}
+	for _, report := range reports {
+		for _, change := range report.Changes {
+			if change.Action != "unchanged" && change.Action != "absent" {
+				locations = append(locations, change.loc)
+			}
+		}
+	}
+	if rejectForbiddenTargets(c, preflightLocations(client, ctx, user.Token, locations)) {
+		return
+	}
+
+	applied := 0
+	failedTargets := []string{}
+	for i := range reports {
+		if reports[i].Status != "failed" {
+			applyBatchTarget(client, ctx, &reports[i])
+			applied += reports[i].Applied
+		}
+		if reports[i].Status == "failed" || reports[i].Status == "partial" {
+			failedTargets = append(failedTargets, reports[i].Target)
+		}
+	}
+
+	message := fmt.Sprintf("Applied %d changes to %d targets", applied, len(reports))
+	if len(failedTargets) > 0 {
+		message += fmt.Sprintf(" (failures in %s)", strings.Join(failedTargets, ", "))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       message,
+		"applied_count": applied,
+		"targets":       reports,
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchChangeSetNormalize(t *testing.T) {
+	changes := &BatchChangeSet{
+		SetVariables:    map[string]string{"api_url": "https://api"},
+		SetSecrets:      map[string]string{"Token": "s3cret"},
+		DeleteVariables: []string{"old_flag", "OLD_FLAG", "Old_Flag"},
+	}
+	if err := changes.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changes.SetVariables, map[string]string{"API_URL": "https://api"}) ||
+		!reflect.DeepEqual(changes.SetSecrets, map[string]string{"TOKEN": "s3cret"}) ||
+		!reflect.DeepEqual(changes.DeleteVariables, []string{"OLD_FLAG"}) {
+		t.Fatalf("unexpected normalized change set %+v", changes)
+	}
+
+	for _, invalid := range []*BatchChangeSet{
+		{SetVariables: map[string]string{"api_url": "a", "API_URL": "b"}},
+		{SetVariables: map[string]string{"1BAD": "a"}},
+		{DeleteSecrets: []string{"github_token"}},
+		{SetSecrets: map[string]string{"token": "a"}, DeleteSecrets: []string{"TOKEN"}},
+	} {
+		if err := invalid.normalize(); err == nil {
+			t.Errorf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestUniqueTargets(t *testing.T) {
+	got := uniqueTargets([]string{"acme/api:prod", "Acme/API:Prod", " acme/api:prod ", "acme/api"})
+	if want := []string{"acme/api:prod", "acme/api"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
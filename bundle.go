@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted bundles. The payload is JSON, sealed either with a passphrase (scrypt
+// key derivation + NaCl secretbox, in a JSON envelope) or to one or more age
+// recipients (ASCII-armored age file). openBundle detects which one it got.
+
+const (
+	bundleFormat  = "github-env-manager-bundle"
+	bundleVersion = 1
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// BundleScope holds the keys of one repository or environment
+type BundleScope struct {
+	Target    string            `json:"target"` // "owner/repo" or "owner/repo:env"
+	Variables map[string]string `json:"variables,omitempty"`
+	Secrets   map[string]string `json:"secrets,omitempty"`
+}
+
+// BundlePayload is the decrypted content of a bundle. Variables and Secrets are
+// unscoped values (e.g. a plain secret value bundle); Scopes holds exported
+// repositories and environments.
+type BundlePayload struct {
+	CreatedAt string            `json:"created_at,omitempty"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Secrets   map[string]string `json:"secrets,omitempty"`
+	Scopes    []BundleScope     `json:"scopes,omitempty"`
+}
+
+// allSecrets returns every secret value of the bundle by name. Unscoped values
+// win over scoped ones; between scopes the first one wins.
+func (p *BundlePayload) allSecrets() map[string]string {
+	secrets := make(map[string]string)
+	for _, scope := range p.Scopes {
+		for name, value := range scope.Secrets {
+			if _, exists := secrets[name]; !exists {
+				secrets[name] = value
+			}
+		}
+	}
+	for name, value := range p.Secrets {
+		secrets[name] = value
+	}
+	return secrets
+}
+
+// BundleKeys says how a bundle is sealed or opened: with a passphrase, or with
+// age recipients (sealing) and an age identity (opening)
+type BundleKeys struct {
+	Passphrase string   `json:"passphrase,omitempty"`
+	Recipients []string `json:"recipients,omitempty"` // age1... public keys
+	Identity   string   `json:"identity,omitempty"`   // AGE-SECRET-KEY-1... or an identity file
+}
+
+// bundleEnvelope is the on-disk form of a passphrase-encrypted bundle
+type bundleEnvelope struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func deriveBundleKey(passphrase string, salt []byte, n, r, p int) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// sealBundle encrypts a payload with a passphrase or to age recipients
+func sealBundle(payload *BundlePayload, keys BundleKeys) ([]byte, error) {
+	if (keys.Passphrase == "") == (len(keys.Recipients) == 0) {
+		return nil, fmt.Errorf("provide either a passphrase or age recipients")
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys.Recipients) > 0 {
+		return sealAge(plaintext, keys.Recipients)
+	}
+
+	envelope := bundleEnvelope{Format: bundleFormat, Version: bundleVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	envelope.Salt = make([]byte, 16)
+	if _, err := rand.Read(envelope.Salt); err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	envelope.Nonce = nonce[:]
+
+	key, err := deriveBundleKey(keys.Passphrase, envelope.Salt, envelope.N, envelope.R, envelope.P)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, key)
+
+	return json.MarshalIndent(envelope, "", "  ")
+}
+
+// openBundle decrypts a bundle produced by sealBundle
+func openBundle(data []byte, keys BundleKeys) (*BundlePayload, error) {
+	var plaintext []byte
+	var err error
+
+	if isAgeFile(data) {
+		plaintext, err = openAge(data, keys.Identity)
+	} else {
+		plaintext, err = openPassphraseBundle(data, keys.Passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var payload BundlePayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("invalid bundle payload: %v", err)
+	}
+	return &payload, nil
+}
+
+func openPassphraseBundle(data []byte, passphrase string) ([]byte, error) {
+	var envelope bundleEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("not a bundle: %v", err)
+	}
+	if envelope.Format != bundleFormat || envelope.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported bundle format")
+	}
+	if envelope.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", envelope.Version)
+	}
+	if len(envelope.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid bundle nonce")
+	}
+	// The cost parameters come from the upload, so only the ones sealBundle
+	// writes are accepted; anything else could make scrypt eat the server
+	if envelope.N != scryptN || envelope.R != scryptR || envelope.P != scryptP {
+		return nil, fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d", envelope.N, envelope.R, envelope.P)
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required")
+	}
+
+	key, err := deriveBundleKey(passphrase, envelope.Salt, envelope.N, envelope.R, envelope.P)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], envelope.Nonce)
+	plaintext, ok := secretbox.Open(nil, envelope.Ciphertext, &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("wrong passphrase or corrupted bundle")
+	}
+	return plaintext, nil
+}
+
+func isAgeFile(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte(armor.Header)) || bytes.HasPrefix(trimmed, []byte("age-encryption.org/"))
+}
+
+// sealAge encrypts to age X25519 recipients and armors the result
+func sealAge(plaintext []byte, recipients []string) ([]byte, error) {
+	parsed := make([]age.Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(recipient))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %v", recipient, err)
+		}
+		parsed = append(parsed, r)
+	}
+
+	var out bytes.Buffer
+	armored := armor.NewWriter(&out)
+	w, err := age.Encrypt(armored, parsed...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := armored.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// openAge decrypts an armored or binary age file. identity may be a single
+// AGE-SECRET-KEY or the content of an identity file.
+func openAge(data []byte, identity string) ([]byte, error) {
+	if identity == "" {
+		return nil, fmt.Errorf("an age identity is required")
+	}
+	identities, err := age.ParseIdentities(strings.NewReader(identity))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %v", err)
+	}
+
+	var in io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		in = armor.NewReader(bufio.NewReader(bytes.NewReader(bytes.TrimSpace(data))))
+	}
+
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bundle: %v", err)
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleRejectsForeignScryptParameters(t *testing.T) {
+	payload := &BundlePayload{Secrets: map[string]string{"API_TOKEN": "tok_123"}}
+	sealed, err := sealBundle(payload, BundleKeys{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := openBundle(sealed, BundleKeys{Passphrase: "correct horse"})
+	if err != nil || opened.Secrets["API_TOKEN"] != "tok_123" {
+		t.Fatalf("expected the bundle to open, got %v, %v", opened, err)
+	}
+
+	for _, tamper := range []func(*bundleEnvelope){
+		func(e *bundleEnvelope) { e.N = 1 << 30 },
+		func(e *bundleEnvelope) { e.R = 1 << 20 },
+		func(e *bundleEnvelope) { e.P = 1 << 20 },
+		func(e *bundleEnvelope) { e.N = 2 },
+	} {
+		var envelope bundleEnvelope
+		if err := json.Unmarshal(sealed, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		tamper(&envelope)
+		data, _ := json.Marshal(envelope)
+
+		start := time.Now()
+		_, err := openBundle(data, BundleKeys{Passphrase: "correct horse"})
+		if err == nil || !strings.Contains(err.Error(), "scrypt parameters") {
+			t.Fatalf("expected N=%d r=%d p=%d to be rejected, got %v", envelope.N, envelope.R, envelope.P, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("rejecting N=%d r=%d p=%d took %s", envelope.N, envelope.R, envelope.P, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Permission preflight. GitHub doesn't tell a token what it may write, so
+// capabilities are probed: reads list one item, writes target a key or
+// environment that doesn't exist. A 404 means the request got past the
+// permission check; a 403 means it didn't. Nothing is ever changed. Results are
+// cached per token for a few minutes so bulk operations can check all their
+// targets before the first write.
+
+const capabilityCacheTTL = 5 * time.Minute
+
+// Capability names used in reports
+const (
+	capReadVariables      = "read_variables"
+	capWriteVariables     = "write_variables"
+	capReadSecrets        = "read_secrets"
+	capWriteSecrets       = "write_secrets"
+	capManageEnvironments = "manage_environments"
+	capOrgAdmin           = "org_admin"
+)
+
+// CapabilityProbe is one request made to find out a capability
+type CapabilityProbe struct {
+	Capability string `json:"capability"`
+	Request    string `json:"request"`
+	Status     int    `json:"status"`
+	Allowed    bool   `json:"allowed"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Capabilities is what the token may do in a repository or organization
+type Capabilities struct {
+	Repo         string            `json:"repo,omitempty"`
+	Org          string            `json:"org,omitempty"`
+	Permission   string            `json:"permission,omitempty"` // "admin", "maintain", "write", "triage", "read" or "none"
+	Capabilities map[string]bool   `json:"capabilities"`
+	Probes       []CapabilityProbe `json:"probes"`
+	CheckedAt    string            `json:"checked_at"`
+}
+
+func (c *Capabilities) can(capability string) bool {
+	return c.Capabilities[capability]
+}
+
+type cachedCapabilities struct {
+	at           time.Time
+	capabilities *Capabilities
+}
+
+var capabilityCache = struct {
+	sync.Mutex
+	entries map[string]cachedCapabilities
+}{entries: make(map[string]cachedCapabilities)}
+
+func capabilityCacheKey(token, scope string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8]) + "|" + scope
+}
+
+// cachedCapabilityCheck returns a cached result for scope or runs check
+func cachedCapabilityCheck(token, scope string, check func() (*Capabilities, error)) (*Capabilities, error) {
+	key := capabilityCacheKey(token, scope)
+	capabilityCache.Lock()
+	cached, ok := capabilityCache.entries[key]
+	capabilityCache.Unlock()
+	if ok && time.Since(cached.at) < capabilityCacheTTL {
+		return cached.capabilities, nil
+	}
+
+	capabilities, err := check()
+	if err != nil {
+		return nil, err
+	}
+
+	capabilityCache.Lock()
+	capabilityCache.entries[key] = cachedCapabilities{at: time.Now(), capabilities: capabilities}
+	capabilityCache.Unlock()
+	return capabilities, nil
+}
+
+// probeName returns a key name that won't exist
+func probeName() string {
+	return "GEM_PREFLIGHT_" + strings.ToUpper(newJobID())
+}
+
+// probe runs a request and records whether it passed the permission check.
+// missingOK says a 404 counts as allowed (write probes against missing keys).
+func (c *Capabilities) probe(capability, request string, missingOK bool, call func() (*github.Response, error)) {
+	resp, err := call()
+	result := CapabilityProbe{Capability: capability, Request: request}
+	if resp != nil {
+		result.Status = resp.StatusCode
+	}
+
+	var errResp *github.ErrorResponse
+	switch {
+	case err == nil:
+		result.Allowed = true
+	case errors.As(err, &errResp) && errResp.Response != nil:
+		result.Status = errResp.Response.StatusCode
+		result.Message = errResp.Message
+		// 422 means the request was authorized and then rejected as invalid
+		result.Allowed = result.Status == http.StatusUnprocessableEntity || (missingOK && result.Status == http.StatusNotFound)
+	default:
+		result.Message = err.Error()
+	}
+
+	c.Probes = append(c.Probes, result)
+	if existing, ok := c.Capabilities[capability]; !ok || existing {
+		c.Capabilities[capability] = result.Allowed
+	}
+}
+
+// repoPermission turns the permissions map of a repository into a role name
+func repoPermission(permissions map[string]bool) string {
+	for _, role := range []string{"admin", "maintain", "push", "triage", "pull"} {
+		if permissions[role] {
+			switch role {
+			case "push":
+				return "write"
+			case "pull":
+				return "read"
+			}
+			return role
+		}
+	}
+	return "none"
+}
+
+// checkRepoCapabilities probes what the token may do in a repository
+func checkRepoCapabilities(client *github.Client, ctx context.Context, owner, repo string) (*Capabilities, error) {
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %v", owner, repo, err)
+	}
+
+	caps := &Capabilities{
+		Repo:         owner + "/" + repo,
+		Permission:   repoPermission(repository.Permissions),
+		Capabilities: make(map[string]bool),
+		Probes:       []CapabilityProbe{},
+		CheckedAt:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	one := &github.ListOptions{PerPage: 1}
+	name := probeName()
+
+	caps.probe(capReadVariables, "GET actions/variables", false, func() (*github.Response, error) {
+		_, resp, err := client.Actions.ListRepoVariables(ctx, owner, repo, one)
+		return resp, err
+	})
+	caps.probe(capWriteVariables, "PATCH actions/variables/"+name, true, func() (*github.Response, error) {
+		return client.Actions.UpdateRepoVariable(ctx, owner, repo, &github.ActionsVariable{Name: name, Value: ""})
+	})
+	caps.probe(capReadSecrets, "GET actions/secrets", false, func() (*github.Response, error) {
+		_, resp, err := client.Actions.ListRepoSecrets(ctx, owner, repo, one)
+		return resp, err
+	})
+	caps.probe(capWriteSecrets, "GET actions/secrets/public-key", false, func() (*github.Response, error) {
+		_, resp, err := client.Actions.GetRepoPublicKey(ctx, owner, repo)
+		return resp, err
+	})
+	caps.probe(capWriteSecrets, "DELETE actions/secrets/"+name, true, func() (*github.Response, error) {
+		return client.Actions.DeleteRepoSecret(ctx, owner, repo, name)
+	})
+	caps.probe(capManageEnvironments, "DELETE environments/"+strings.ToLower(name), true, func() (*github.Response, error) {
+		return client.Repositories.DeleteEnvironment(ctx, owner, repo, strings.ToLower(name))
+	})
+
+	// Probes can pass on a 404 the permission level rules out: variables and
+	// secrets need write access, environments need admin
+	if caps.Permission == "read" || caps.Permission == "triage" || caps.Permission == "none" {
+		caps.Capabilities[capWriteVariables] = false
+		caps.Capabilities[capWriteSecrets] = false
+	}
+	if caps.Permission != "admin" {
+		caps.Capabilities[capManageEnvironments] = false
+	}
+
+	if repository.GetOwner().GetType() == "Organization" {
+		orgCaps, err := checkOrgCapabilities(client, ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		caps.Org = owner
+		caps.Capabilities[capOrgAdmin] = orgCaps.can(capOrgAdmin)
+	}
+
+	return caps, nil
+}
+
+// checkEnvironmentCapabilities probes whether the token may write variables and
+// secrets of one environment. Environment keys sit behind their own permission
+// for fine-grained tokens and GitHub Apps, so repository probes don't answer it.
+func checkEnvironmentCapabilities(client *github.Client, ctx context.Context, owner, repo, env string) (*Capabilities, error) {
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %v", owner, repo, err)
+	}
+
+	caps := &Capabilities{
+		Repo:         owner + "/" + repo + ":" + env,
+		Permission:   repoPermission(repository.Permissions),
+		Capabilities: make(map[string]bool),
+		Probes:       []CapabilityProbe{},
+		CheckedAt:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	repoID := int(repository.GetID())
+	name := probeName()
+	caps.probe(capWriteVariables, "PATCH environments/"+env+"/variables/"+name, true, func() (*github.Response, error) {
+		return client.Actions.UpdateEnvVariable(ctx, owner, repo, env, &github.ActionsVariable{Name: name, Value: ""})
+	})
+	// A 404 here is an environment that doesn't exist yet, not a missing permission
+	caps.probe(capWriteSecrets, "GET environments/"+env+"/secrets/public-key", true, func() (*github.Response, error) {
+		_, resp, err := client.Actions.GetEnvPublicKey(ctx, repoID, env)
+		return resp, err
+	})
+	caps.probe(capWriteSecrets, "DELETE environments/"+env+"/secrets/"+name, true, func() (*github.Response, error) {
+		return client.Actions.DeleteEnvSecret(ctx, repoID, env, name)
+	})
+
+	if caps.Permission == "read" || caps.Permission == "triage" || caps.Permission == "none" {
+		caps.Capabilities[capWriteVariables] = false
+		caps.Capabilities[capWriteSecrets] = false
+	}
+	return caps, nil
+}
+
+// checkOrgCapabilities finds out whether the token may manage org variables and secrets
+func checkOrgCapabilities(client *github.Client, ctx context.Context, org string) (*Capabilities, error) {
+	caps := &Capabilities{
+		Org:          org,
+		Capabilities: make(map[string]bool),
+		Probes:       []CapabilityProbe{},
+		CheckedAt:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	membership, _, err := client.Organizations.GetOrgMembership(ctx, "", org)
+	switch {
+	case err == nil:
+		caps.Permission = membership.GetRole()
+	case isNotFound(err):
+		caps.Permission = "none"
+	default:
+		caps.Permission = "unknown"
+	}
+
+	name := probeName()
+	caps.probe(capOrgAdmin, "GET orgs/actions/secrets/public-key", false, func() (*github.Response, error) {
+		_, resp, err := client.Actions.GetOrgPublicKey(ctx, org)
+		return resp, err
+	})
+	caps.probe(capOrgAdmin, "DELETE orgs/actions/variables/"+name, true, func() (*github.Response, error) {
+		return client.Actions.DeleteOrgVariable(ctx, org, name)
+	})
+	if caps.Permission != "admin" && caps.Permission != "unknown" {
+		caps.Capabilities[capOrgAdmin] = false
+	}
+	caps.Capabilities[capWriteVariables] = caps.Capabilities[capOrgAdmin]
+	caps.Capabilities[capWriteSecrets] = caps.Capabilities[capOrgAdmin]
+
+	return caps, nil
+}
+
+// TargetAccess is a target the token can't write
+type TargetAccess struct {
+	Target  string   `json:"target"`
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// preflightLocations checks that the token may write every location and returns
+// the targets it can't write. Repository, environment and org keys are probed
+// (and cached) separately, since a token may write one and not the others.
+func preflightLocations(client *github.Client, ctx context.Context, token string, locations []KeyLocation) []TargetAccess {
+	needs := make(map[string][]string) // "owner/repo", "environment:owner/repo:env" or "org:name" -> capabilities
+	for _, loc := range locations {
+		scope, capability := loc.Repo, capWriteVariables
+		if loc.Type == "secret" {
+			capability = capWriteSecrets
+		}
+		switch loc.Scope {
+		case "org":
+			scope = "org:" + loc.Org
+		case "environment":
+			scope = "environment:" + loc.Repo + ":" + loc.Environment
+		}
+		if !contains(needs[scope], capability) {
+			needs[scope] = append(needs[scope], capability)
+		}
+	}
+
+	scopes := make([]string, 0, len(needs))
+	for scope := range needs {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	denied := []TargetAccess{}
+	for _, scope := range scopes {
+		caps, err := cachedCapabilityCheck(token, scope, func() (*Capabilities, error) {
+			if org, ok := strings.CutPrefix(scope, "org:"); ok {
+				return checkOrgCapabilities(client, ctx, org)
+			}
+			if target, ok := strings.CutPrefix(scope, "environment:"); ok {
+				owner, repo, env, err := parseEnvTarget(target)
+				if err != nil {
+					return nil, err
+				}
+				return checkEnvironmentCapabilities(client, ctx, owner, repo, env)
+			}
+			owner, repo, err := parseRepo(scope)
+			if err != nil {
+				return nil, err
+			}
+			return checkRepoCapabilities(client, ctx, owner, repo)
+		})
+		target := strings.TrimPrefix(scope, "environment:")
+		if err != nil {
+			denied = append(denied, TargetAccess{Target: target, Error: err.Error()})
+			continue
+		}
+
+		access := TargetAccess{Target: target}
+		for _, capability := range needs[scope] {
+			if !caps.can(capability) {
+				access.Missing = append(access.Missing, capability)
+			}
+		}
+		if len(access.Missing) > 0 {
+			denied = append(denied, access)
+		}
+	}
+	return denied
+}
+
+// rejectForbiddenTargets writes a 403 listing the targets the token can't write
+func rejectForbiddenTargets(c *gin.Context, denied []TargetAccess) bool {
+	if len(denied) == 0 {
+		return false
+	}
+	targets := make([]string, len(denied))
+	for i, access := range denied {
+		targets[i] = access.Target
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":   "The token cannot write to " + strings.Join(targets, ", "),
+		"targets": denied,
+	})
+	return true
+}
+
+func getRepoCapabilities(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	check := func() (*Capabilities, error) { return checkRepoCapabilities(client, ctx, owner, repo) }
+	var caps *Capabilities
+	if c.Query("refresh") == "true" {
+		caps, err = check()
+	} else {
+		caps, err = cachedCapabilityCheck(user.Token, owner+"/"+repo, check)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, caps)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+func TestPreflightProbesEnvironmentKeysSeparately(t *testing.T) {
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/environments/prod/"):
+			// The token may write the repository's keys but not the environment's
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(gin.H{"message": "Resource not accessible by integration"})
+		case r.URL.Path == "/repos/acme/app":
+			json.NewEncoder(w).Encode(gin.H{"id": 1, "permissions": gin.H{"push": true}, "owner": gin.H{"type": "User"}})
+		case r.URL.Path == "/repos/acme/app/actions/secrets/public-key":
+			json.NewEncoder(w).Encode(gin.H{"key_id": "k1", "key": "a2V5"})
+		case r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(gin.H{"total_count": 0})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	client := github.NewClient(nil).WithAuthToken("token-env-preflight")
+	denied := preflightLocations(client, context.Background(), "token-env-preflight", []KeyLocation{
+		{Scope: "repository", Type: "secret", Repo: "acme/app", Name: "API_KEY"},
+		{Scope: "environment", Type: "secret", Repo: "acme/app", Environment: "prod", Name: "API_KEY"},
+	})
+	if len(denied) != 1 || denied[0].Target != "acme/app:prod" || !contains(denied[0].Missing, capWriteSecrets) {
+		t.Fatalf("expected only the environment to be denied, got %+v", denied)
+	}
+}
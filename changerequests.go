@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Four-eyes change requests. Writes to environments matching a protected pattern
+// aren't made directly: they're staged as a change request holding the diff
+// against the live values, a second user approves or rejects it, and only then
+// does the server apply it. Secret values are encrypted for the environment when
+// staged, so the local store never holds them in plain text. Bulk operations
+// can't write protected environments at all; they have to go through a request.
+
+// protectedEnvironments are the environment globs that need a change request
+var protectedEnvironments []string
+
+func isProtectedEnvironment(env string) bool {
+	return env != "" && matchesGlob(protectedEnvironments, env)
+}
+
+// ChangeRequestKey is one key of a change request
+type ChangeRequestKey struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Action  string `json:"action"`            // "create", "update" or "delete"
+	Current string `json:"current,omitempty"` // live variable value when staged
+	Value   string `json:"value,omitempty"`   // new variable value
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Secret values, encrypted with the environment's public key
+	KeyID          string `json:"key_id,omitempty"`
+	EncryptedValue string `json:"encrypted_value,omitempty"`
+}
+
+// ChangeRequest is a staged change set for one protected environment
+type ChangeRequest struct {
+	ID            string             `json:"id"`
+	Repo          string             `json:"repo"`
+	Environment   string             `json:"environment"`
+	Title         string             `json:"title,omitempty"`
+	Changes       []ChangeRequestKey `json:"changes"`
+	Status        string             `json:"status"` // "pending", "approved", "rejected", "applied" or "failed"
+	RequestedBy   string             `json:"requested_by"`
+	RequestedAt   string             `json:"requested_at"`
+	ReviewedBy    string             `json:"reviewed_by,omitempty"`
+	ReviewedAt    string             `json:"reviewed_at,omitempty"`
+	ReviewComment string             `json:"review_comment,omitempty"`
+	AppliedBy     string             `json:"applied_by,omitempty"`
+	AppliedAt     string             `json:"applied_at,omitempty"`
+	Errors        []string           `json:"errors,omitempty"`
+}
+
+func (r *ChangeRequest) target() RBACTarget {
+	owner, _, _ := parseRepo(r.Repo)
+	return RBACTarget{Org: owner, Repo: r.Repo, Environment: r.Environment}
+}
+
+// public returns a copy without the encrypted secret values
+func (r ChangeRequest) public() ChangeRequest {
+	r.Changes = append([]ChangeRequestKey(nil), r.Changes...)
+	for i := range r.Changes {
+		r.Changes[i].KeyID = ""
+		r.Changes[i].EncryptedValue = ""
+	}
+	return r
+}
+
+func findChangeRequest(requests []ChangeRequest, id string) *ChangeRequest {
+	for i := range requests {
+		if requests[i].ID == id {
+			return &requests[i]
+		}
+	}
+	return nil
+}
+
+// loadChangeRequest returns a copy of a stored change request
+func loadChangeRequest(id string) *ChangeRequest {
+	var request *ChangeRequest
+	store.view(func(data *StoreData) {
+		if stored := findChangeRequest(data.ChangeRequests, id); stored != nil {
+			copied := *stored
+			copied.Changes = append([]ChangeRequestKey(nil), stored.Changes...)
+			request = &copied
+		}
+	})
+	return request
+}
+
+// saveChangeRequest stores a change request and tells connected clients about it
+func saveChangeRequest(request *ChangeRequest, event string) error {
+	err := store.update(func(data *StoreData) error {
+		if existing := findChangeRequest(data.ChangeRequests, request.ID); existing != nil {
+			*existing = *request
+			return nil
+		}
+		data.ChangeRequests = append(data.ChangeRequests, *request)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	notifications.broadcast(Notification{Type: "change_request", Event: event, ChangeRequest: request.public()})
+	return nil
+}
+
+// stageChangeRequest diffs a change set against the live keys of an environment
+// and stores the result as a pending change request
+func stageChangeRequest(client *github.Client, ctx context.Context, user *User, repo, env, title string, changes *BatchChangeSet) (*ChangeRequest, error) {
+	owner, name, err := parseRepo(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	planned, err := planBatchTarget(client, ctx, repo+":"+env, changes)
+	if err != nil {
+		return nil, err
+	}
+	live, err := listEnvironmentVariables(client, ctx, owner, name, env)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string, len(live))
+	for _, variable := range live {
+		current[variable.Name] = variable.Value
+	}
+
+	request := &ChangeRequest{
+		ID:          newJobID(),
+		Repo:        repo,
+		Environment: env,
+		Title:       title,
+		Changes:     []ChangeRequestKey{},
+		Status:      "pending",
+		RequestedBy: user.Login,
+		RequestedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	for _, change := range planned {
+		if change.Action == "unchanged" || change.Action == "absent" {
+			continue
+		}
+		key := ChangeRequestKey{Type: change.Type, Name: change.Name, Action: change.Action}
+		switch {
+		case change.Type == "variable":
+			key.Current = current[change.Name]
+			key.Value = change.value
+		case change.Action != "delete":
+			sealed, err := sealSecret(client, ctx, change.loc, change.value)
+			if err != nil {
+				return nil, err
+			}
+			key.KeyID = sealed.KeyID
+			key.EncryptedValue = sealed.EncryptedValue
+		}
+		request.Changes = append(request.Changes, key)
+	}
+	if len(request.Changes) == 0 {
+		return nil, fmt.Errorf("nothing to change in %s:%s", repo, env)
+	}
+
+	if err := saveChangeRequest(request, "created"); err != nil {
+		return nil, fmt.Errorf("failed to save change request: %v", err)
+	}
+	return request, nil
+}
+
+// stageProtectedChange stages a single-key edit of a protected environment and
+// answers 202; it returns false when the environment isn't protected
+func stageProtectedChange(c *gin.Context, user *User, loc KeyLocation, value string, remove bool) bool {
+	if !isProtectedEnvironment(loc.Environment) {
+		return false
+	}
+
+	changes := &BatchChangeSet{}
+	switch {
+	case remove && loc.Type == "secret":
+		changes.DeleteSecrets = []string{loc.Name}
+	case remove:
+		changes.DeleteVariables = []string{loc.Name}
+	case loc.Type == "secret":
+		changes.SetSecrets = map[string]string{loc.Name: value}
+	default:
+		changes.SetVariables = map[string]string{loc.Name: value}
+	}
+
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+	request, err := stageChangeRequest(client, context.Background(), user, loc.Repo, loc.Environment, "", changes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to stage change request: %v", err)})
+		return true
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        fmt.Sprintf("%s is protected; the change was staged as change request %s for review", loc.Environment, request.ID),
+		"change_request": request.public(),
+	})
+	return true
+}
+
+// repoAccess returns the permission of a user's own token on a repository
+// ("admin", "maintain", "write", "triage", "read" or "none"), cached with the
+// capability checks
+func repoAccess(user *User, repo string) string {
+	owner, name, err := parseRepo(repo)
+	if err != nil {
+		return "none"
+	}
+	caps, err := cachedCapabilityCheck(user.Token, "permission:"+strings.ToLower(repo), func() (*Capabilities, error) {
+		client := github.NewClient(nil).WithAuthToken(user.Token)
+		repository, _, err := client.Repositories.Get(context.Background(), owner, name)
+		if err != nil {
+			if isNotFound(err) {
+				return &Capabilities{Repo: repo, Permission: "none"}, nil
+			}
+			return nil, err
+		}
+		return &Capabilities{Repo: repo, Permission: repoPermission(repository.Permissions)}, nil
+	})
+	if err != nil {
+		return "none"
+	}
+	return caps.Permission
+}
+
+// canWriteRepo reports whether a user's own token may write a repository
+func canWriteRepo(user *User, repo string) bool {
+	switch repoAccess(user, repo) {
+	case "admin", "maintain", "write":
+		return true
+	}
+	return false
+}
+
+// orgRole returns the role of a user's own token in an organization ("admin",
+// "member" or "none"), cached with the capability checks
+func orgRole(user *User, org string) string {
+	if org == "" {
+		return "none"
+	}
+	caps, err := cachedCapabilityCheck(user.Token, "membership:"+strings.ToLower(org), func() (*Capabilities, error) {
+		client := github.NewClient(nil).WithAuthToken(user.Token)
+		membership, _, err := client.Organizations.GetOrgMembership(context.Background(), "", org)
+		if err != nil {
+			if isNotFound(err) {
+				return &Capabilities{Org: org, Permission: "none"}, nil
+			}
+			return nil, err
+		}
+		if membership.GetState() != "active" {
+			return &Capabilities{Org: org, Permission: "none"}, nil
+		}
+		return &Capabilities{Org: org, Permission: membership.GetRole()}, nil
+	})
+	if err != nil {
+		return "none"
+	}
+	return caps.Permission
+}
+
+// canWriteLocation reports whether a user's own token may manage keys at a
+// location: org keys need an org admin, repository and environment keys write
+func canWriteLocation(user *User, loc KeyLocation) bool {
+	if loc.Scope == "org" {
+		return orgRole(user, loc.Org) == "admin"
+	}
+	return canWriteRepo(user, loc.Repo)
+}
+
+// authorizeLocationEdit checks that a user may change what the server keeps
+// about a location, like secret metadata: their role has to allow writing it and
+// their token has to write the repository, or administer the org
+func authorizeLocationEdit(c *gin.Context, user *User, loc KeyLocation) bool {
+	if !checkRBAC(c, user, "write", locationTargets([]KeyLocation{loc})) {
+		return false
+	}
+	if !canWriteLocation(user, loc) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You need write access to " + loc.String()})
+		return false
+	}
+	return true
+}
+
+// readerFilter returns a check for whether a user may see staged values of
+// targets: their token has to read every repository (or belong to every org)
+// and, with RBAC on, their role has to allow reading every target
+func readerFilter(user *User) func(targets []RBACTarget) bool {
+	var principal RBACPrincipal
+	var principalErr error
+	if activeRBAC != nil {
+		principal, principalErr = rbacPrincipal(user)
+	}
+	return func(targets []RBACTarget) bool {
+		if activeRBAC != nil && (principalErr != nil || len(activeRBAC.denied(principal, "read", targets)) > 0) {
+			return false
+		}
+		for _, target := range targets {
+			if target.Repo == "" && target.Org != "" {
+				if orgRole(user, target.Org) == "none" {
+					return false
+				}
+				continue
+			}
+			if repoAccess(user, target.Repo) == "none" {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// rejectProtectedTargets writes a 409 when a bulk write would touch a protected environment
+func rejectProtectedTargets(c *gin.Context, targets []RBACTarget) bool {
+	protected := []string{}
+	for _, target := range targets {
+		if isProtectedEnvironment(target.Environment) && !contains(protected, target.String()) {
+			protected = append(protected, target.String())
+		}
+	}
+	if len(protected) == 0 {
+		return false
+	}
+	sort.Strings(protected)
+	c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+		"error":   fmt.Sprintf("%s are protected; stage the change with POST /api/change-requests", strings.Join(protected, ", ")),
+		"targets": protected,
+	})
+	return true
+}
+
+// authorizeWrite checks targets a handler found itself against RBAC and the
+// protected environments
+func authorizeWrite(c *gin.Context, user *User, targets []RBACTarget) bool {
+	return checkRBAC(c, user, "write", targets) && !rejectProtectedTargets(c, targets)
+}
+
+// changeRequestRoutes stage protected writes themselves, so the bulk guard skips them
+var changeRequestRoutes = []string{
+	"/api/repos/:owner/:repo/environments/:env/variables",
+	"/api/repos/:owner/:repo/environments/:env/variables/:name",
+	"/api/repos/:owner/:repo/environments/:env/secrets",
+	"/api/repos/:owner/:repo/environments/:env/secrets/:name",
+}
+
+// protectedEnvironmentsMiddleware stops direct writes to protected environments
+func protectedEnvironmentsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if len(protectedEnvironments) == 0 || contains(changeRequestRoutes, path) || strings.HasPrefix(path, "/api/change-requests") {
+			c.Next()
+			return
+		}
+		body, err := requestBody(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if requestAction(c, body) == "read" || !rejectProtectedTargets(c, requestTargets(c, body)) {
+			c.Next()
+		}
+	}
+}
+
+// verifyChangeRequest compares the live keys with the values the request was
+// staged against and returns the keys that changed since
+func verifyChangeRequest(client *github.Client, ctx context.Context, request *ChangeRequest) ([]string, error) {
+	owner, repo, _ := parseRepo(request.Repo)
+	live, err := listEnvironmentVariables(client, ctx, owner, repo, request.Environment)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]string, len(live))
+	for _, variable := range live {
+		current[variable.Name] = variable.Value
+	}
+
+	drifted := []string{}
+	for _, key := range request.Changes {
+		if key.Type != "variable" {
+			continue
+		}
+		value, exists := current[key.Name]
+		if (key.Action == "create") == exists || (exists && value != key.Current) {
+			drifted = append(drifted, key.Name)
+		}
+	}
+	return drifted, nil
+}
+
+// applyChangeRequest writes a change request and records the result
+func applyChangeRequest(client *github.Client, ctx context.Context, request *ChangeRequest, login string) {
+	request.Errors = nil
+	for i, key := range request.Changes {
+		loc := KeyLocation{Scope: "environment", Type: key.Type, Repo: request.Repo, Environment: request.Environment, Name: key.Name}
+		var err error
+		switch {
+		case key.Action == "delete":
+			err = deleteKey(client, ctx, loc)
+		case key.Type == "variable":
+			err = writeKey(client, ctx, loc, key.Value)
+		default:
+			err = writeSealedSecret(client, ctx, loc, &github.EncryptedSecret{Name: key.Name, KeyID: key.KeyID, EncryptedValue: key.EncryptedValue})
+		}
+		if err != nil {
+			request.Changes[i].Status = "failed"
+			request.Changes[i].Error = err.Error()
+			request.Errors = append(request.Errors, fmt.Sprintf("%s: %v", loc, err))
+			continue
+		}
+		request.Changes[i].Status = "applied"
+	}
+
+	request.Status = "applied"
+	if len(request.Errors) > 0 {
+		request.Status = "failed"
+	}
+	request.AppliedBy = login
+	request.AppliedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+}
+
+func listChangeRequests(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	status := c.Query("status")
+	repo := c.Query("repo")
+	matching := []ChangeRequest{}
+	store.view(func(data *StoreData) {
+		for _, request := range data.ChangeRequests {
+			if (status == "" || request.Status == status) && (repo == "" || strings.EqualFold(request.Repo, repo)) {
+				matching = append(matching, request.public())
+			}
+		}
+	})
+
+	// Requests hold live values, so only show those the user can read
+	mayRead := readerFilter(user)
+	requests := []ChangeRequest{}
+	for _, request := range matching {
+		if mayRead([]RBACTarget{request.target()}) {
+			requests = append(requests, request)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedAt > requests[j].RequestedAt })
+
+	c.JSON(http.StatusOK, gin.H{"change_requests": requests, "protected_environments": protectedEnvironments})
+}
+
+func getChangeRequest(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	request := loadChangeRequest(c.Param("id"))
+	if request == nil || !readerFilter(user)([]RBACTarget{request.target()}) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return
+	}
+
+	response := gin.H{"change_request": request.public()}
+	if request.Status == "pending" || request.Status == "approved" {
+		client := github.NewClient(nil).WithAuthToken(user.Token)
+		if drifted, err := verifyChangeRequest(client, context.Background(), request); err == nil {
+			response["drifted"] = drifted
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func createChangeRequest(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Repo        string `json:"repo"`
+		Environment string `json:"environment"`
+		Title       string `json:"title"`
+		BatchChangeSet
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, _, err := parseRepo(req.Repo); err != nil || req.Environment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "repo ('owner/repo') and environment are required"})
+		return
+	}
+	if req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The change set is empty"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	violations := []PolicyViolation{}
+	for _, name := range sortedKeys(req.SetVariables) {
+		violations = append(violations, activePolicy.checkKey(req.Environment, "variable", name)...)
+	}
+	for _, name := range sortedKeys(req.SetSecrets) {
+		violations = append(violations, activePolicy.checkKey(req.Environment, "secret", name)...)
+	}
+	for _, name := range req.DeleteVariables {
+		violations = append(violations, activePolicy.checkDelete(req.Environment, "variable", name)...)
+	}
+	for _, name := range req.DeleteSecrets {
+		violations = append(violations, activePolicy.checkDelete(req.Environment, "secret", name)...)
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+	request, err := stageChangeRequest(client, context.Background(), user, req.Repo, req.Environment, req.Title, &req.BatchChangeSet)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to stage change request: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"change_request": request.public()})
+}
+
+// reviewChangeRequest approves or rejects a pending change request
+func reviewChangeRequest(c *gin.Context, approve bool) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+		Apply   bool   `json:"apply"` // apply right after approving
+	}
+	// The body is optional
+	c.ShouldBindJSON(&req)
+
+	request := loadChangeRequest(c.Param("id"))
+	if request == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return
+	}
+	if request.Status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Change request is %s", request.Status)})
+		return
+	}
+
+	// The requester may withdraw their own request, but never approve it
+	own := request.RequestedBy == user.Login
+	if approve && own {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A change request must be approved by someone other than its author"})
+		return
+	}
+	// Reviewers need write access to the repository with their own token, so a
+	// second account without access can't approve
+	if !own && !canWriteRepo(user, request.Repo) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Reviewing needs write access to %s", request.Repo)})
+		return
+	}
+	if !own && !checkRBAC(c, user, "approve", []RBACTarget{request.target()}) {
+		return
+	}
+
+	event := "rejected"
+	request.Status = "rejected"
+	if approve {
+		event = "approved"
+		request.Status = "approved"
+	}
+	request.ReviewedBy = user.Login
+	request.ReviewedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	request.ReviewComment = req.Comment
+	if err := saveChangeRequest(request, event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save change request: %v", err)})
+		return
+	}
+
+	if approve && req.Apply {
+		runChangeRequest(c, user, request)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"change_request": request.public()})
+}
+
+func approveChangeRequest(c *gin.Context) {
+	reviewChangeRequest(c, true)
+}
+
+func rejectChangeRequest(c *gin.Context) {
+	reviewChangeRequest(c, false)
+}
+
+func applyChangeRequestHandler(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	request := loadChangeRequest(c.Param("id"))
+	if request == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return
+	}
+	if request.Status != "approved" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Change request is %s, not approved", request.Status)})
+		return
+	}
+	if !checkRBAC(c, user, "write", []RBACTarget{request.target()}) {
+		return
+	}
+
+	runChangeRequest(c, user, request)
+}
+
+// runChangeRequest applies an approved change request unless the environment
+// changed since it was staged
+func runChangeRequest(c *gin.Context, user *User, request *ChangeRequest) {
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	drifted, err := verifyChangeRequest(client, ctx, request)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s:%s: %v", request.Repo, request.Environment, err)})
+		return
+	}
+	if len(drifted) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          fmt.Sprintf("%s changed since the request was staged; stage it again", strings.Join(drifted, ", ")),
+			"drifted":        drifted,
+			"change_request": request.public(),
+		})
+		return
+	}
+
+	// The policy may have changed since the request was staged
+	violations := []PolicyViolation{}
+	for _, key := range request.Changes {
+		if key.Action == "delete" {
+			violations = append(violations, activePolicy.checkDelete(request.Environment, key.Type, key.Name)...)
+		} else {
+			violations = append(violations, activePolicy.checkKey(request.Environment, key.Type, key.Name)...)
+		}
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	applyChangeRequest(client, ctx, request, user.Login)
+	if err := saveChangeRequest(request, request.Status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save change request: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        fmt.Sprintf("Change request %s %s", request.ID, request.Status),
+		"change_request": request.public(),
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeRepoPermissions answers GET /repos/acme/svc with the permissions of each token
+func fakeRepoPermissions(t *testing.T, permissions map[string]string) {
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		permission, ok := permissions[token]
+		if r.URL.Path != "/repos/acme/svc" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":          1,
+			"permissions": map[string]bool{permission: true},
+		})
+	}))
+}
+
+func TestReviewChangeRequestNeedsASecondWriter(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"alice-token": "push", "bob-token": "pull", "carol-token": "push"})
+
+	request := &ChangeRequest{ID: "cr1", Repo: "acme/svc", Environment: "production", Status: "pending", RequestedBy: "alice",
+		Changes: []ChangeRequestKey{{Type: "variable", Name: "DEBUG", Action: "update", Current: "false", Value: "true"}}}
+	if err := saveChangeRequest(request, "created"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.POST("/api/change-requests/:id/approve", approveChangeRequest)
+	approve := func(login string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/change-requests/cr1/approve", nil)
+		req.Header.Set("X-Session-ID", testSession(t, login, login+"-token"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := approve("alice"); code != http.StatusForbidden {
+		t.Errorf("author approving: got status %d, want 403", code)
+	}
+	if code := approve("bob"); code != http.StatusForbidden {
+		t.Errorf("reader approving: got status %d, want 403", code)
+	}
+	if code := approve("mallory"); code != http.StatusForbidden {
+		t.Errorf("user without access approving: got status %d, want 403", code)
+	}
+	if code := approve("carol"); code != http.StatusOK {
+		t.Errorf("writer approving: got status %d, want 200", code)
+	}
+	if stored := loadChangeRequest("cr1"); stored.Status != "approved" || stored.ReviewedBy != "carol" {
+		t.Errorf("got status %s reviewed by %s, want approved by carol", stored.Status, stored.ReviewedBy)
+	}
+}
+
+func TestListChangeRequestsHidesUnreadableRepositories(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"alice-token": "pull"})
+
+	request := &ChangeRequest{ID: "cr1", Repo: "acme/svc", Environment: "production", Status: "pending", RequestedBy: "alice",
+		Changes: []ChangeRequestKey{{Type: "variable", Name: "DB_HOST", Action: "update", Current: "db-1", Value: "db-2"}}}
+	if err := saveChangeRequest(request, "created"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/change-requests", listChangeRequests)
+	list := func(login string) []ChangeRequest {
+		req := httptest.NewRequest(http.MethodGet, "/api/change-requests", nil)
+		req.Header.Set("X-Session-ID", testSession(t, login, login+"-token"))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var response struct {
+			ChangeRequests []ChangeRequest `json:"change_requests"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return response.ChangeRequests
+	}
+
+	if requests := list("alice"); len(requests) != 1 {
+		t.Errorf("reader: got %d requests, want 1", len(requests))
+	}
+	if requests := list("mallory"); len(requests) != 0 {
+		t.Errorf("user without access: got %d requests, want 0", len(requests))
+	}
+}
+
+func TestProtectedEnvironmentsAreMatchedCaseInsensitively(t *testing.T) {
+	previous := protectedEnvironments
+	protectedEnvironments = []string{"production*"}
+	t.Cleanup(func() { protectedEnvironments = previous })
+
+	for _, env := range []string{"production", "Production", "PRODUCTION-eu"} {
+		if !isProtectedEnvironment(env) {
+			t.Errorf("%s isn't protected", env)
+		}
+	}
+	if isProtectedEnvironment("staging") {
+		t.Error("staging is protected")
+	}
+}
+
+func TestProtectedEnvironmentsMiddlewareReadsBodyWhateverTheContentType(t *testing.T) {
+	previous := protectedEnvironments
+	protectedEnvironments = []string{"production*"}
+	t.Cleanup(func() { protectedEnvironments = previous })
+
+	router := gin.New()
+	api := router.Group("/api")
+	api.Use(protectedEnvironmentsMiddleware())
+	api.POST("/batch", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	for _, contentType := range []string{"application/json", "text/plain"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"targets":["acme/svc:Production"],"set_variables":{"DEBUG":"true"}}`))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("Content-Type %q: got status %d, want 409", contentType, rec.Code)
+		}
+	}
+}
+
+// A clone only reads the environment in its route; the target in the body is the write
+func TestCloneFromProtectedEnvironmentOnlyChecksTheTarget(t *testing.T) {
+	previous := protectedEnvironments
+	protectedEnvironments = []string{"production*"}
+	t.Cleanup(func() { protectedEnvironments = previous })
+
+	config := &RBACConfig{DefaultRole: "viewer", Rules: []RBACRule{
+		{Role: "editor", Repos: []string{"acme/svc"}, Environments: []string{"staging*"}},
+	}}
+	router, sessionID := testRBACRouter(t, config, "dev")
+	api := router.Group("/api", rbacMiddleware(), protectedEnvironmentsMiddleware())
+	api.POST("/repos/:owner/:repo/environments/:env/clone", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	clone := func(source, target string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/repos/acme/svc/environments/"+source+"/clone", strings.NewReader(`{"target":"`+target+`"}`))
+		req.Header.Set("X-Session-ID", sessionID)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if status := clone("production", "acme/svc:staging-2"); status != http.StatusOK {
+		t.Fatalf("clone from production into staging: got %d, want 200", status)
+	}
+	if status := clone("staging", "acme/svc:production-2"); status != http.StatusForbidden {
+		t.Fatalf("clone into an environment the role can't write: got %d, want 403", status)
+	}
+
+	// Without RBAC, the protected target is still refused
+	activeRBAC = nil
+	if status := clone("staging", "acme/svc:production-2"); status != http.StatusConflict {
+		t.Fatalf("clone into a protected environment: got %d, want 409", status)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Environment cloning. Copies protection rules, deployment branch policies and
+// variables of an environment into another repository (or another environment of
+// the same repository). Secret values can't be read, so they are created from the
+// supplied value map, or from a placeholder when one is given. Keys the target
+// already has are kept unless the clone overwrites them.
+
+// CloneReport describes what a clone created at the target
+type CloneReport struct {
+	Source              string   `json:"source"`
+	Target              string   `json:"target"`
+	Settings            string   `json:"settings"`
+	Warnings            []string `json:"warnings"`
+	VariablesCopied     []string `json:"variables_copied"`
+	VariablesSkipped    []string `json:"variables_skipped"` // already at the target
+	SecretsSet          []string `json:"secrets_set"`
+	SecretsPlaceholder  []string `json:"secrets_placeholder"`
+	SecretsMissingValue []string `json:"secrets_missing_value"`
+	SecretsSkipped      []string `json:"secrets_skipped"` // already at the target
+	Errors              []string `json:"errors"`
+}
+
+// cloneEnvironment copies one environment to another. Secrets named in values are
+// written with that value, others with the placeholder, or skipped if there is none.
+// Keys the target already has are skipped unless overwrite is set. Nothing is
+// written when a key violates the policy for the target environment.
+func cloneEnvironment(client *github.Client, ctx context.Context, owner, repo, env, targetOwner, targetRepo, targetEnv string, values map[string]string, placeholder string, withoutReviewers, overwrite bool) (*CloneReport, []PolicyViolation, error) {
+	report := &CloneReport{
+		Source:              fmt.Sprintf("%s/%s:%s", owner, repo, env),
+		Target:              fmt.Sprintf("%s/%s:%s", targetOwner, targetRepo, targetEnv),
+		Warnings:            []string{},
+		VariablesCopied:     []string{},
+		VariablesSkipped:    []string{},
+		SecretsSet:          []string{},
+		SecretsPlaceholder:  []string{},
+		SecretsMissingValue: []string{},
+		SecretsSkipped:      []string{},
+		Errors:              []string{},
+	}
+
+	settings, err := fetchEnvironmentSettings(client, ctx, owner, repo, env)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceID, err := repositoryID(client, ctx, owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	variables, err := listEnvironmentVariables(client, ctx, owner, repo, env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list variables of %s: %v", report.Source, err)
+	}
+	secrets, err := listEnvironmentSecrets(client, ctx, sourceID, env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list secrets of %s: %v", report.Source, err)
+	}
+
+	violations := []PolicyViolation{}
+	for _, variable := range variables {
+		violations = append(violations, activePolicy.checkKey(targetEnv, "variable", variable.Name)...)
+	}
+	for _, secret := range secrets {
+		violations = append(violations, activePolicy.checkKey(targetEnv, "secret", secret.Name)...)
+	}
+	if len(violations) > 0 {
+		return nil, violations, nil
+	}
+
+	warnings, failed, err := applyEnvironmentSettings(client, ctx, targetOwner, targetRepo, targetEnv, settings, withoutReviewers)
+	report.Warnings = append(report.Warnings, warnings...)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Settings = "applied"
+	if len(failed) > 0 {
+		report.Settings = "partial"
+		report.Errors = append(report.Errors, failed...)
+	}
+
+	targetID, err := repositoryID(client, ctx, targetOwner, targetRepo)
+	if err != nil {
+		return nil, nil, err
+	}
+	existingVariables, err := listEnvironmentVariables(client, ctx, targetOwner, targetRepo, targetEnv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list variables of %s: %v", report.Target, err)
+	}
+	existingSecrets, err := listEnvironmentSecrets(client, ctx, targetID, targetEnv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list secrets of %s: %v", report.Target, err)
+	}
+
+	for _, variable := range variables {
+		if !overwrite && contains(variableNames(existingVariables), strings.ToUpper(variable.Name)) {
+			report.VariablesSkipped = append(report.VariablesSkipped, variable.Name)
+			continue
+		}
+		loc := KeyLocation{Scope: "environment", Type: "variable", Repo: targetOwner + "/" + targetRepo, Environment: targetEnv, Name: variable.Name}
+		if err := writeKey(client, ctx, loc, variable.Value); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", variable.Name, err))
+			continue
+		}
+		report.VariablesCopied = append(report.VariablesCopied, variable.Name)
+	}
+
+	for _, secret := range secrets {
+		if !overwrite && contains(secretNames(existingSecrets), strings.ToUpper(secret.Name)) {
+			report.SecretsSkipped = append(report.SecretsSkipped, secret.Name)
+			continue
+		}
+		value, supplied := values[secret.Name]
+		if !supplied {
+			if placeholder == "" {
+				report.SecretsMissingValue = append(report.SecretsMissingValue, secret.Name)
+				continue
+			}
+			value = placeholder
+		}
+
+		if err := putEnvironmentSecret(client, ctx, targetID, targetEnv, secret.Name, value); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", secret.Name, err))
+			continue
+		}
+		if supplied {
+			report.SecretsSet = append(report.SecretsSet, secret.Name)
+		} else {
+			report.SecretsPlaceholder = append(report.SecretsPlaceholder, secret.Name)
+		}
+	}
+
+	return report, nil, nil
+}
+
+func cloneEnvironmentHandler(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+	env := c.Param("env")
+
+	var req struct {
+		Target            string            `json:"target"`
+		Secrets           map[string]string `json:"secrets"`
+		SecretSource      *SecretSource     `json:"secret_source"`
+		SecretNames       []string          `json:"secret_names"`
+		SecretPlaceholder string            `json:"secret_placeholder"`
+		WithoutReviewers  bool              `json:"allow_without_reviewers"`
+		Overwrite         bool              `json:"overwrite"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	targetOwner, targetRepo, targetEnv, err := parseEnvTarget(req.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.EqualFold(targetOwner, owner) && strings.EqualFold(targetRepo, repo) && strings.EqualFold(targetEnv, env) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target environment are the same"})
+		return
+	}
+
+	// Values from a secret source fill in anything not given explicitly
+	values := req.Secrets
+	if req.SecretSource != nil {
+		resolved, err := resolveSecretValues(req.SecretSource, req.SecretNames)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to load secret values: %v", err)})
+			return
+		}
+		for name, value := range req.Secrets {
+			resolved[name] = value
+		}
+		values = resolved
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	report, violations, err := cloneEnvironment(client, ctx, owner, repo, env, targetOwner, targetRepo, targetEnv, values, req.SecretPlaceholder, req.WithoutReviewers, req.Overwrite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, report)
+}
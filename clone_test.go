@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// fakeCloneRepo serves acme/svc with a production environment to clone from and
+// a staging environment that already has API_URL and DB_PASSWORD, and records
+// every key written
+func fakeCloneRepo(t *testing.T) *[]string {
+	var mu sync.Mutex
+	writes := []string{}
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/repos/acme/svc":
+			json.NewEncoder(w).Encode(gin.H{"id": 1})
+		case r.Method == http.MethodPut && r.URL.Path == "/repos/acme/svc/environments/staging":
+			json.NewEncoder(w).Encode(gin.H{"name": "staging"})
+		case r.URL.Path == "/repos/acme/svc/environments/production":
+			json.NewEncoder(w).Encode(gin.H{"name": "production"})
+		case r.URL.Path == "/repos/acme/svc/environments/production/variables":
+			json.NewEncoder(w).Encode(gin.H{"total_count": 2, "variables": []gin.H{{"name": "API_URL", "value": "https://prod"}, {"name": "LOG_LEVEL", "value": "warn"}}})
+		case r.URL.Path == "/repositories/1/environments/production/secrets":
+			json.NewEncoder(w).Encode(gin.H{"total_count": 2, "secrets": []gin.H{{"name": "DB_PASSWORD"}, {"name": "API_KEY"}}})
+		case r.URL.Path == "/repos/acme/svc/environments/staging/variables" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(gin.H{"total_count": 1, "variables": []gin.H{{"name": "api_url", "value": "https://staging"}}})
+		case r.URL.Path == "/repositories/1/environments/staging/secrets":
+			json.NewEncoder(w).Encode(gin.H{"total_count": 1, "secrets": []gin.H{{"name": "DB_PASSWORD"}}})
+		case r.URL.Path == "/repositories/1/environments/staging/secrets/public-key":
+			json.NewEncoder(w).Encode(gin.H{"key_id": "k1", "key": base64.StdEncoding.EncodeToString(make([]byte, 32))})
+		case r.Method != http.MethodGet:
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return &writes
+}
+
+func TestCloneKeepsExistingTargetKeys(t *testing.T) {
+	writes := fakeCloneRepo(t)
+	client := github.NewClient(nil).WithAuthToken("token")
+	values := map[string]string{"DB_PASSWORD": "new", "API_KEY": "key"}
+
+	report, _, err := cloneEnvironment(client, context.Background(), "acme", "svc", "production", "acme", "svc", "staging", values, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.VariablesSkipped, []string{"API_URL"}) || !reflect.DeepEqual(report.SecretsSkipped, []string{"DB_PASSWORD"}) {
+		t.Errorf("expected API_URL and DB_PASSWORD to be skipped, got %v and %v", report.VariablesSkipped, report.SecretsSkipped)
+	}
+	if !reflect.DeepEqual(report.VariablesCopied, []string{"LOG_LEVEL"}) || !reflect.DeepEqual(report.SecretsSet, []string{"API_KEY"}) {
+		t.Errorf("expected LOG_LEVEL and API_KEY to be written, got %v and %v", report.VariablesCopied, report.SecretsSet)
+	}
+	for _, write := range *writes {
+		if strings.Contains(write, "API_URL") || strings.Contains(write, "DB_PASSWORD") {
+			t.Errorf("existing key written: %s", write)
+		}
+	}
+
+	*writes = nil
+	report, _, err = cloneEnvironment(client, context.Background(), "acme", "svc", "production", "acme", "svc", "staging", values, "", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := append(report.VariablesCopied, report.SecretsSet...)
+	sort.Strings(copied)
+	if len(report.VariablesSkipped)+len(report.SecretsSkipped) != 0 || !reflect.DeepEqual(copied, []string{"API_KEY", "API_URL", "DB_PASSWORD", "LOG_LEVEL"}) {
+		t.Errorf("overwrite: copied %v, skipped %v and %v", copied, report.VariablesSkipped, report.SecretsSkipped)
+	}
+}
+
+func TestCloneRefusesTheSameEnvironment(t *testing.T) {
+	sessionID := testSession(t, "octocat", "token")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/repos/acme/svc/environments/production/clone", strings.NewReader(`{"target":"Acme/SVC:Production"}`))
+	c.Request.Header.Set("X-Session-ID", sessionID)
+	c.Params = gin.Params{{Key: "owner", Value: "acme"}, {Key: "repo", Value: "svc"}, {Key: "env", Value: "production"}}
+	cloneEnvironmentHandler(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d %s", w.Code, w.Body)
+	}
+}
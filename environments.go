@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Environment protection settings in a form that can be copied between
+// repositories, stored locally and rendered into other formats.
+
+// EnvironmentReviewer is a user or team that must approve deployments
+type EnvironmentReviewer struct {
+	Type  string `json:"type" yaml:"type"` // "User" or "Team"
+	ID    int64  `json:"id" yaml:"id"`
+	Login string `json:"login,omitempty" yaml:"login,omitempty"`
+}
+
+// BranchPolicyRule is one custom deployment branch or tag pattern
+type BranchPolicyRule struct {
+	Name string `json:"name" yaml:"name"`
+	Type string `json:"type,omitempty" yaml:"type,omitempty"` // "branch" or "tag"
+}
+
+// EnvironmentSettings holds the protection rules and deployment branch policy of an environment
+type EnvironmentSettings struct {
+	WaitTimer            int                   `json:"wait_timer" yaml:"wait_timer"`
+	PreventSelfReview    bool                  `json:"prevent_self_review" yaml:"prevent_self_review"`
+	CanAdminsBypass      bool                  `json:"can_admins_bypass" yaml:"can_admins_bypass"`
+	Reviewers            []EnvironmentReviewer `json:"reviewers" yaml:"reviewers"`
+	ProtectedBranches    bool                  `json:"protected_branches" yaml:"protected_branches"`
+	CustomBranchPolicies bool                  `json:"custom_branch_policies" yaml:"custom_branch_policies"`
+	BranchPolicies       []BranchPolicyRule    `json:"branch_policies" yaml:"branch_policies"`
+}
+
+// fetchEnvironmentSettings reads the protection rules and branch policies of an environment
+func fetchEnvironmentSettings(client *github.Client, ctx context.Context, owner, repo, env string) (*EnvironmentSettings, error) {
+	environment, _, err := client.Repositories.GetEnvironment(ctx, owner, repo, env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment %s: %v", env, err)
+	}
+
+	settings := &EnvironmentSettings{
+		CanAdminsBypass: environment.GetCanAdminsBypass(),
+		Reviewers:       []EnvironmentReviewer{},
+		BranchPolicies:  []BranchPolicyRule{},
+	}
+
+	for _, rule := range environment.ProtectionRules {
+		switch rule.GetType() {
+		case "wait_timer":
+			settings.WaitTimer = rule.GetWaitTimer()
+		case "required_reviewers":
+			settings.PreventSelfReview = rule.GetPreventSelfReview()
+			for _, reviewer := range rule.Reviewers {
+				switch r := reviewer.Reviewer.(type) {
+				case *github.User:
+					settings.Reviewers = append(settings.Reviewers, EnvironmentReviewer{Type: "User", ID: r.GetID(), Login: r.GetLogin()})
+				case *github.Team:
+					settings.Reviewers = append(settings.Reviewers, EnvironmentReviewer{Type: "Team", ID: r.GetID(), Login: r.GetSlug()})
+				}
+			}
+		}
+	}
+
+	if policy := environment.DeploymentBranchPolicy; policy != nil {
+		settings.ProtectedBranches = policy.GetProtectedBranches()
+		settings.CustomBranchPolicies = policy.GetCustomBranchPolicies()
+	}
+
+	if settings.CustomBranchPolicies {
+		policies, _, err := client.Repositories.ListDeploymentBranchPolicies(ctx, owner, repo, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployment branch policies: %v", err)
+		}
+		for _, policy := range policies.BranchPolicies {
+			settings.BranchPolicies = append(settings.BranchPolicies, BranchPolicyRule{Name: policy.GetName(), Type: policy.GetType()})
+		}
+	}
+
+	return settings, nil
+}
+
+// isPlanLimitation reports whether GitHub refused protection rules because the
+// repository's plan doesn't include them (e.g. private repositories on Free)
+func isPlanLimitation(err error) bool {
+	var ghErr *github.ErrorResponse
+	if !errors.As(err, &ghErr) || ghErr.Response == nil || ghErr.Response.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	return strings.Contains(strings.ToLower(ghErr.Message), "plan")
+}
+
+// applyEnvironmentSettings creates or updates an environment with the given settings.
+// When the target's plan doesn't support required reviewers the environment is
+// only created without them if withoutReviewers allows it; any other error fails
+// it. Branch policies that could not be created are returned separately, since
+// the environment then exists but is less protected than asked for.
+func applyEnvironmentSettings(client *github.Client, ctx context.Context, owner, repo, env string, settings *EnvironmentSettings, withoutReviewers bool) ([]string, []string, error) {
+	var warnings []string
+	var failed []string
+
+	request := &github.CreateUpdateEnvironment{
+		WaitTimer:         github.Ptr(settings.WaitTimer),
+		CanAdminsBypass:   github.Ptr(settings.CanAdminsBypass),
+		PreventSelfReview: github.Ptr(settings.PreventSelfReview),
+	}
+	for _, reviewer := range settings.Reviewers {
+		request.Reviewers = append(request.Reviewers, &github.EnvReviewers{
+			Type: github.Ptr(reviewer.Type),
+			ID:   github.Ptr(reviewer.ID),
+		})
+	}
+	if settings.ProtectedBranches || settings.CustomBranchPolicies {
+		request.DeploymentBranchPolicy = &github.BranchPolicy{
+			ProtectedBranches:    github.Ptr(settings.ProtectedBranches),
+			CustomBranchPolicies: github.Ptr(settings.CustomBranchPolicies),
+		}
+	}
+
+	_, _, err := client.Repositories.CreateUpdateEnvironment(ctx, owner, repo, env, request)
+	if err != nil && len(request.Reviewers) > 0 && isPlanLimitation(err) {
+		if !withoutReviewers {
+			return warnings, nil, fmt.Errorf("the plan of %s/%s doesn't support required reviewers for %s (allow creating it without reviewers to go ahead): %v", owner, repo, env, err)
+		}
+		warnings = append(warnings, fmt.Sprintf("reviewers are not supported by the plan and were skipped: %v", err))
+		request.Reviewers = nil
+		request.PreventSelfReview = nil
+		_, _, err = client.Repositories.CreateUpdateEnvironment(ctx, owner, repo, env, request)
+	}
+	if err != nil {
+		return warnings, nil, fmt.Errorf("failed to create environment %s: %v", env, err)
+	}
+
+	if settings.CustomBranchPolicies {
+		existing := []string{}
+		if current, _, err := client.Repositories.ListDeploymentBranchPolicies(ctx, owner, repo, env); err == nil {
+			for _, policy := range current.BranchPolicies {
+				existing = append(existing, policy.GetType()+":"+policy.GetName())
+			}
+		}
+
+		for _, policy := range settings.BranchPolicies {
+			policyType := policy.Type
+			if policyType == "" {
+				policyType = "branch"
+			}
+			if contains(existing, policyType+":"+policy.Name) {
+				continue
+			}
+			_, _, err := client.Repositories.CreateDeploymentBranchPolicy(ctx, owner, repo, env, &github.DeploymentBranchPolicyRequest{
+				Name: github.Ptr(policy.Name),
+				Type: github.Ptr(policyType),
+			})
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("failed to create %s policy %s: %v", policyType, policy.Name, err))
+			}
+		}
+	}
+
+	return warnings, failed, nil
+}
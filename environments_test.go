@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// fakeEnvironmentAPI refuses environments with reviewers with the given 422
+// message and fails every branch policy; it records each PUT body
+func fakeEnvironmentAPI(t *testing.T, reviewerError string) *[]map[string]interface{} {
+	puts := []map[string]interface{}{}
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/repos/acme/svc/environments/production":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			puts = append(puts, body)
+			if _, ok := body["reviewers"]; ok {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]string{"message": reviewerError})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"name": "production"})
+		case r.URL.Path == "/repos/acme/svc/environments/production/deployment-branch-policies" && r.Method == "GET":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 0, "branch_policies": []interface{}{}})
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	return &puts
+}
+
+// allWithReviewers reports whether every PUT still asked for the reviewers
+func allWithReviewers(puts []map[string]interface{}) bool {
+	for _, body := range puts {
+		if _, ok := body["reviewers"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var protectedSettings = &EnvironmentSettings{
+	Reviewers:            []EnvironmentReviewer{{Type: "Team", ID: 7}},
+	CustomBranchPolicies: true,
+	BranchPolicies:       []BranchPolicyRule{{Name: "main"}},
+}
+
+func TestEnvironmentSettingsNeedConsentToDropReviewers(t *testing.T) {
+	puts := fakeEnvironmentAPI(t, "Failed to create the environment protection rule. Please ensure the billing plan include the required protection rules.")
+	client := github.NewClient(nil).WithAuthToken("token")
+
+	if _, _, err := applyEnvironmentSettings(client, context.Background(), "acme", "svc", "production", protectedSettings, false); err == nil || !strings.Contains(err.Error(), "reviewers") {
+		t.Fatalf("expected the plan limitation to fail without consent, got %v", err)
+	}
+	if !allWithReviewers(*puts) {
+		t.Fatalf("expected no retry without reviewers, got %v", *puts)
+	}
+
+	warnings, failed, err := applyEnvironmentSettings(client, context.Background(), "acme", "svc", "production", protectedSettings, true)
+	if err != nil || len(warnings) != 1 || allWithReviewers(*puts) {
+		t.Fatalf("expected a retry without reviewers, got %v, %v after %v", warnings, err, *puts)
+	}
+	if len(failed) != 1 || !strings.Contains(failed[0], "main") {
+		t.Fatalf("expected the failed branch policy to be reported, got %v", failed)
+	}
+}
+
+func TestEnvironmentSettingsOnlyRetryPlanLimitations(t *testing.T) {
+	puts := fakeEnvironmentAPI(t, "Validation Failed: team 7 not found")
+	client := github.NewClient(nil).WithAuthToken("token")
+
+	if _, _, err := applyEnvironmentSettings(client, context.Background(), "acme", "svc", "production", protectedSettings, true); err == nil {
+		t.Fatalf("expected an unknown reviewer to fail the environment")
+	}
+	if !allWithReviewers(*puts) {
+		t.Fatalf("expected no retry without reviewers for a non-plan error, got %v", *puts)
+	}
+}
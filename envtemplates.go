@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Environment templates for bootstrapping repositories. A template names the
+// environments a repository should have, their protection settings, the
+// variables they need (with defaults) and the secrets they need. Applying it
+// creates what is missing and reports the secrets that still need a value.
+
+// TemplateEnvironment is one environment of a template
+type TemplateEnvironment struct {
+	Name      string               `json:"name"`
+	Settings  *EnvironmentSettings `json:"settings,omitempty"`
+	Variables map[string]string    `json:"variables,omitempty"` // required keys with defaults, "" for no default
+	Secrets   []string             `json:"secrets,omitempty"`   // required secret names
+}
+
+// EnvironmentTemplate is a named set of environments
+type EnvironmentTemplate struct {
+	Name         string                `json:"name"`
+	Description  string                `json:"description,omitempty"`
+	Environments []TemplateEnvironment `json:"environments"`
+	UpdatedBy    string                `json:"updated_by,omitempty"`
+	UpdatedAt    string                `json:"updated_at,omitempty"`
+}
+
+// validate checks the template name and its environment names, and uppercases
+// the key names like GitHub does
+func (t *EnvironmentTemplate) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if len(t.Environments) == 0 {
+		return fmt.Errorf("a template needs at least one environment")
+	}
+	seen := []string{}
+	for i, env := range t.Environments {
+		if !isValidEnvironmentName(env.Name) {
+			return fmt.Errorf("invalid environment name %q", env.Name)
+		}
+		if contains(seen, env.Name) {
+			return fmt.Errorf("environment %s is listed twice", env.Name)
+		}
+		seen = append(seen, env.Name)
+
+		variables, err := normalizeKeyValues("variable", env.Variables)
+		if err != nil {
+			return fmt.Errorf("%s: %v", env.Name, err)
+		}
+		secrets, err := normalizeKeyNames("secret", env.Secrets, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %v", env.Name, err)
+		}
+		t.Environments[i].Variables = variables
+		t.Environments[i].Secrets = secrets
+	}
+	return nil
+}
+
+func (t *EnvironmentTemplate) environment(name string) *TemplateEnvironment {
+	for i := range t.Environments {
+		if t.Environments[i].Name == name {
+			return &t.Environments[i]
+		}
+	}
+	return nil
+}
+
+func findEnvironmentTemplate(templates []EnvironmentTemplate, name string) *EnvironmentTemplate {
+	for i := range templates {
+		if templates[i].Name == name {
+			return &templates[i]
+		}
+	}
+	return nil
+}
+
+// loadEnvironmentTemplate returns a copy of a stored template
+func loadEnvironmentTemplate(name string) *EnvironmentTemplate {
+	var template *EnvironmentTemplate
+	store.view(func(data *StoreData) {
+		if stored := findEnvironmentTemplate(data.EnvironmentTemplates, name); stored != nil {
+			copied := *stored
+			template = &copied
+		}
+	})
+	return template
+}
+
+// TemplateKeyResult is one variable or secret of an applied template
+type TemplateKeyResult struct {
+	Environment string `json:"environment"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Action      string `json:"action"` // "create", "update", "keep", "missing"
+	Status      string `json:"status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// applyTemplateEnvironment creates or updates one environment of a template and
+// writes its required variables. values overrides the template defaults and
+// secrets supplies secret values; required keys without a value are reported as
+// "missing". Settings of an existing environment are only replaced when the
+// template defines some, so reviewers, wait timers and branch policies set up by
+// hand survive; the returned action says which happened ("create", "update" or
+// "keep").
+func applyTemplateEnvironment(client *github.Client, ctx context.Context, owner, repo string, env TemplateEnvironment, values, secrets map[string]string, overwrite, dryRun bool) ([]TemplateKeyResult, string, []string, error) {
+	warnings := []string{}
+
+	settingsAction := "keep"
+	_, _, err := client.Repositories.GetEnvironment(ctx, owner, repo, env.Name)
+	switch {
+	case isNotFound(err):
+		settingsAction = "create"
+	case err != nil:
+		return nil, "", warnings, err
+	case env.Settings != nil:
+		settingsAction = "update"
+	}
+
+	if !dryRun && settingsAction != "keep" {
+		settings := env.Settings
+		if settings == nil {
+			settings = &EnvironmentSettings{}
+		}
+		settingWarnings, failed, err := applyEnvironmentSettings(client, ctx, owner, repo, env.Name, settings, false)
+		warnings = append(warnings, settingWarnings...)
+		warnings = append(warnings, failed...)
+		if err != nil {
+			return nil, settingsAction, warnings, err
+		}
+	}
+
+	// A new environment has no keys yet; only existing ones can be read
+	existingVariables, err := listEnvironmentVariables(client, ctx, owner, repo, env.Name)
+	if err != nil {
+		return nil, settingsAction, warnings, err
+	}
+	repoID, err := repositoryID(client, ctx, owner, repo)
+	if err != nil {
+		return nil, settingsAction, warnings, err
+	}
+	existingSecrets, err := listEnvironmentSecrets(client, ctx, repoID, env.Name)
+	if err != nil {
+		return nil, settingsAction, warnings, err
+	}
+
+	results := []TemplateKeyResult{}
+	names := make([]string, 0, len(env.Variables))
+	for name := range env.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			value = env.Variables[name]
+		}
+
+		result := TemplateKeyResult{Environment: env.Name, Type: "variable", Name: name, Action: "create"}
+		for _, variable := range existingVariables {
+			if strings.EqualFold(variable.Name, name) {
+				result.Action = "keep"
+				if overwrite && variable.Value != value && value != "" {
+					result.Action = "update"
+				}
+			}
+		}
+		if result.Action == "create" && value == "" {
+			result.Action = "missing"
+		}
+		results = append(results, writeTemplateKey(client, ctx, owner, repo, result, value, dryRun))
+	}
+
+	for _, name := range env.Secrets {
+		value, supplied := secrets[name]
+		result := TemplateKeyResult{Environment: env.Name, Type: "secret", Name: name, Action: "missing"}
+		exists := false
+		for _, secret := range existingSecrets {
+			exists = exists || strings.EqualFold(secret.Name, name)
+		}
+		switch {
+		case exists && (!supplied || !overwrite):
+			result.Action = "keep"
+		case exists:
+			result.Action = "update"
+		case supplied:
+			result.Action = "create"
+		}
+		results = append(results, writeTemplateKey(client, ctx, owner, repo, result, value, dryRun))
+	}
+
+	return results, settingsAction, warnings, nil
+}
+
+func writeTemplateKey(client *github.Client, ctx context.Context, owner, repo string, result TemplateKeyResult, value string, dryRun bool) TemplateKeyResult {
+	if dryRun || (result.Action != "create" && result.Action != "update") {
+		return result
+	}
+	loc := KeyLocation{Scope: "environment", Type: result.Type, Repo: owner + "/" + repo, Environment: result.Environment, Name: result.Name}
+	if err := writeKey(client, ctx, loc, value); err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = "applied"
+	return result
+}
+
+func listEnvironmentTemplates(c *gin.Context) {
+	if _, err := getAuthenticatedUser(c); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	templates := []EnvironmentTemplate{}
+	store.view(func(data *StoreData) {
+		templates = append(templates, data.EnvironmentTemplates...)
+	})
+
+	c.JSON(http.StatusOK, templates)
+}
+
+func putEnvironmentTemplate(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req EnvironmentTemplate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Name = c.Param("name")
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Required keys must be valid for the environments they are required in
+	violations := []PolicyViolation{}
+	for _, env := range req.Environments {
+		for name := range env.Variables {
+			violations = append(violations, activePolicy.checkKey(env.Name, "variable", name)...)
+		}
+		for _, name := range env.Secrets {
+			violations = append(violations, activePolicy.checkKey(env.Name, "secret", name)...)
+		}
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	req.UpdatedBy = user.Login
+	req.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+
+	err = store.update(func(data *StoreData) error {
+		if existing := findEnvironmentTemplate(data.EnvironmentTemplates, req.Name); existing != nil {
+			*existing = req
+			return nil
+		}
+		data.EnvironmentTemplates = append(data.EnvironmentTemplates, req)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save template: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func deleteEnvironmentTemplate(c *gin.Context) {
+	if _, err := getAuthenticatedUser(c); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	name := c.Param("name")
+	found := false
+	err := store.update(func(data *StoreData) error {
+		kept := data.EnvironmentTemplates[:0]
+		for _, template := range data.EnvironmentTemplates {
+			if template.Name == name {
+				found = true
+				continue
+			}
+			kept = append(kept, template)
+		}
+		data.EnvironmentTemplates = kept
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete template: %v", err)})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found: " + name})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
+
+func applyEnvironmentTemplate(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	var req struct {
+		Template     string                       `json:"template"`
+		Environments []string                     `json:"environments"` // default all of the template
+		Variables    map[string]map[string]string `json:"variables"`    // env -> name -> value
+		Secrets      map[string]map[string]string `json:"secrets"`      // env -> name -> value
+		Overwrite    bool                         `json:"overwrite"`
+		DryRun       bool                         `json:"dry_run"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template := loadEnvironmentTemplate(req.Template)
+	if template == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found: " + req.Template})
+		return
+	}
+	// Templates saved before key names were normalized get the same treatment,
+	// and so do the values of the request
+	if err := template.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for env, values := range req.Variables {
+		if req.Variables[env], err = normalizeKeyValues("variable", values); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", env, err)})
+			return
+		}
+	}
+	for env, values := range req.Secrets {
+		if req.Secrets[env], err = normalizeKeyValues("secret", values); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", env, err)})
+			return
+		}
+	}
+	for _, env := range req.Environments {
+		if template.environment(env) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Template %s has no environment %s", template.Name, env)})
+			return
+		}
+	}
+
+	// The policy may have changed since the template was saved
+	violations := []PolicyViolation{}
+	for _, env := range template.Environments {
+		if len(req.Environments) > 0 && !contains(req.Environments, env.Name) {
+			continue
+		}
+		for name := range env.Variables {
+			violations = append(violations, activePolicy.checkKey(env.Name, "variable", name)...)
+		}
+		for _, name := range env.Secrets {
+			violations = append(violations, activePolicy.checkKey(env.Name, "secret", name)...)
+		}
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	// Without a list the request names no environments, so check the template's
+	if !req.DryRun && len(req.Environments) == 0 {
+		targets := []RBACTarget{}
+		for _, env := range template.Environments {
+			targets = append(targets, RBACTarget{Org: owner, Repo: owner + "/" + repo, Environment: env.Name})
+		}
+		if !authorizeWrite(c, user, targets) {
+			return
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	results := []TemplateKeyResult{}
+	environments := []gin.H{}
+	warnings := []string{}
+	errors := []string{}
+	for _, env := range template.Environments {
+		if len(req.Environments) > 0 && !contains(req.Environments, env.Name) {
+			continue
+		}
+
+		envResults, settingsAction, envWarnings, err := applyTemplateEnvironment(client, ctx, owner, repo, env, req.Variables[env.Name], req.Secrets[env.Name], req.Overwrite, req.DryRun)
+		for _, warning := range envWarnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", env.Name, warning))
+		}
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", env.Name, err))
+			environments = append(environments, gin.H{"name": env.Name, "status": "failed", "settings": settingsAction})
+			continue
+		}
+		environments = append(environments, gin.H{"name": env.Name, "status": "ready", "settings": settingsAction})
+		results = append(results, envResults...)
+	}
+
+	missingSecrets := []TemplateKeyResult{}
+	missingVariables := []TemplateKeyResult{}
+	for _, result := range results {
+		if result.Error != "" {
+			errors = append(errors, fmt.Sprintf("%s %s %s: %s", result.Environment, result.Type, result.Name, result.Error))
+		}
+		switch {
+		case result.Action == "missing" && result.Type == "secret":
+			missingSecrets = append(missingSecrets, result)
+		case result.Action == "missing":
+			missingVariables = append(missingVariables, result)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"template":                 template.Name,
+		"environments":             environments,
+		"keys":                     results,
+		"secrets_needing_values":   missingSecrets,
+		"variables_needing_values": missingVariables,
+		"warnings":                 warnings,
+		"errors":                   errors,
+	})
+}
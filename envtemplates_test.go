@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// fakeEnvironments serves the environments named in existing as empty
+// environments of acme/svc and records every settings PUT
+func fakeEnvironments(t *testing.T, existing ...string) *[]string {
+	var mu sync.Mutex
+	puts := []string{}
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/repos/acme/svc":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 1})
+		case r.Method == "PUT" && len(r.URL.Path) > len("/repos/acme/svc/environments/"):
+			puts = append(puts, r.URL.Path)
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "x"})
+		case r.Method == "GET":
+			for _, env := range existing {
+				switch r.URL.Path {
+				case "/repos/acme/svc/environments/" + env:
+					json.NewEncoder(w).Encode(map[string]interface{}{"name": env})
+					return
+				case "/repos/acme/svc/environments/" + env + "/variables":
+					json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 0, "variables": []interface{}{}})
+					return
+				case "/repositories/1/environments/" + env + "/secrets":
+					json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 0, "secrets": []interface{}{}})
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return &puts
+}
+
+func TestTemplateKeepsSettingsOfExistingEnvironments(t *testing.T) {
+	puts := fakeEnvironments(t, "production")
+	client := github.NewClient(nil).WithAuthToken("token")
+	ctx := context.Background()
+
+	// No settings in the template: an existing environment is left alone
+	_, action, _, err := applyTemplateEnvironment(client, ctx, "acme", "svc", TemplateEnvironment{Name: "production"}, nil, nil, false, false)
+	if err != nil || action != "keep" || len(*puts) != 0 {
+		t.Fatalf("expected production to keep its settings, got %q, %v, PUTs %v", action, err, *puts)
+	}
+
+	// Settings in the template replace the existing ones
+	withSettings := TemplateEnvironment{Name: "production", Settings: &EnvironmentSettings{WaitTimer: 5}}
+	if _, action, _, err = applyTemplateEnvironment(client, ctx, "acme", "svc", withSettings, nil, nil, false, true); err != nil || action != "update" || len(*puts) != 0 {
+		t.Fatalf("dry run: got %q, %v, PUTs %v", action, err, *puts)
+	}
+	if _, action, _, err = applyTemplateEnvironment(client, ctx, "acme", "svc", withSettings, nil, nil, false, false); err != nil || action != "update" || len(*puts) != 1 {
+		t.Fatalf("expected production settings to be updated, got %q, %v, PUTs %v", action, err, *puts)
+	}
+}
+
+func TestTemplateCreatesMissingEnvironments(t *testing.T) {
+	puts := fakeEnvironments(t)
+	client := github.NewClient(nil).WithAuthToken("token")
+
+	_, action, _, _ := applyTemplateEnvironment(client, context.Background(), "acme", "svc", TemplateEnvironment{Name: "staging"}, nil, nil, false, true)
+	if action != "create" || len(*puts) != 0 {
+		t.Fatalf("dry run: expected staging to be reported as created without writing, got %q, PUTs %v", action, *puts)
+	}
+	applyTemplateEnvironment(client, context.Background(), "acme", "svc", TemplateEnvironment{Name: "staging"}, nil, nil, false, false)
+	if len(*puts) != 1 || (*puts)[0] != "/repos/acme/svc/environments/staging" {
+		t.Fatalf("expected staging to be created, got PUTs %v", *puts)
+	}
+}
+
+func TestTemplateKeyNamesAreNormalized(t *testing.T) {
+	template := EnvironmentTemplate{Name: "service", Environments: []TemplateEnvironment{
+		{Name: "staging", Variables: map[string]string{"api_url": "https://api"}, Secrets: []string{"db_password", "DB_PASSWORD"}},
+	}}
+	if err := template.validate(); err != nil {
+		t.Fatal(err)
+	}
+	env := template.Environments[0]
+	if _, ok := env.Variables["API_URL"]; !ok || len(env.Variables) != 1 || len(env.Secrets) != 1 || env.Secrets[0] != "DB_PASSWORD" {
+		t.Fatalf("expected uppercased names, got %+v", env)
+	}
+
+	for _, env := range []TemplateEnvironment{
+		{Name: "staging", Variables: map[string]string{"api-url": ""}},
+		{Name: "staging", Variables: map[string]string{"api_url": "a", "API_URL": "b"}},
+		{Name: "staging", Secrets: []string{"GITHUB_TOKEN"}},
+	} {
+		template := EnvironmentTemplate{Name: "service", Environments: []TemplateEnvironment{env}}
+		if err := template.validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", env)
+		}
+	}
+}
+
+func TestTemplateKeepsExistingKeysWhateverTheirCase(t *testing.T) {
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/acme/svc":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 1})
+		case "/repos/acme/svc/environments/staging":
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "staging"})
+		case "/repos/acme/svc/environments/staging/variables":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 1, "variables": []interface{}{map[string]string{"name": "API_URL", "value": "https://live"}}})
+		case "/repositories/1/environments/staging/secrets":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 1, "secrets": []interface{}{map[string]string{"name": "DB_PASSWORD"}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	client := github.NewClient(nil).WithAuthToken("token")
+
+	// A template saved before names were normalized
+	env := TemplateEnvironment{Name: "staging", Variables: map[string]string{"api_url": "https://default"}, Secrets: []string{"db_password"}}
+	results, _, _, err := applyTemplateEnvironment(client, context.Background(), "acme", "svc", env, nil, map[string]string{"db_password": "new"}, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Action != "keep" {
+			t.Errorf("expected the live %s %s to be kept, got %q", result.Type, result.Name, result.Action)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Portable export bundles: the variables of a set of repositories and environments
+// plus secret values supplied by the user, encrypted with a passphrase or to age
+// recipients. The import side decrypts a bundle and writes it back, optionally to
+// different targets.
+
+func exportBundle(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Targets []string                     `json:"targets"`
+		Secrets map[string]map[string]string `json:"secrets"` // target -> name -> value
+		BundleKeys
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one target is required"})
+		return
+	}
+	if (req.Passphrase == "") == (len(req.Recipients) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either a passphrase or age recipients"})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	payload := &BundlePayload{
+		CreatedAt: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		CreatedBy: user.Login,
+	}
+	summary := []gin.H{}
+	withoutValue := []string{}
+	errors := []string{}
+
+	for _, target := range req.Targets {
+		variables, secrets, err := listTargetKeys(client, ctx, target)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+
+		scope := BundleScope{Target: target, Variables: map[string]string{}, Secrets: map[string]string{}}
+		for _, variable := range variables {
+			scope.Variables[variable.Name] = variable.Value
+		}
+
+		// Secrets can only be exported with a value supplied by the caller
+		supplied := req.Secrets[target]
+		for _, secret := range secrets {
+			if value, ok := supplied[secret.Name]; ok {
+				scope.Secrets[secret.Name] = value
+			} else {
+				withoutValue = append(withoutValue, fmt.Sprintf("%s %s", target, secret.Name))
+			}
+		}
+		for name, value := range supplied {
+			scope.Secrets[name] = value
+		}
+
+		payload.Scopes = append(payload.Scopes, scope)
+		summary = append(summary, gin.H{"target": target, "variables": len(scope.Variables), "secrets": len(scope.Secrets)})
+	}
+
+	if len(payload.Scopes) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Nothing could be exported", "errors": errors})
+		return
+	}
+
+	sealed, err := sealBundle(payload, req.BundleKeys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to encrypt bundle: %v", err)})
+		return
+	}
+
+	encryption, extension := "passphrase", "json"
+	if len(req.Recipients) > 0 {
+		encryption, extension = "age", "age"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bundle":                base64.StdEncoding.EncodeToString(sealed),
+		"filename":              fmt.Sprintf("github-env-bundle-%s.%s", time.Now().UTC().Format("20060102-150405"), extension),
+		"encryption":            encryption,
+		"scopes":                summary,
+		"secrets_without_value": withoutValue,
+		"errors":                errors,
+	})
+}
+
+func importBundle(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Bundle    string            `json:"bundle"`     // base64
+		TargetMap map[string]string `json:"target_map"` // bundle target -> new target
+		Only      []string          `json:"only"`       // bundle targets to import, default all
+		Overwrite bool              `json:"overwrite"`
+		DryRun    bool              `json:"dry_run"`
+		BundleKeys
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Bundle)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bundle must be base64 encoded"})
+		return
+	}
+	payload, err := openBundle(data, req.BundleKeys)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to open bundle: %v", err)})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	// Plan every write against the current state of the targets
+	planned := []plannedKeyWrite{}
+	violations := []PolicyViolation{}
+	errors := []string{}
+	for _, scope := range payload.Scopes {
+		if len(req.Only) > 0 && !contains(req.Only, scope.Target) {
+			continue
+		}
+		target := scope.Target
+		if mapped, ok := req.TargetMap[target]; ok {
+			target = mapped
+		}
+
+		keys, scopeViolations, err := planKeyWrites(client, ctx, target, scope.Variables, scope.Secrets, req.Overwrite)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+		planned = append(planned, keys...)
+		violations = append(violations, scopeViolations...)
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"created_at": payload.CreatedAt,
+			"created_by": payload.CreatedBy,
+			"changes":    plannedChanges(planned),
+			"violations": violations,
+			"errors":     errors,
+		})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	// Bundle targets are only known once the bundle is open
+	locations := make([]KeyLocation, len(planned))
+	for i, write := range planned {
+		locations[i] = write.loc
+	}
+	if !authorizeWrite(c, user, locationTargets(locations)) {
+		return
+	}
+
+	changes, applied, writeErrors := applyKeyWrites(client, ctx, planned)
+	errors = append(errors, writeErrors...)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("Imported %d keys", applied),
+		"applied_count": applied,
+		"changes":       changes,
+		"errors":        errors,
+	})
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"github.com/sirupsen/logrus"
+)
+
+// GitHub App mode. Users still log in with their own tokens, which are used for
+// reads, but writes to repositories and organizations where the app is installed
+// go out with an installation token instead. The swap happens in the HTTP
+// transport so every handler gets it. A write only goes through the app when the
+// user's own role allows it (write for repositories, admin for environments and
+// organizations); otherwise the user's token is sent and GitHub decides. Every
+// write made through the app is recorded with the user it was made for.
+
+var (
+	appID             int64
+	appPrivateKeyFile = ""
+	// githubAPIHost is the API host whose requests the app transport handles
+	githubAPIHost = "api.github.com"
+)
+
+// maxAppAuditEntries caps the audit trail in the local store
+const maxAppAuditEntries = 5000
+
+// installationCacheTTL is how long an installation lookup is trusted, so an app
+// installed (or removed) while the server runs is picked up
+const installationCacheTTL = 10 * time.Minute
+
+// activeApp is the configured GitHub App, nil outside app mode
+var activeApp *githubApp
+
+// AppAuditEntry is one write made through the app
+type AppAuditEntry struct {
+	At             string `json:"at"`
+	OnBehalfOf     string `json:"on_behalf_of"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	Status         int    `json:"status"`
+	InstallationID int64  `json:"installation_id"`
+}
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+type cachedPermission struct {
+	at      time.Time
+	allowed bool
+}
+
+type cachedInstallation struct {
+	at time.Time
+	id int64
+}
+
+type githubApp struct {
+	id   int64
+	key  *rsa.PrivateKey
+	base http.RoundTripper // transport of the app's own requests
+
+	mu            sync.Mutex
+	tokens        map[int64]installationToken
+	installations map[string]cachedInstallation // scope -> installation, 0 when not installed
+	permissions   map[string]cachedPermission
+}
+
+// loadGitHubApp reads the private key of an app
+func loadGitHubApp(id int64, keyFile string) (*githubApp, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", keyFile)
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			var ok bool
+			if key, ok = parsed.(*rsa.PrivateKey); !ok {
+				err = fmt.Errorf("not an RSA key")
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	return &githubApp{
+		id:            id,
+		key:           key,
+		base:          http.DefaultTransport,
+		tokens:        make(map[int64]installationToken),
+		installations: make(map[string]cachedInstallation),
+		permissions:   make(map[string]cachedPermission),
+	}, nil
+}
+
+// jwt mints the token the app authenticates as itself with. It's backdated a
+// minute against clock drift and valid for the maximum of ten minutes.
+func (a *githubApp) jwt() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.id, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// client returns a client authenticated as the app itself
+func (a *githubApp) client() (*github.Client, error) {
+	jwt, err := a.jwt()
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(&http.Client{Transport: a.base}).WithAuthToken(jwt), nil
+}
+
+// userClient returns a client that sends a user's token without the app transport
+func (a *githubApp) userClient(token string) *github.Client {
+	return github.NewClient(&http.Client{Transport: a.base}).WithAuthToken(token)
+}
+
+// installation finds the installation covering scope ("repo:owner/name",
+// "repoid:123" or "org:name"); 0 means the app isn't installed there
+func (a *githubApp) installation(ctx context.Context, scope string) (int64, error) {
+	a.mu.Lock()
+	cached, ok := a.installations[scope]
+	a.mu.Unlock()
+	if ok && time.Since(cached.at) < installationCacheTTL {
+		return cached.id, nil
+	}
+
+	client, err := a.client()
+	if err != nil {
+		return 0, err
+	}
+
+	var found *github.Installation
+	kind, name, _ := strings.Cut(scope, ":")
+	switch kind {
+	case "repo":
+		owner, repo, _ := strings.Cut(name, "/")
+		found, _, err = client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	case "repoid":
+		var repoID int64
+		if repoID, err = strconv.ParseInt(name, 10, 64); err == nil {
+			found, _, err = client.Apps.FindRepositoryInstallationByID(ctx, repoID)
+		}
+	case "org":
+		found, _, err = client.Apps.FindOrganizationInstallation(ctx, name)
+	}
+	if err != nil && !isNotFound(err) {
+		return 0, fmt.Errorf("failed to find installation for %s: %v", scope, err)
+	}
+
+	id := found.GetID()
+	a.mu.Lock()
+	a.installations[scope] = cachedInstallation{at: time.Now(), id: id}
+	a.mu.Unlock()
+	return id, nil
+}
+
+// installationToken returns a cached token of an installation, exchanging the
+// JWT for a new one when it's within five minutes of expiry
+func (a *githubApp) installationToken(ctx context.Context, id int64) (string, error) {
+	a.mu.Lock()
+	cached, ok := a.tokens[id]
+	a.mu.Unlock()
+	if ok && time.Until(cached.expiresAt) > 5*time.Minute {
+		return cached.token, nil
+	}
+
+	client, err := a.client()
+	if err != nil {
+		return "", err
+	}
+	token, _, err := client.Apps.CreateInstallationToken(ctx, id, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installation token: %v", err)
+	}
+
+	a.mu.Lock()
+	a.tokens[id] = installationToken{token: token.GetToken(), expiresAt: token.GetExpiresAt().Time}
+	a.mu.Unlock()
+	return token.GetToken(), nil
+}
+
+// userMayWrite checks that the user's own role allows the write the app makes
+// for them. Results are cached like capabilities.
+func (a *githubApp) userMayWrite(ctx context.Context, userToken, scope string, needAdmin bool) bool {
+	key := fmt.Sprintf("%s|%t", capabilityCacheKey(userToken, scope), needAdmin)
+	a.mu.Lock()
+	cached, ok := a.permissions[key]
+	a.mu.Unlock()
+	if ok && time.Since(cached.at) < capabilityCacheTTL {
+		return cached.allowed
+	}
+
+	client := a.userClient(userToken)
+	allowed := false
+	kind, name, _ := strings.Cut(scope, ":")
+	switch kind {
+	case "repo", "repoid":
+		var repository *github.Repository
+		var err error
+		if kind == "repo" {
+			owner, repo, _ := strings.Cut(name, "/")
+			repository, _, err = client.Repositories.Get(ctx, owner, repo)
+		} else if repoID, parseErr := strconv.ParseInt(name, 10, 64); parseErr == nil {
+			repository, _, err = client.Repositories.GetByID(ctx, repoID)
+		}
+		if err == nil && repository != nil {
+			permission := repoPermission(repository.Permissions)
+			allowed = permission == "admin" || (!needAdmin && (permission == "maintain" || permission == "write"))
+		}
+	case "org":
+		membership, _, err := client.Organizations.GetOrgMembership(ctx, "", name)
+		allowed = err == nil && membership.GetState() == "active" && membership.GetRole() == "admin"
+	}
+
+	a.mu.Lock()
+	a.permissions[key] = cachedPermission{at: time.Now(), allowed: allowed}
+	a.mu.Unlock()
+	return allowed
+}
+
+// appWriteScope returns the scope of a write request the app may take over, and
+// whether the user needs admin rights for it. Only the writes this server makes
+// are taken over: keys (write), environments and their protection rules (admin)
+// and org keys (admin). Secret public keys are fetched with the app too, since
+// they're only needed to write.
+func appWriteScope(req *http.Request) (scope string, needAdmin, audited, ok bool) {
+	if req.URL.Host != githubAPIHost {
+		return "", false, false, false
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	publicKey := strings.HasSuffix(req.URL.Path, "/secrets/public-key")
+	if req.Method == http.MethodGet && !publicKey {
+		return "", false, false, false
+	}
+	// Permission probes never change anything and would only flood the trail
+	audited = req.Method != http.MethodGet && !strings.Contains(strings.ToUpper(req.URL.Path), "GEM_PREFLIGHT_")
+
+	isKeys := func(part string) bool { return part == "variables" || part == "secrets" }
+	switch {
+	case len(parts) >= 5 && parts[0] == "repos" && parts[3] == "actions" && isKeys(parts[4]):
+		return "repo:" + parts[1] + "/" + parts[2], false, audited, true
+	case len(parts) >= 5 && parts[0] == "repos" && parts[3] == "environments":
+		// Environment keys need write; the environment itself, its reviewers and
+		// branch policies need admin
+		needAdmin = len(parts) < 6 || !isKeys(parts[5])
+		return "repo:" + parts[1] + "/" + parts[2], needAdmin, audited, true
+	case len(parts) >= 5 && parts[0] == "repositories" && parts[2] == "environments" && isKeys(parts[4]):
+		return "repoid:" + parts[1], false, audited, true
+	case len(parts) >= 4 && parts[0] == "orgs" && parts[2] == "actions" && isKeys(parts[3]):
+		return "org:" + parts[1], true, audited, true
+	}
+	return "", false, false, false
+}
+
+// sessionUser finds the logged in user a token belongs to
+func sessionUser(token string) (User, bool) {
+	_, user, ok := findSession(func(user User) bool { return user.Token == token })
+	return user, ok
+}
+
+// RoundTrip sends writes of logged in users with an installation token
+func (a *githubApp) RoundTrip(req *http.Request) (*http.Response, error) {
+	scope, needAdmin, audited, ok := appWriteScope(req)
+	if !ok {
+		return a.base.RoundTrip(req)
+	}
+
+	authorization := req.Header.Get("Authorization")
+	userToken := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(authorization, "Bearer "), "token "))
+	user, ok := sessionUser(userToken)
+	if !ok || userToken == "" {
+		return a.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	id, err := a.installation(ctx, scope)
+	if err != nil {
+		logrus.Warnf("GitHub App: %v", err)
+	}
+	if id == 0 || !a.userMayWrite(ctx, userToken, scope, needAdmin) {
+		return a.base.RoundTrip(req)
+	}
+
+	token, err := a.installationToken(ctx, id)
+	if err != nil {
+		logrus.Warnf("GitHub App: %v", err)
+		return a.base.RoundTrip(req)
+	}
+
+	appReq := req.Clone(ctx)
+	appReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := a.base.RoundTrip(appReq)
+
+	if audited {
+		entry := AppAuditEntry{
+			At:             time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+			OnBehalfOf:     user.Login,
+			Method:         req.Method,
+			Path:           req.URL.Path,
+			InstallationID: id,
+		}
+		if resp != nil {
+			entry.Status = resp.StatusCode
+		}
+		recordAppAudit(entry)
+	}
+	return resp, err
+}
+
+func recordAppAudit(entry AppAuditEntry) {
+	err := store.update(func(data *StoreData) error {
+		data.AppAudit = append(data.AppAudit, entry)
+		if excess := len(data.AppAudit) - maxAppAuditEntries; excess > 0 {
+			data.AppAudit = data.AppAudit[excess:]
+		}
+		return nil
+	})
+	if err != nil {
+		logrus.Warnf("Failed to record app audit entry: %v", err)
+	}
+}
+
+// enableGitHubApp loads the app and routes GitHub API writes through it
+func enableGitHubApp() error {
+	app, err := loadGitHubApp(appID, appPrivateKeyFile)
+	if err != nil {
+		return err
+	}
+
+	// Check the key belongs to the app before serving anything
+	client, err := app.client()
+	if err != nil {
+		return err
+	}
+	info, _, err := client.Apps.Get(context.Background(), "")
+	if err != nil {
+		return fmt.Errorf("failed to authenticate as app %d: %v", appID, err)
+	}
+	logrus.Infof("Writing through GitHub App %s (%d)", info.GetSlug(), appID)
+
+	activeApp = app
+	http.DefaultTransport = app
+	return nil
+}
+
+func getAppStatus(c *gin.Context) {
+	if _, err := getAuthenticatedUser(c); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	if activeApp == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	activeApp.mu.Lock()
+	installations := make(map[string]int64, len(activeApp.installations))
+	for scope, cached := range activeApp.installations {
+		installations[scope] = cached.id
+	}
+	activeApp.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       true,
+		"app_id":        activeApp.id,
+		"installations": installations,
+	})
+}
+
+func getAppAudit(c *gin.Context) {
+	if _, err := getAuthenticatedUser(c); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	login := c.Query("user")
+	entries := []AppAuditEntry{}
+	store.view(func(data *StoreData) {
+		// Newest first
+		for i := len(data.AppAudit) - 1; i >= 0; i-- {
+			if login == "" || data.AppAudit[i].OnBehalfOf == login {
+				entries = append(entries, data.AppAudit[i])
+			}
+		}
+	})
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+func TestAppWriteScope(t *testing.T) {
+	cases := []struct {
+		method, path string
+		scope        string
+		needAdmin    bool
+		ok           bool
+	}{
+		{"PATCH", "/repos/acme/app/actions/variables/LOG_LEVEL", "repo:acme/app", false, true},
+		{"PUT", "/repos/acme/app/actions/secrets/TOKEN", "repo:acme/app", false, true},
+		{"GET", "/repos/acme/app/actions/secrets/public-key", "repo:acme/app", false, true},
+		{"POST", "/repos/acme/app/environments/prod/variables", "repo:acme/app", false, true},
+		{"PUT", "/repos/acme/app/environments/prod", "repo:acme/app", true, true},
+		{"DELETE", "/repos/acme/app/environments/prod", "repo:acme/app", true, true},
+		{"POST", "/repos/acme/app/environments/prod/deployment-branch-policies", "repo:acme/app", true, true},
+		{"PUT", "/repositories/42/environments/prod/secrets/TOKEN", "repoid:42", false, true},
+		{"GET", "/repositories/42/environments/prod/secrets/public-key", "repoid:42", false, true},
+		{"POST", "/orgs/acme/actions/variables", "org:acme", true, true},
+		{"PUT", "/orgs/acme/actions/secrets/TOKEN/repositories", "org:acme", true, true},
+		// Not writes this server makes, so they keep the user's token
+		{"POST", "/repos/acme/app/deployments", "", false, false},
+		{"PUT", "/repos/acme/app/collaborators/mallory", "", false, false},
+		{"PATCH", "/orgs/acme", "", false, false},
+		{"GET", "/repos/acme/app/actions/variables", "", false, false},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(tc.method, "https://api.github.com"+tc.path, nil)
+		scope, needAdmin, _, ok := appWriteScope(req)
+		if scope != tc.scope || needAdmin != tc.needAdmin || ok != tc.ok {
+			t.Errorf("%s %s: got (%q, admin %v, %v), want (%q, admin %v, %v)", tc.method, tc.path, scope, needAdmin, ok, tc.scope, tc.needAdmin, tc.ok)
+		}
+	}
+}
+
+func testApp(t *testing.T) *githubApp {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &githubApp{
+		id:            4242,
+		key:           key,
+		base:          http.DefaultTransport,
+		tokens:        make(map[int64]installationToken),
+		installations: make(map[string]cachedInstallation),
+		permissions:   make(map[string]cachedPermission),
+	}
+}
+
+func TestAppJWTClaims(t *testing.T) {
+	app := testApp(t)
+	token, err := app.jwt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected three JWT parts, got %q", token)
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+		Iss string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	if claims.Iss != "4242" || claims.Iat > now-30 || claims.Exp <= now || claims.Exp-claims.Iat > 600 {
+		t.Fatalf("unexpected claims %+v at %d", claims, now)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&app.key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatalf("signature doesn't verify: %v", err)
+	}
+}
+
+func TestAppRoundTripSwapsTokenOnlyForWriters(t *testing.T) {
+	testStore(t)
+	var mu sync.Mutex
+	installed := false
+	writes := map[string]string{} // variable -> Authorization it was written with
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/repos/acme/app/installation":
+			if !installed {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(gin.H{"id": 7})
+		case r.URL.Path == "/app/installations/7/access_tokens":
+			json.NewEncoder(w).Encode(gin.H{"token": "installation-token", "expires_at": time.Now().Add(time.Hour)})
+		case r.URL.Path == "/repos/acme/app":
+			permissions := gin.H{"pull": true}
+			if r.Header.Get("Authorization") == "Bearer writer-token" {
+				permissions["push"] = true
+			}
+			json.NewEncoder(w).Encode(gin.H{"id": 1, "permissions": permissions})
+		case strings.HasPrefix(r.URL.Path, "/repos/acme/app/actions/variables/"):
+			writes[strings.TrimPrefix(r.URL.Path, "/repos/acme/app/actions/variables/")] = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	app := testApp(t)
+	testSession(t, "writer", "writer-token")
+	testSession(t, "reader", "reader-token")
+	write := func(token, name string) {
+		client := github.NewClient(&http.Client{Transport: app}).WithAuthToken(token)
+		if _, err := client.Actions.UpdateRepoVariable(context.Background(), "acme", "app", &github.ActionsVariable{Name: name, Value: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Not installed yet: the user's token goes out
+	write("writer-token", "BEFORE_INSTALL")
+
+	// Installed later; once the cached lookup expires the app takes over
+	mu.Lock()
+	installed = true
+	mu.Unlock()
+	app.mu.Lock()
+	for scope, cached := range app.installations {
+		cached.at = cached.at.Add(-installationCacheTTL)
+		app.installations[scope] = cached
+	}
+	app.mu.Unlock()
+	write("writer-token", "WRITER")
+	write("reader-token", "READER")
+
+	want := map[string]string{
+		"BEFORE_INSTALL": "Bearer writer-token",
+		"WRITER":         "Bearer installation-token",
+		"READER":         "Bearer reader-token",
+	}
+	for name, authorization := range want {
+		if writes[name] != authorization {
+			t.Errorf("%s was written with %q, want %q", name, writes[name], authorization)
+		}
+	}
+
+	var audit []AppAuditEntry
+	store.view(func(data *StoreData) { audit = data.AppAudit })
+	if len(audit) != 1 || audit[0].OnBehalfOf != "writer" || audit[0].InstallationID != 7 {
+		t.Fatalf("expected one audited write for writer, got %+v", audit)
+	}
+}
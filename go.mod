@@ -3,11 +3,14 @@ module github-env-manager
 go 1.25.0
 
 require (
+	filippo.io/age v1.1.1
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/go-github/v74 v74.0.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -34,9 +37,7 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/net v0.22.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeGitHub serves handler in place of api.github.com for the rest of the test
+func fakeGitHub(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	target, _ := url.Parse(server.URL)
+
+	previous := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "api.github.com" {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+		}
+		return previous.RoundTrip(req)
+	})
+	t.Cleanup(func() {
+		http.DefaultTransport = previous
+		server.Close()
+	})
+	return server
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// testSession logs a user in for the rest of the test and returns the session ID
+func testSession(t *testing.T, login, token string) string {
+	t.Helper()
+	sessionID, err := newSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveSession(sessionID, User{Login: login, Token: token})
+	t.Cleanup(func() { deleteSession(sessionID) })
+	return sessionID
+}
+
+// testStore opens an empty local store for the rest of the test
+func testStore(t *testing.T) {
+	t.Helper()
+	previous := store
+	opened, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store = opened
+	t.Cleanup(func() { store = previous })
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
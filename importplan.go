@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Shared planning for imports. Every importer (bundles, SOPS files, manifests)
+// turns its input into per-target variable and secret maps, plans the writes
+// against what the target already has, checks the plan against the policy and
+// then applies it key by key.
+
+// KeyChange is one key of an import
+type KeyChange struct {
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // "create", "update" or "skip"
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// targetLocation turns an "owner/repo" or "owner/repo:env" target into a key location
+func targetLocation(target, keyType, name string) (KeyLocation, error) {
+	if strings.Contains(target, ":") {
+		owner, repo, env, err := parseEnvTarget(target)
+		if err != nil {
+			return KeyLocation{}, err
+		}
+		return KeyLocation{Scope: "environment", Type: keyType, Repo: owner + "/" + repo, Environment: env, Name: name}, nil
+	}
+	if _, _, err := parseRepo(target); err != nil {
+		return KeyLocation{}, fmt.Errorf("invalid target %q, use 'owner/repo' or 'owner/repo:env'", target)
+	}
+	return KeyLocation{Scope: "repository", Type: keyType, Repo: target, Name: name}, nil
+}
+
+// listTargetKeys returns the variables and secret names of a repository or environment
+func listTargetKeys(client *github.Client, ctx context.Context, target string) ([]Variable, []Secret, error) {
+	loc, err := targetLocation(target, "variable", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	if loc.Scope == "repository" {
+		variables, err := listRepoVariables(client, ctx, owner, repo)
+		if err != nil {
+			return nil, nil, err
+		}
+		secrets, err := listRepoSecrets(client, ctx, owner, repo)
+		if err != nil {
+			return nil, nil, err
+		}
+		return variables, secrets, nil
+	}
+
+	variables, err := listEnvironmentVariables(client, ctx, owner, repo, loc.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	repoID, err := repositoryID(client, ctx, owner, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	secrets, err := listEnvironmentSecrets(client, ctx, repoID, loc.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+	return variables, secrets, nil
+}
+
+// plannedKeyWrite is a KeyChange with the location and value to write
+type plannedKeyWrite struct {
+	change KeyChange
+	loc    KeyLocation
+	value  string
+}
+
+// planKeyWrites plans writing variables and secrets to a target. Existing keys are
+// updated when overwrite is set and skipped otherwise. Writes to environments are
+// checked against the policy.
+func planKeyWrites(client *github.Client, ctx context.Context, target string, variables, secrets map[string]string, overwrite bool) ([]plannedKeyWrite, []PolicyViolation, error) {
+	existingVariables, existingSecrets, err := listTargetKeys(client, ctx, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	planned := []plannedKeyWrite{}
+	violations := []PolicyViolation{}
+	plan := func(keyType string, values map[string]string, exists func(string) bool) {
+		for _, name := range sortedKeys(values) {
+			loc, _ := targetLocation(target, keyType, name)
+			action := "create"
+			if exists(name) {
+				action = "update"
+				if !overwrite {
+					action = "skip"
+				}
+			}
+			if action != "skip" && loc.Scope == "environment" {
+				violations = append(violations, activePolicy.checkKey(loc.Environment, keyType, name)...)
+			}
+			planned = append(planned, plannedKeyWrite{
+				change: KeyChange{Target: target, Type: keyType, Name: name, Action: action},
+				loc:    loc,
+				value:  values[name],
+			})
+		}
+	}
+	plan("variable", variables, func(name string) bool {
+		for _, variable := range existingVariables {
+			if variable.Name == name {
+				return true
+			}
+		}
+		return false
+	})
+	plan("secret", secrets, func(name string) bool { return hasSecret(existingSecrets, name) })
+
+	return planned, violations, nil
+}
+
+// plannedChanges returns the changes of a plan for previews
+func plannedChanges(planned []plannedKeyWrite) []KeyChange {
+	changes := make([]KeyChange, len(planned))
+	for i, key := range planned {
+		changes[i] = key.change
+	}
+	return changes
+}
+
+// applyKeyWrites writes every planned key that isn't skipped. Secrets are sealed
+// with encryptSecret by writeKey.
+func applyKeyWrites(client *github.Client, ctx context.Context, planned []plannedKeyWrite) ([]KeyChange, int, []string) {
+	changes := plannedChanges(planned)
+	errors := []string{}
+	applied := 0
+	for i, key := range planned {
+		if key.change.Action == "skip" {
+			changes[i].Status = "skipped"
+			continue
+		}
+		if err := writeKey(client, ctx, key.loc, key.value); err != nil {
+			changes[i].Status = "failed"
+			changes[i].Error = err.Error()
+			errors = append(errors, fmt.Sprintf("%s: %v", key.loc, err))
+			continue
+		}
+		changes[i].Status = "applied"
+		applied++
+	}
+	return changes, applied, errors
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Variable value interpolation. A variable can be stored as a template that
+// references other variables: ${HOST} looks the key up like a workflow would
+// (environment, then repository, then organization), ${repo:HOST} and
+// ${org:SHARED_DOMAIN} pick the scope explicitly and $${ is a literal "${".
+// Templates live in the local store; GitHub only ever sees resolved values.
+
+// ValueTemplate is the template of one variable
+type ValueTemplate struct {
+	Location  KeyLocation `json:"location"`
+	Template  string      `json:"template"`
+	UpdatedBy string      `json:"updated_by,omitempty"`
+	UpdatedAt string      `json:"updated_at,omitempty"`
+}
+
+// templateRef is one ${...} reference of a template
+type templateRef struct {
+	Scope string // "", "repo" or "org"
+	Name  string
+}
+
+func (r templateRef) String() string {
+	if r.Scope == "" {
+		return "${" + r.Name + "}"
+	}
+	return "${" + r.Scope + ":" + r.Name + "}"
+}
+
+var templateRefPattern = regexp.MustCompile(`^(?:(repo|org):)?([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// expandTemplate parses template and replaces every reference with the result of
+// lookup. It doubles as the syntax check when lookup never fails.
+func expandTemplate(template string, lookup func(templateRef) (string, error)) (string, error) {
+	var out strings.Builder
+	rest := template
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			out.WriteString(rest)
+			return out.String(), nil
+		}
+		if i > 0 && rest[i-1] == '$' {
+			out.WriteString(rest[:i-1] + "${")
+			rest = rest[i+2:]
+			continue
+		}
+		out.WriteString(rest[:i])
+
+		end := strings.IndexByte(rest[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", rest[i:])
+		}
+		match := templateRefPattern.FindStringSubmatch(rest[i+2 : i+end])
+		if match == nil {
+			return "", fmt.Errorf("invalid reference %q, use ${NAME}, ${repo:NAME} or ${org:NAME}", rest[i:i+end+1])
+		}
+
+		value, err := lookup(templateRef{Scope: match[1], Name: match[2]})
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+		rest = rest[i+end+1:]
+	}
+}
+
+func findValueTemplate(templates []ValueTemplate, loc KeyLocation) *ValueTemplate {
+	for i := range templates {
+		if sameSecret(templates[i].Location, loc) {
+			return &templates[i]
+		}
+	}
+	return nil
+}
+
+// valueResolver resolves templates against live variables, caching each scope it reads
+type valueResolver struct {
+	client    *github.Client
+	ctx       context.Context
+	templates []ValueTemplate
+	scopes    map[string]map[string]string
+}
+
+// newValueResolver snapshots the stored templates
+func newValueResolver(client *github.Client, ctx context.Context) *valueResolver {
+	r := &valueResolver{client: client, ctx: ctx, scopes: make(map[string]map[string]string)}
+	store.view(func(data *StoreData) {
+		r.templates = append(r.templates, data.ValueTemplates...)
+	})
+	return r
+}
+
+// withTemplate makes the resolver use template for loc, e.g. to check a template
+// before it is saved
+func (r *valueResolver) withTemplate(loc KeyLocation, template string) {
+	if existing := findValueTemplate(r.templates, loc); existing != nil {
+		existing.Template = template
+		return
+	}
+	r.templates = append(r.templates, ValueTemplate{Location: loc, Template: template})
+}
+
+// variables returns the live variables of the scope of loc. Org variables seen
+// from a repository are the ones shared with it.
+func (r *valueResolver) variables(loc KeyLocation) (map[string]string, error) {
+	key := loc.Scope + "|" + loc.Org + "|" + loc.Repo + "|" + loc.Environment
+	if cached, ok := r.scopes[key]; ok {
+		return cached, nil
+	}
+
+	var variables []Variable
+	var err error
+	owner, repo, _ := parseRepo(loc.Repo)
+	switch {
+	case loc.Scope == "org" && loc.Repo != "":
+		variables, err = listRepoOrgVariables(r.client, r.ctx, owner, repo)
+	case loc.Scope == "org":
+		variables, err = listOrgVariables(r.client, r.ctx, loc.Org)
+	case loc.Scope == "environment":
+		variables, err = listEnvironmentVariables(r.client, r.ctx, owner, repo, loc.Environment)
+	default:
+		variables, err = listRepoVariables(r.client, r.ctx, owner, repo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list variables for %s: %v", loc, err)
+	}
+
+	values := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		values[variable.Name] = variable.Value
+	}
+	r.scopes[key] = values
+	return values, nil
+}
+
+// candidates lists where a reference made from loc may be defined, in lookup order
+func (r *valueResolver) candidates(from KeyLocation, ref templateRef) []KeyLocation {
+	org := KeyLocation{Scope: "org", Type: "variable", Org: from.Org, Repo: from.Repo, Name: ref.Name}
+	if from.Scope != "org" {
+		org.Org, _, _ = parseRepo(from.Repo)
+	}
+	repo := KeyLocation{Scope: "repository", Type: "variable", Repo: from.Repo, Name: ref.Name}
+	env := KeyLocation{Scope: "environment", Type: "variable", Repo: from.Repo, Environment: from.Environment, Name: ref.Name}
+
+	switch {
+	case ref.Scope == "org":
+		return []KeyLocation{org}
+	case ref.Scope == "repo" && from.Scope != "org":
+		return []KeyLocation{repo}
+	case ref.Scope == "repo":
+		return nil
+	case from.Scope == "environment":
+		return []KeyLocation{env, repo, org}
+	case from.Scope == "repository":
+		return []KeyLocation{repo, org}
+	default:
+		return []KeyLocation{org}
+	}
+}
+
+// resolve returns the value of the variable at loc, expanding its template if it has one
+func (r *valueResolver) resolve(loc KeyLocation) (string, error) {
+	return r.resolveKey(loc, nil)
+}
+
+func (r *valueResolver) resolveKey(loc KeyLocation, stack []string) (string, error) {
+	id := loc.String()
+	for i, seen := range stack {
+		if seen == id {
+			return "", fmt.Errorf("reference cycle: %s", strings.Join(append(stack[i:], id), " -> "))
+		}
+	}
+
+	// Org templates are stored without the repository they are seen from
+	stored := loc
+	if loc.Scope == "org" {
+		stored.Repo = ""
+	}
+	if template := findValueTemplate(r.templates, stored); template != nil {
+		stack = append(stack, id)
+		return expandTemplate(template.Template, func(ref templateRef) (string, error) {
+			for _, candidate := range r.candidates(loc, ref) {
+				if value, found, err := r.lookup(candidate, stack); found || err != nil {
+					return value, err
+				}
+			}
+			return "", fmt.Errorf("%s in %s is not defined", ref, id)
+		})
+	}
+
+	values, err := r.variables(loc)
+	if err != nil {
+		return "", err
+	}
+	return values[loc.Name], nil
+}
+
+// lookup resolves a candidate location, reporting whether it is defined at all
+func (r *valueResolver) lookup(loc KeyLocation, stack []string) (string, bool, error) {
+	stored := loc
+	if loc.Scope == "org" {
+		stored.Repo = ""
+	}
+	if findValueTemplate(r.templates, stored) == nil {
+		values, err := r.variables(loc)
+		if err != nil {
+			return "", false, err
+		}
+		if _, ok := values[loc.Name]; !ok {
+			return "", false, nil
+		}
+	}
+	value, err := r.resolveKey(loc, stack)
+	return value, true, err
+}
+
+// interpolateOnWrite is used by the variable handlers. With interpolate set the
+// resolved value is returned for writing; otherwise the value is returned as is.
+// The returned save updates the template store to match: it stores value as the
+// template of loc, or drops a stored template since the literal value replaces
+// it. Handlers call it only once GitHub has taken the write, so a failed or
+// staged write leaves the stored template alone.
+func interpolateOnWrite(client *github.Client, ctx context.Context, loc KeyLocation, value string, interpolate bool, login string) (string, func() error, error) {
+	loc.Type = "variable"
+	loc.Visibility = ""
+
+	if !interpolate {
+		return value, func() error {
+			hasTemplate := false
+			store.view(func(data *StoreData) {
+				hasTemplate = findValueTemplate(data.ValueTemplates, loc) != nil
+			})
+			if !hasTemplate {
+				return nil
+			}
+			return store.update(func(data *StoreData) error {
+				data.ValueTemplates = removeValueTemplate(data.ValueTemplates, loc)
+				return nil
+			})
+		}, nil
+	}
+
+	resolver := newValueResolver(client, ctx)
+	resolver.withTemplate(loc, value)
+	resolved, err := resolver.resolve(loc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return resolved, func() error {
+		return saveValueTemplate(ValueTemplate{Location: loc, Template: value, UpdatedBy: login})
+	}, nil
+}
+
+func saveValueTemplate(template ValueTemplate) error {
+	template.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	return store.update(func(data *StoreData) error {
+		if existing := findValueTemplate(data.ValueTemplates, template.Location); existing != nil {
+			*existing = template
+			return nil
+		}
+		data.ValueTemplates = append(data.ValueTemplates, template)
+		return nil
+	})
+}
+
+func removeValueTemplate(templates []ValueTemplate, loc KeyLocation) []ValueTemplate {
+	kept := templates[:0]
+	for _, template := range templates {
+		if !sameSecret(template.Location, loc) {
+			kept = append(kept, template)
+		}
+	}
+	return kept
+}
+
+// ResolvedTemplate is one entry of the resolve preview
+type ResolvedTemplate struct {
+	Location KeyLocation `json:"location"`
+	Template string      `json:"template"`
+	Resolved string      `json:"resolved,omitempty"`
+	Current  string      `json:"current"`
+	Changed  bool        `json:"changed"`
+	Status   string      `json:"status,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+func listValueTemplates(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	repo := c.Query("repo")
+	org := c.Query("org")
+
+	stored := []ValueTemplate{}
+	store.view(func(data *StoreData) {
+		for _, template := range data.ValueTemplates {
+			if (repo == "" || template.Location.Repo == repo) && (org == "" || template.Location.Org == org) {
+				stored = append(stored, template)
+			}
+		}
+	})
+
+	// Templates can hold literal values, so only show those the user can read
+	mayRead := readerFilter(user)
+	templates := []ValueTemplate{}
+	for _, template := range stored {
+		if mayRead(locationTargets([]KeyLocation{template.Location})) {
+			templates = append(templates, template)
+		}
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+func putValueTemplate(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Location KeyLocation `json:"location"`
+		Template string      `json:"template"`
+		DryRun   bool        `json:"dry_run"` // resolve without saving
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Location.Type = "variable"
+	req.Location.Visibility = ""
+	if err := req.Location.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location: " + err.Error()})
+		return
+	}
+	if !authorizeLocationEdit(c, user, req.Location) {
+		return
+	}
+	if _, err := expandTemplate(req.Template, func(templateRef) (string, error) { return "", nil }); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	// Undefined references and cycles are rejected before the template is saved
+	resolver := newValueResolver(client, ctx)
+	resolver.withTemplate(req.Location, req.Template)
+	resolved, err := resolver.resolve(req.Location)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"location": req.Location, "template": req.Template, "resolved": resolved})
+		return
+	}
+
+	template := ValueTemplate{Location: req.Location, Template: req.Template, UpdatedBy: user.Login}
+	if err := saveValueTemplate(template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save template: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"location": req.Location, "template": req.Template, "resolved": resolved})
+}
+
+func deleteValueTemplate(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	loc := KeyLocation{
+		Scope:       c.Query("scope"),
+		Type:        "variable",
+		Org:         c.Query("org"),
+		Repo:        c.Query("repo"),
+		Environment: c.Query("environment"),
+		Name:        c.Query("name"),
+	}
+	if err := loc.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location: " + err.Error()})
+		return
+	}
+	if !authorizeLocationEdit(c, user, loc) {
+		return
+	}
+
+	found := false
+	err = store.update(func(data *StoreData) error {
+		found = findValueTemplate(data.ValueTemplates, loc) != nil
+		data.ValueTemplates = removeValueTemplate(data.ValueTemplates, loc)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete template: %v", err)})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No template for " + loc.String()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
+
+// resolveValueTemplates previews the resolved value of every template of a
+// repository (or org) and, with apply set, writes the ones that changed
+func resolveValueTemplates(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Repo  string   `json:"repo"`
+		Org   string   `json:"org"`
+		Envs  []string `json:"envs"` // default all environments
+		Apply bool     `json:"apply"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Repo == "" && req.Org == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "repo or org is required"})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	resolver := newValueResolver(client, ctx)
+	mayRead := readerFilter(user)
+	entries := []ResolvedTemplate{}
+	violations := []PolicyViolation{}
+	for _, template := range resolver.templates {
+		loc := template.Location
+		switch {
+		case req.Repo != "" && loc.Repo == req.Repo:
+			if loc.Scope == "environment" && len(req.Envs) > 0 && !contains(req.Envs, loc.Environment) {
+				continue
+			}
+		case req.Org != "" && loc.Scope == "org" && loc.Org == req.Org:
+		default:
+			continue
+		}
+		if !mayRead(locationTargets([]KeyLocation{loc})) {
+			continue
+		}
+
+		entry := ResolvedTemplate{Location: loc, Template: template.Template}
+		if current, err := resolver.variables(loc); err == nil {
+			entry.Current = current[loc.Name]
+		}
+		resolved, err := resolver.resolve(loc)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Resolved = resolved
+			entry.Changed = resolved != entry.Current
+		}
+		if entry.Changed && loc.Scope == "environment" {
+			violations = append(violations, activePolicy.checkKey(loc.Environment, "variable", loc.Name)...)
+		}
+		entries = append(entries, entry)
+	}
+
+	if !req.Apply {
+		c.JSON(http.StatusOK, gin.H{"templates": entries, "violations": violations})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	changed := []KeyLocation{}
+	for _, entry := range entries {
+		if entry.Changed {
+			changed = append(changed, entry.Location)
+		}
+	}
+	if !authorizeWrite(c, user, locationTargets(changed)) {
+		return
+	}
+
+	applied := 0
+	errors := []string{}
+	for i, entry := range entries {
+		if entry.Error != "" {
+			errors = append(errors, fmt.Sprintf("%s: %s", entry.Location, entry.Error))
+			continue
+		}
+		if !entry.Changed {
+			continue
+		}
+		if err := writeKey(client, ctx, entry.Location, entry.Resolved); err != nil {
+			entries[i].Status = "failed"
+			entries[i].Error = err.Error()
+			errors = append(errors, fmt.Sprintf("%s: %v", entry.Location, err))
+			continue
+		}
+		entries[i].Status = "applied"
+		applied++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("Applied %d resolved values", applied),
+		"applied_count": applied,
+		"templates":     entries,
+		"errors":        errors,
+	})
+}
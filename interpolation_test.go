@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func storedTemplates() []ValueTemplate {
+	var templates []ValueTemplate
+	store.view(func(data *StoreData) {
+		templates = append(templates, data.ValueTemplates...)
+	})
+	return templates
+}
+
+func TestTemplateOnlySavedAfterGitHubTakesTheWrite(t *testing.T) {
+	testStore(t)
+	sessionID := testSession(t, "alice", "alice-token")
+	protectedEnvironments = []string{"production"}
+	t.Cleanup(func() { protectedEnvironments = nil })
+
+	accept := false
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/acme/svc":
+			w.Write([]byte(`{"id": 1}`))
+		case r.Method == "POST" && accept:
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	router := gin.New()
+	router.POST("/api/repos/:owner/:repo/variables", createVariable)
+	router.POST("/api/repos/:owner/:repo/environments/:env/variables", createEnvironmentVariable)
+	post := func(path string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"name": "API_URL", "value": "https://example.com/api", "interpolate": true}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Session-ID", sessionID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := post("/api/repos/acme/svc/variables"); status == http.StatusCreated || len(storedTemplates()) != 0 {
+		t.Fatalf("expected a failed write to leave no template, got %d and %v", status, storedTemplates())
+	}
+	if status := post("/api/repos/acme/svc/environments/production/variables"); status != http.StatusAccepted || len(storedTemplates()) != 0 {
+		t.Fatalf("expected a staged write to leave no template, got %d and %v", status, storedTemplates())
+	}
+
+	accept = true
+	if status := post("/api/repos/acme/svc/variables"); status != http.StatusCreated {
+		t.Fatalf("create: %d", status)
+	}
+	templates := storedTemplates()
+	if len(templates) != 1 || templates[0].Template != "https://example.com/api" || templates[0].Location.Scope != "repository" {
+		t.Fatalf("expected the template to be saved after the write, got %v", templates)
+	}
+}
+
+func TestValueTemplatesNeedAccessToTheLocation(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"template-reader": "pull", "template-writer": "push"})
+	if err := saveValueTemplate(ValueTemplate{Location: KeyLocation{Scope: "repository", Type: "variable", Repo: "acme/svc", Name: "API_URL"}, Template: "https://internal.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/api/value-templates", listValueTemplates)
+	router.PUT("/api/value-templates", putValueTemplate)
+	router.DELETE("/api/value-templates", deleteValueTemplate)
+	call := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Session-ID", testSession(t, token, token))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if body := call(http.MethodGet, "/api/value-templates", "", "template-reader").Body.String(); !strings.Contains(body, "API_URL") {
+		t.Errorf("reader should see the template, got %s", body)
+	}
+	if body := call(http.MethodGet, "/api/value-templates", "", "template-stranger").Body.String(); body != "[]" {
+		t.Errorf("user without access should see no templates, got %s", body)
+	}
+
+	body := `{"location": {"scope": "repository", "repo": "acme/svc", "name": "API_URL"}, "template": "https://evil.example.com"}`
+	if rec := call(http.MethodPut, "/api/value-templates", body, "template-reader"); rec.Code != http.StatusForbidden {
+		t.Errorf("reader put: status %d, want 403", rec.Code)
+	}
+	if rec := call(http.MethodDelete, "/api/value-templates?scope=repository&repo=acme/svc&name=API_URL", "", "template-reader"); rec.Code != http.StatusForbidden {
+		t.Errorf("reader delete: status %d, want 403", rec.Code)
+	}
+	if templates := storedTemplates(); len(templates) != 1 || templates[0].Template != "https://internal.example.com" {
+		t.Errorf("template changed by a reader: %v", templates)
+	}
+	if rec := call(http.MethodDelete, "/api/value-templates?scope=repository&repo=acme/svc&name=API_URL", "", "template-writer"); rec.Code != http.StatusOK {
+		t.Errorf("writer delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
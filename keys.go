@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Write helpers for code paths that change keys outside of the single-key
+// handlers (conversions, moves, bulk jobs). Secrets are always sealed with
+// encryptSecret against the public key of the target scope.
+
+// putRepoSecret creates or updates a repository secret
+func putRepoSecret(client *github.Client, ctx context.Context, owner, repo, name, value string) error {
+	publicKey, _, err := client.Actions.GetRepoPublicKey(ctx, owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repository public key: %v", err)
+	}
+
+	encryptedValue, err := encryptSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	_, err = client.Actions.CreateOrUpdateRepoSecret(ctx, owner, repo, &github.EncryptedSecret{
+		Name:           name,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: encryptedValue,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write repository secret: %v", err)
+	}
+	return nil
+}
+
+// putEnvironmentSecret creates or updates an environment secret
+func putEnvironmentSecret(client *github.Client, ctx context.Context, repoID int64, env, name, value string) error {
+	publicKey, _, err := client.Actions.GetEnvPublicKey(ctx, int(repoID), env)
+	if err != nil {
+		return fmt.Errorf("failed to get environment public key: %v", err)
+	}
+
+	encryptedValue, err := encryptSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	_, err = client.Actions.CreateOrUpdateEnvSecret(ctx, int(repoID), env, &github.EncryptedSecret{
+		Name:           name,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: encryptedValue,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write environment secret: %v", err)
+	}
+	return nil
+}
+
+// putOrgSecret creates or updates an organization secret. Visibility is "all",
+// "private" or "selected"; it defaults to "private".
+func putOrgSecret(client *github.Client, ctx context.Context, org, name, value, visibility string) error {
+	publicKey, _, err := client.Actions.GetOrgPublicKey(ctx, org)
+	if err != nil {
+		return fmt.Errorf("failed to get organization public key: %v", err)
+	}
+
+	encryptedValue, err := encryptSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	if visibility == "" {
+		visibility = "private"
+	}
+
+	_, err = client.Actions.CreateOrUpdateOrgSecret(ctx, org, &github.EncryptedSecret{
+		Name:           name,
+		KeyID:          publicKey.GetKeyID(),
+		EncryptedValue: encryptedValue,
+		Visibility:     visibility,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write organization secret: %v", err)
+	}
+	return nil
+}
+
+// sealSecret encrypts a value for a repository or environment secret ahead of
+// writing it, so it can be kept until then without storing the plain value
+func sealSecret(client *github.Client, ctx context.Context, loc KeyLocation, value string) (*github.EncryptedSecret, error) {
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	var publicKey *github.PublicKey
+	if loc.Scope == "environment" {
+		repoID, err := repositoryID(client, ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey, _, err = client.Actions.GetEnvPublicKey(ctx, int(repoID), loc.Environment); err != nil {
+			return nil, fmt.Errorf("failed to get environment public key: %v", err)
+		}
+	} else {
+		var err error
+		if publicKey, _, err = client.Actions.GetRepoPublicKey(ctx, owner, repo); err != nil {
+			return nil, fmt.Errorf("failed to get repository public key: %v", err)
+		}
+	}
+
+	encryptedValue, err := encryptSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+	return &github.EncryptedSecret{Name: loc.Name, KeyID: publicKey.GetKeyID(), EncryptedValue: encryptedValue}, nil
+}
+
+// writeSealedSecret writes a secret sealed by sealSecret
+func writeSealedSecret(client *github.Client, ctx context.Context, loc KeyLocation, secret *github.EncryptedSecret) error {
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	var err error
+	if loc.Scope == "environment" {
+		var repoID int64
+		if repoID, err = repositoryID(client, ctx, owner, repo); err == nil {
+			_, err = client.Actions.CreateOrUpdateEnvSecret(ctx, int(repoID), loc.Environment, secret)
+		}
+	} else {
+		_, err = client.Actions.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", loc, err)
+	}
+	return nil
+}
+
+// KeyLocation identifies a single variable or secret at org, repository or environment scope
+type KeyLocation struct {
+	Scope       string `json:"scope"` // "org", "repository" or "environment"
+	Type        string `json:"type"`  // "variable" or "secret"
+	Org         string `json:"org,omitempty"`
+	Repo        string `json:"repo,omitempty"` // "owner/repo"
+	Environment string `json:"environment,omitempty"`
+	Name        string `json:"name"`
+	// Visibility applies to org keys only ("all", "private" or "selected")
+	Visibility string `json:"visibility,omitempty"`
+}
+
+func (l KeyLocation) String() string {
+	switch l.Scope {
+	case "org":
+		return fmt.Sprintf("org %s %s %s", l.Org, l.Type, l.Name)
+	case "environment":
+		return fmt.Sprintf("%s:%s %s %s", l.Repo, l.Environment, l.Type, l.Name)
+	default:
+		return fmt.Sprintf("%s %s %s", l.Repo, l.Type, l.Name)
+	}
+}
+
+// keyNamePattern is what GitHub accepts for variable and secret names, once uppercased
+var keyNamePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// isValidKeyName reports whether GitHub would accept name for a variable or
+// secret. GitHub stores names uppercased, so callers uppercase them first.
+func isValidKeyName(name string) bool {
+	return keyNamePattern.MatchString(name) && !strings.HasPrefix(name, "GITHUB_")
+}
+
+// sameKey reports whether two locations name the same key. GitHub treats owner,
+// repository, environment and key names case-insensitively, and the visibility
+// of an org key doesn't make it a different key.
+func (l KeyLocation) sameKey(other KeyLocation) bool {
+	if l.Scope != other.Scope || l.Type != other.Type || !strings.EqualFold(l.Name, other.Name) {
+		return false
+	}
+	if l.Scope == "org" {
+		return strings.EqualFold(l.Org, other.Org)
+	}
+	return strings.EqualFold(l.Repo, other.Repo) && (l.Scope != "environment" || strings.EqualFold(l.Environment, other.Environment))
+}
+
+// validate checks that the location has the fields its scope needs
+func (l KeyLocation) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("key name is required")
+	}
+	if l.Type != "variable" && l.Type != "secret" {
+		return fmt.Errorf("type must be 'variable' or 'secret'")
+	}
+	switch l.Scope {
+	case "org":
+		if l.Org == "" {
+			return fmt.Errorf("org is required for org scope")
+		}
+	case "repository", "environment":
+		if _, _, err := parseRepo(l.Repo); err != nil {
+			return err
+		}
+		if l.Scope == "environment" && l.Environment == "" {
+			return fmt.Errorf("environment is required for environment scope")
+		}
+	default:
+		return fmt.Errorf("scope must be 'org', 'repository' or 'environment'")
+	}
+	return nil
+}
+
+func repositoryID(client *github.Client, ctx context.Context, owner, repo string) (int64, error) {
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get repository: %v", err)
+	}
+	return repository.GetID(), nil
+}
+
+// readVariableValue returns the current value of a variable
+func readVariableValue(client *github.Client, ctx context.Context, loc KeyLocation) (string, error) {
+	var variable *github.ActionsVariable
+	var err error
+
+	owner, repo, _ := parseRepo(loc.Repo)
+	switch loc.Scope {
+	case "org":
+		variable, _, err = client.Actions.GetOrgVariable(ctx, loc.Org, loc.Name)
+	case "environment":
+		variable, _, err = client.Actions.GetEnvVariable(ctx, owner, repo, loc.Environment, loc.Name)
+	default:
+		variable, _, err = client.Actions.GetRepoVariable(ctx, owner, repo, loc.Name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return variable.Value, nil
+}
+
+// keyExists reports whether the key is currently defined at the location
+func keyExists(client *github.Client, ctx context.Context, loc KeyLocation) (bool, error) {
+	var err error
+	if loc.Type == "variable" {
+		_, err = readVariableValue(client, ctx, loc)
+	} else {
+		owner, repo, _ := parseRepo(loc.Repo)
+		switch loc.Scope {
+		case "org":
+			_, _, err = client.Actions.GetOrgSecret(ctx, loc.Org, loc.Name)
+		case "environment":
+			var repoID int64
+			if repoID, err = repositoryID(client, ctx, owner, repo); err != nil {
+				return false, err
+			}
+			_, _, err = client.Actions.GetEnvSecret(ctx, int(repoID), loc.Environment, loc.Name)
+		default:
+			_, _, err = client.Actions.GetRepoSecret(ctx, owner, repo, loc.Name)
+		}
+	}
+
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeKey creates or updates the key at the location
+func writeKey(client *github.Client, ctx context.Context, loc KeyLocation, value string) error {
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	if loc.Type == "secret" {
+		switch loc.Scope {
+		case "org":
+			return putOrgSecret(client, ctx, loc.Org, loc.Name, value, loc.Visibility)
+		case "environment":
+			repoID, err := repositoryID(client, ctx, owner, repo)
+			if err != nil {
+				return err
+			}
+			return putEnvironmentSecret(client, ctx, repoID, loc.Environment, loc.Name, value)
+		default:
+			return putRepoSecret(client, ctx, owner, repo, loc.Name, value)
+		}
+	}
+
+	variable := &github.ActionsVariable{Name: loc.Name, Value: value}
+	var err error
+	switch loc.Scope {
+	case "org":
+		visibility := loc.Visibility
+		if visibility == "" {
+			visibility = "private"
+		}
+		variable.Visibility = &visibility
+		if _, err = client.Actions.UpdateOrgVariable(ctx, loc.Org, variable); isNotFound(err) {
+			_, err = client.Actions.CreateOrgVariable(ctx, loc.Org, variable)
+		}
+	case "environment":
+		if _, err = client.Actions.UpdateEnvVariable(ctx, owner, repo, loc.Environment, variable); isNotFound(err) {
+			_, err = client.Actions.CreateEnvVariable(ctx, owner, repo, loc.Environment, variable)
+		}
+	default:
+		if _, err = client.Actions.UpdateRepoVariable(ctx, owner, repo, variable); isNotFound(err) {
+			_, err = client.Actions.CreateRepoVariable(ctx, owner, repo, variable)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write variable: %v", err)
+	}
+	return nil
+}
+
+// deleteKey removes the key at the location
+func deleteKey(client *github.Client, ctx context.Context, loc KeyLocation) error {
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	var err error
+	switch {
+	case loc.Type == "variable" && loc.Scope == "org":
+		_, err = client.Actions.DeleteOrgVariable(ctx, loc.Org, loc.Name)
+	case loc.Type == "variable" && loc.Scope == "environment":
+		_, err = client.Actions.DeleteEnvVariable(ctx, owner, repo, loc.Environment, loc.Name)
+	case loc.Type == "variable":
+		_, err = client.Actions.DeleteRepoVariable(ctx, owner, repo, loc.Name)
+	case loc.Scope == "org":
+		_, err = client.Actions.DeleteOrgSecret(ctx, loc.Org, loc.Name)
+	case loc.Scope == "environment":
+		var repoID int64
+		if repoID, err = repositoryID(client, ctx, owner, repo); err == nil {
+			_, err = client.Actions.DeleteEnvSecret(ctx, int(repoID), loc.Environment, loc.Name)
+		}
+	default:
+		_, err = client.Actions.DeleteRepoSecret(ctx, owner, repo, loc.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", loc, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Import of Kubernetes ConfigMap and Secret manifests. ConfigMap entries become
+// variables and Secret entries become secrets of one target. Manifests may hold
+// several YAML documents and "List" objects; other kinds are ignored.
+
+// kubeManifest is the part of a Kubernetes object the importer reads
+type kubeManifest struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+	BinaryData map[string]string `yaml:"binaryData"`
+	Items      []kubeManifest    `yaml:"items"`
+}
+
+// KubeEntry is one key read from a manifest
+type KubeEntry struct {
+	Source string `json:"source"` // "ConfigMap/name" or "Secret/name"
+	Key    string `json:"key"`    // key in the manifest
+	Name   string `json:"name"`   // GitHub variable or secret name
+	Type   string `json:"type"`
+	value  string
+}
+
+// kubeKeyName turns a manifest key (e.g. "database.url") into a valid GitHub
+// variable or secret name ("DATABASE_URL")
+func kubeKeyName(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// parseKubeManifests reads the ConfigMap and Secret entries of a multi-document
+// manifest. In a Secret, stringData wins over data like it does on the API server.
+func parseKubeManifests(content []byte) ([]KubeEntry, []string, error) {
+	entries := []KubeEntry{}
+	warnings := []string{}
+
+	var collect func(manifest kubeManifest) error
+	collect = func(manifest kubeManifest) error {
+		source := manifest.Kind + "/" + manifest.Metadata.Name
+		switch manifest.Kind {
+		case "List", "ConfigMapList", "SecretList":
+			for _, item := range manifest.Items {
+				if err := collect(item); err != nil {
+					return err
+				}
+			}
+		case "ConfigMap":
+			for _, key := range sortedKeys(manifest.Data) {
+				entries = append(entries, KubeEntry{Source: source, Key: key, Type: "variable", value: manifest.Data[key]})
+			}
+			for _, key := range sortedKeys(manifest.BinaryData) {
+				warnings = append(warnings, fmt.Sprintf("%s: binaryData key %q skipped", source, key))
+			}
+		case "Secret":
+			values := map[string]string{}
+			for key, encoded := range manifest.Data {
+				decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+				if err != nil {
+					return fmt.Errorf("%s: data key %q is not valid base64", source, key)
+				}
+				values[key] = string(decoded)
+			}
+			for key, value := range manifest.StringData {
+				values[key] = value
+			}
+			for _, key := range sortedKeys(values) {
+				entries = append(entries, KubeEntry{Source: source, Key: key, Type: "secret", value: values[key]})
+			}
+		case "":
+			// Empty document
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s: kind %s ignored", source, manifest.Kind))
+		}
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var manifest kubeManifest
+		err := decoder.Decode(&manifest)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+		}
+		if err := collect(manifest); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Map keys to GitHub names; the first manifest wins on collisions
+	seen := map[string]KubeEntry{}
+	kept := entries[:0]
+	for _, entry := range entries {
+		entry.Name = kubeKeyName(entry.Key)
+		if entry.Name == "" || strings.HasPrefix(entry.Name, "GITHUB_") {
+			warnings = append(warnings, fmt.Sprintf("%s: key %q has no valid GitHub name", entry.Source, entry.Key))
+			continue
+		}
+		if first, ok := seen[entry.Name]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s: key %q maps to %s, already taken by %s key %q", entry.Source, entry.Key, entry.Name, first.Source, first.Key))
+			continue
+		}
+		seen[entry.Name] = entry
+		kept = append(kept, entry)
+	}
+
+	return kept, warnings, nil
+}
+
+func importKubernetesManifests(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Manifests string `json:"manifests"`
+		Target    string `json:"target"` // "owner/repo:env" or "owner/repo"
+		Overwrite bool   `json:"overwrite"`
+		DryRun    bool   `json:"dry_run"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, err := targetLocation(req.Target, "variable", ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, warnings, err := parseKubeManifests([]byte(req.Manifests))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No ConfigMap or Secret entries found", "warnings": warnings})
+		return
+	}
+
+	variables := map[string]string{}
+	secrets := map[string]string{}
+	for _, entry := range entries {
+		if entry.Type == "variable" {
+			variables[entry.Name] = entry.value
+		} else {
+			secrets[entry.Name] = entry.value
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	planned, violations, err := planKeyWrites(client, ctx, req.Target, variables, secrets, req.Overwrite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", req.Target, err)})
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"entries":    entries,
+			"changes":    plannedChanges(planned),
+			"violations": violations,
+			"warnings":   warnings,
+		})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	changes, applied, errors := applyKeyWrites(client, ctx, planned)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("Imported %d keys from Kubernetes manifests", applied),
+		"applied_count": applied,
+		"entries":       entries,
+		"changes":       changes,
+		"warnings":      warnings,
+		"errors":        errors,
+	})
+}
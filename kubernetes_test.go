@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKubeManifests(t *testing.T) {
+	content := `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  database.url: postgres://db/app
+  log-level: info
+binaryData:
+  logo.png: iVBORw0K
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+data:
+  api-key: c2VjcmV0
+  password: b2xk
+stringData:
+  password: new
+---
+apiVersion: v1
+kind: List
+items:
+  - kind: ConfigMap
+    metadata:
+      name: extra
+    data:
+      DATABASE_URL: postgres://other/app
+      1st: x
+      github.token: y
+  - kind: Deployment
+    metadata:
+      name: web
+`
+	entries, warnings, err := parseKubeManifests([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, entry := range entries {
+		got = append(got, entry.Source+" "+entry.Key+" "+entry.Type+" "+entry.Name+"="+entry.value)
+	}
+	want := []string{
+		"ConfigMap/app database.url variable DATABASE_URL=postgres://db/app",
+		"ConfigMap/app log-level variable LOG_LEVEL=info",
+		"Secret/app api-key secret API_KEY=secret",
+		"Secret/app password secret PASSWORD=new",
+		"ConfigMap/extra 1st variable _1ST=x",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, warning := range []string{
+		`ConfigMap/app: binaryData key "logo.png" skipped`,
+		`ConfigMap/extra: key "DATABASE_URL" maps to DATABASE_URL, already taken by ConfigMap/app key "database.url"`,
+		`ConfigMap/extra: key "github.token" has no valid GitHub name`,
+		"Deployment/web: kind Deployment ignored",
+	} {
+		found := false
+		for _, got := range warnings {
+			found = found || got == warning
+		}
+		if !found {
+			t.Errorf("missing warning %q in %v", warning, warnings)
+		}
+	}
+}
+
+func TestParseKubeManifestsRejectsBadInput(t *testing.T) {
+	for name, content := range map[string]string{
+		"invalid yaml":   "kind: [",
+		"invalid base64": "kind: Secret\nmetadata:\n  name: app\ndata:\n  token: '%%%'\n",
+	} {
+		if _, _, err := parseKubeManifests([]byte(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Layered environments. A repository gets a locally stored base set of variables
+// and one overlay per environment that only holds what differs. Overlays may
+// extend other overlays (production-eu extends production) and unset inherited
+// keys. Rendering flattens the layers and writes the result to each environment.
+// GitHub uppercases variable names, so layers store them uppercased too.
+
+const baseLayer = "base"
+
+// EnvironmentOverlay is the layer of one environment
+type EnvironmentOverlay struct {
+	Extends   string            `json:"extends,omitempty"` // another overlay, default base
+	Variables map[string]string `json:"variables"`
+	Unset     []string          `json:"unset,omitempty"` // inherited keys to drop
+}
+
+// EnvironmentLayers holds the layers of one repository
+type EnvironmentLayers struct {
+	Repo      string                        `json:"repo"`
+	Base      map[string]string             `json:"base"`
+	Overlays  map[string]EnvironmentOverlay `json:"overlays"`
+	UpdatedBy string                        `json:"updated_by,omitempty"`
+	UpdatedAt string                        `json:"updated_at,omitempty"`
+}
+
+// EffectiveValue is one variable of a flattened environment
+type EffectiveValue struct {
+	Value      string   `json:"value"`
+	Layer      string   `json:"layer"`                // layer the value came from
+	Overridden []string `json:"overridden,omitempty"` // lower layers that also set it
+}
+
+// layerChain returns the overlays env inherits from, base first. Environments
+// without an overlay only get the base layer.
+func (l *EnvironmentLayers) layerChain(env string) ([]string, error) {
+	chain := []string{}
+	for name := env; name != "" && name != baseLayer; {
+		if contains(chain, name) {
+			return nil, fmt.Errorf("overlay %s extends itself through %s", env, strings.Join(chain, " -> "))
+		}
+		overlay, ok := l.Overlays[name]
+		if !ok {
+			if name != env {
+				return nil, fmt.Errorf("overlay %s extends unknown overlay %s", chain[len(chain)-1], name)
+			}
+			break
+		}
+		chain = append(chain, name)
+		name = overlay.Extends
+	}
+
+	// Reverse so base-most overlays are applied first
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return append([]string{baseLayer}, chain...), nil
+}
+
+// effective flattens the layers of env
+func (l *EnvironmentLayers) effective(env string) (map[string]EffectiveValue, error) {
+	chain, err := l.layerChain(env)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]EffectiveValue)
+	for _, layer := range chain {
+		variables := l.Base
+		if layer != baseLayer {
+			overlay := l.Overlays[layer]
+			for _, name := range overlay.Unset {
+				delete(values, strings.ToUpper(name))
+			}
+			variables = overlay.Variables
+		}
+		for name, value := range variables {
+			name = strings.ToUpper(name)
+			entry := EffectiveValue{Value: value, Layer: layer}
+			if previous, ok := values[name]; ok {
+				entry.Overridden = append(previous.Overridden, previous.Layer)
+			}
+			values[name] = entry
+		}
+	}
+	return values, nil
+}
+
+// environments returns the environments the layers render to
+func (l *EnvironmentLayers) environments() []string {
+	envs := make([]string, 0, len(l.Overlays))
+	for env := range l.Overlays {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+// targets returns the repository and every overlay environment of the layers
+func (l *EnvironmentLayers) targets() []RBACTarget {
+	owner, _, _ := parseRepo(l.Repo)
+	targets := []RBACTarget{{Org: owner, Repo: l.Repo}}
+	for _, env := range l.environments() {
+		targets = append(targets, RBACTarget{Org: owner, Repo: l.Repo, Environment: env})
+	}
+	return targets
+}
+
+// normalizeLayerVariables uppercases the names of one layer and rejects names
+// GitHub wouldn't take or that only differ in case
+func normalizeLayerVariables(layer string, variables map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(variables))
+	for name, value := range variables {
+		upper := strings.ToUpper(name)
+		if !isValidKeyName(upper) {
+			return nil, fmt.Errorf("invalid variable name %q in layer %s", name, layer)
+		}
+		if _, ok := normalized[upper]; ok {
+			return nil, fmt.Errorf("layer %s sets %s more than once", layer, upper)
+		}
+		normalized[upper] = value
+	}
+	return normalized, nil
+}
+
+func findEnvironmentLayers(layers []EnvironmentLayers, repo string) *EnvironmentLayers {
+	for i := range layers {
+		if layers[i].Repo == repo {
+			return &layers[i]
+		}
+	}
+	return nil
+}
+
+// loadEnvironmentLayers returns a copy of the stored layers of repo
+func loadEnvironmentLayers(repo string) *EnvironmentLayers {
+	var layers *EnvironmentLayers
+	store.view(func(data *StoreData) {
+		if stored := findEnvironmentLayers(data.Layers, repo); stored != nil {
+			copied := *stored
+			layers = &copied
+		}
+	})
+	return layers
+}
+
+func getEnvironmentLayers(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	repo := c.Param("owner") + "/" + c.Param("repo")
+	layers := loadEnvironmentLayers(repo)
+	if layers == nil {
+		layers = &EnvironmentLayers{Repo: repo, Base: map[string]string{}, Overlays: map[string]EnvironmentOverlay{}}
+	}
+	// Layers hold plain values, so only show them to users who can read them
+	if !readerFilter(user)(layers.targets()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, layers)
+}
+
+func putEnvironmentLayers(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req EnvironmentLayers
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Repo = c.Param("owner") + "/" + c.Param("repo")
+	if req.Base == nil {
+		req.Base = map[string]string{}
+	}
+	if req.Overlays == nil {
+		req.Overlays = map[string]EnvironmentOverlay{}
+	}
+	if _, ok := req.Overlays[baseLayer]; ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "'base' is reserved for the base layer"})
+		return
+	}
+	if req.Base, err = normalizeLayerVariables(baseLayer, req.Base); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for env, overlay := range req.Overlays {
+		if overlay.Variables, err = normalizeLayerVariables(env, overlay.Variables); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i, name := range overlay.Unset {
+			overlay.Unset[i] = strings.ToUpper(name)
+		}
+		req.Overlays[env] = overlay
+	}
+	for env := range req.Overlays {
+		if _, err := req.layerChain(env); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !checkRBAC(c, user, "write", req.targets()) {
+		return
+	}
+	if !canWriteRepo(user, req.Repo) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You need write access to " + req.Repo})
+		return
+	}
+
+	req.UpdatedBy = user.Login
+	req.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+
+	err = store.update(func(data *StoreData) error {
+		if existing := findEnvironmentLayers(data.Layers, req.Repo); existing != nil {
+			*existing = req
+			return nil
+		}
+		data.Layers = append(data.Layers, req)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save layers: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func getEffectiveEnvironment(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	repo := c.Param("owner") + "/" + c.Param("repo")
+	layers := loadEnvironmentLayers(repo)
+	if layers == nil || !readerFilter(user)(layers.targets()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No layers defined for " + repo})
+		return
+	}
+
+	envs := c.QueryArray("env")
+	if len(envs) == 0 {
+		envs = layers.environments()
+	}
+
+	effective := make(map[string]map[string]EffectiveValue)
+	for _, env := range envs {
+		values, err := layers.effective(env)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		effective[env] = values
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repo": repo, "environments": effective})
+}
+
+// LayerRenderChange is one variable write or delete of a render
+type LayerRenderChange struct {
+	Environment string `json:"environment"`
+	Name        string `json:"name"`
+	Action      string `json:"action"` // "create", "update" or "delete"
+	Layer       string `json:"layer,omitempty"`
+	Current     string `json:"current,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// renderEnvironmentLayers compares the flattened layers with the live variables of
+// each environment and writes the difference. With prune set, live variables the
+// layers don't define are deleted.
+func renderEnvironmentLayers(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	var req struct {
+		Envs   []string `json:"envs"` // default every overlay
+		Prune  bool     `json:"prune"`
+		DryRun bool     `json:"dry_run"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	layers := loadEnvironmentLayers(owner + "/" + repo)
+	if layers == nil || !readerFilter(user)(layers.targets()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No layers defined for " + owner + "/" + repo})
+		return
+	}
+	if len(req.Envs) == 0 {
+		req.Envs = layers.environments()
+	}
+	if !req.DryRun {
+		targets := []RBACTarget{}
+		for _, env := range req.Envs {
+			targets = append(targets, RBACTarget{Org: owner, Repo: owner + "/" + repo, Environment: env})
+		}
+		if !authorizeWrite(c, user, targets) {
+			return
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	changes := []LayerRenderChange{}
+	violations := []PolicyViolation{}
+	errors := []string{}
+	for _, env := range req.Envs {
+		effective, err := layers.effective(env)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		live, err := listEnvironmentVariables(client, ctx, owner, repo, env)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", env, err))
+			continue
+		}
+		current := make(map[string]string, len(live))
+		for _, variable := range live {
+			current[strings.ToUpper(variable.Name)] = variable.Value
+		}
+
+		names := make([]string, 0, len(effective))
+		for name := range effective {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value := effective[name]
+			existing, exists := current[name]
+			if exists && existing == value.Value {
+				continue
+			}
+			change := LayerRenderChange{Environment: env, Name: name, Action: "create", Layer: value.Layer, Current: existing, Value: value.Value}
+			if exists {
+				change.Action = "update"
+			}
+			changes = append(changes, change)
+			violations = append(violations, activePolicy.checkKey(env, "variable", name)...)
+		}
+
+		if req.Prune {
+			for _, variable := range live {
+				if _, ok := effective[strings.ToUpper(variable.Name)]; !ok {
+					changes = append(changes, LayerRenderChange{Environment: env, Name: variable.Name, Action: "delete", Current: variable.Value})
+					violations = append(violations, activePolicy.checkDelete(env, "variable", variable.Name)...)
+				}
+			}
+		}
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"changes": changes, "violations": violations, "errors": errors})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	applied := 0
+	for i, change := range changes {
+		loc := KeyLocation{Scope: "environment", Type: "variable", Repo: owner + "/" + repo, Environment: change.Environment, Name: change.Name}
+		if change.Action == "delete" {
+			err = deleteKey(client, ctx, loc)
+		} else {
+			err = writeKey(client, ctx, loc, change.Value)
+		}
+		if err != nil {
+			changes[i].Status = "failed"
+			changes[i].Error = err.Error()
+			errors = append(errors, fmt.Sprintf("%s: %v", loc, err))
+			continue
+		}
+		changes[i].Status = "applied"
+		applied++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("Rendered %d changes to %s", applied, strings.Join(req.Envs, ", ")),
+		"applied_count": applied,
+		"changes":       changes,
+		"errors":        errors,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func putLayers(t *testing.T, sessionID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := gin.New()
+	router.PUT("/api/repos/:owner/:repo/layers", putEnvironmentLayers)
+	req := httptest.NewRequest("PUT", "/api/repos/acme/svc/layers", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-ID", sessionID)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPutLayersUppercasesNames(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"layers-writer": "push"})
+	sessionID := testSession(t, "alice", "layers-writer")
+
+	w := putLayers(t, sessionID, `{"base": {"api_url": "https://api"}, "overlays": {"production": {"variables": {"Log_Level": "warn"}, "unset": ["api_url"]}}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("save: %d %s", w.Code, w.Body)
+	}
+	layers := loadEnvironmentLayers("acme/svc")
+	if _, ok := layers.Base["API_URL"]; !ok || layers.Overlays["production"].Variables["LOG_LEVEL"] != "warn" || layers.Overlays["production"].Unset[0] != "API_URL" {
+		t.Fatalf("expected uppercased names, got %+v", layers)
+	}
+
+	effective, err := layers.effective("production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := effective["API_URL"]; ok || effective["LOG_LEVEL"].Value != "warn" {
+		t.Fatalf("expected API_URL to be unset and LOG_LEVEL set, got %+v", effective)
+	}
+
+	for _, body := range []string{
+		`{"base": {"api-url": "x"}}`,
+		`{"base": {"GITHUB_TOKEN": "x"}}`,
+		`{"base": {"1ST": "x"}}`,
+		`{"base": {"api_url": "a", "API_URL": "b"}}`,
+	} {
+		if w := putLayers(t, sessionID, body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestLayersNeedAccessToTheRepository(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"layers-owner": "push", "layers-reader": "pull"})
+	if w := putLayers(t, testSession(t, "alice", "layers-owner"), `{"base": {"DB_PASSWORD": "hunter2"}}`); w.Code != http.StatusOK {
+		t.Fatalf("save: %d %s", w.Code, w.Body)
+	}
+
+	router := gin.New()
+	router.GET("/api/repos/:owner/:repo/layers", getEnvironmentLayers)
+	router.GET("/api/repos/:owner/:repo/layers/effective", getEffectiveEnvironment)
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Session-ID", testSession(t, token, token))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/api/repos/acme/svc/layers", "/api/repos/acme/svc/layers/effective?env=production"} {
+		if w := get(path, "layers-reader"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hunter2") {
+			t.Errorf("reader %s: %d %s", path, w.Code, w.Body)
+		}
+		if w := get(path, "layers-stranger"); w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "hunter2") {
+			t.Errorf("user without access %s: %d %s", path, w.Code, w.Body)
+		}
+	}
+
+	if w := putLayers(t, testSession(t, "bob", "layers-reader"), `{"base": {"DB_PASSWORD": "changed"}}`); w.Code != http.StatusForbidden {
+		t.Errorf("reader put: %d, want 403", w.Code)
+	}
+	if loadEnvironmentLayers("acme/svc").Base["DB_PASSWORD"] != "hunter2" {
+		t.Error("layers changed by a reader")
+	}
+}
+
+func TestEffectiveLayersMatchNamesCaseInsensitively(t *testing.T) {
+	// Saved before names were normalized
+	layers := &EnvironmentLayers{
+		Base:     map[string]string{"api_url": "https://api", "DEBUG": "false"},
+		Overlays: map[string]EnvironmentOverlay{"staging": {Variables: map[string]string{"debug": "true"}, Unset: []string{"API_URL"}}},
+	}
+	effective, err := layers.effective("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(effective) != 1 || effective["DEBUG"].Value != "true" || effective["DEBUG"].Layer != "staging" {
+		t.Fatalf("expected only DEBUG=true from staging, got %+v", effective)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Listing helpers shared by the handlers that need to walk variables and
+// secrets outside of a single request/response cycle (search, analyzers,
+// bulk jobs). A 404 is treated as "nothing there", matching the handlers.
+
+// parseRepo splits an "owner/repo" string into its parts
+func parseRepo(fullName string) (string, string, error) {
+	parts := strings.Split(fullName, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid repo format %q, use 'owner/repo'", fullName)
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseEnvTarget splits an "owner/repo:env" string into its parts
+func parseEnvTarget(target string) (string, string, string, error) {
+	repoPart, env, found := strings.Cut(target, ":")
+	if !found || env == "" {
+		return "", "", "", fmt.Errorf("invalid target %q, use 'owner/repo:env'", target)
+	}
+	owner, repo, err := parseRepo(repoPart)
+	if err != nil {
+		return "", "", "", err
+	}
+	return owner, repo, env, nil
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "404")
+}
+
+func convertVariables(variables []*github.ActionsVariable) []Variable {
+	result := make([]Variable, 0, len(variables))
+	for _, variable := range variables {
+		result = append(result, Variable{
+			Name:      variable.Name,
+			Value:     variable.Value,
+			CreatedAt: variable.GetCreatedAt().Format("2006-01-02T15:04:05Z"),
+			UpdatedAt: variable.GetUpdatedAt().Format("2006-01-02T15:04:05Z"),
+		})
+	}
+	return result
+}
+
+func convertSecrets(secrets []*github.Secret) []Secret {
+	result := make([]Secret, 0, len(secrets))
+	for _, secret := range secrets {
+		result = append(result, Secret{
+			Name:      secret.Name,
+			CreatedAt: secret.CreatedAt.Format("2006-01-02T15:04:05Z"),
+			UpdatedAt: secret.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		})
+	}
+	return result
+}
+
+// listEnvironmentNames returns the names of all environments in a repository
+func listEnvironmentNames(client *github.Client, ctx context.Context, owner, repo string) ([]string, error) {
+	var names []string
+	opt := &github.EnvironmentListOptions{ListOptions: github.ListOptions{Page: 1, PerPage: 100}}
+	for {
+		environments, resp, err := client.Repositories.ListEnvironments(ctx, owner, repo, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []string{}, nil
+			}
+			return nil, err
+		}
+		for _, env := range environments.Environments {
+			names = append(names, env.GetName())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return names, nil
+}
+
+// listRepoVariables returns all repository-level Actions variables
+func listRepoVariables(client *github.Client, ctx context.Context, owner, repo string) ([]Variable, error) {
+	var all []Variable
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		variables, resp, err := client.Actions.ListRepoVariables(ctx, owner, repo, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Variable{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertVariables(variables.Variables)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listEnvironmentVariables returns all variables of a single environment
+func listEnvironmentVariables(client *github.Client, ctx context.Context, owner, repo, env string) ([]Variable, error) {
+	var all []Variable
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		variables, resp, err := client.Actions.ListEnvVariables(ctx, owner, repo, env, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Variable{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertVariables(variables.Variables)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listOrgVariables returns all organization-level variables visible to the token
+func listOrgVariables(client *github.Client, ctx context.Context, org string) ([]Variable, error) {
+	var all []Variable
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		variables, resp, err := client.Actions.ListOrgVariables(ctx, org, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Variable{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertVariables(variables.Variables)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listRepoSecrets returns the metadata of all repository-level secrets
+func listRepoSecrets(client *github.Client, ctx context.Context, owner, repo string) ([]Secret, error) {
+	var all []Secret
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		secrets, resp, err := client.Actions.ListRepoSecrets(ctx, owner, repo, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Secret{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertSecrets(secrets.Secrets)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listEnvironmentSecrets returns the metadata of all secrets of a single environment.
+// Environment secrets are addressed by repository ID rather than owner/name.
+func listEnvironmentSecrets(client *github.Client, ctx context.Context, repoID int64, env string) ([]Secret, error) {
+	var all []Secret
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		secrets, resp, err := client.Actions.ListEnvSecrets(ctx, int(repoID), env, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Secret{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertSecrets(secrets.Secrets)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listOrgSecrets returns the metadata of all organization-level secrets visible to the token
+func listOrgSecrets(client *github.Client, ctx context.Context, org string) ([]Secret, error) {
+	var all []Secret
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		secrets, resp, err := client.Actions.ListOrgSecrets(ctx, org, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Secret{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertSecrets(secrets.Secrets)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// EnvironmentSnapshot holds everything defined in one environment
+type EnvironmentSnapshot struct {
+	Name      string     `json:"name"`
+	Variables []Variable `json:"variables"`
+	Secrets   []Secret   `json:"secrets"`
+}
+
+// RepoSnapshot holds the repository-level and environment-level keys of a repository
+type RepoSnapshot struct {
+	Owner        string                `json:"owner"`
+	Repo         string                `json:"repo"`
+	ID           int64                 `json:"id"`
+	Variables    []Variable            `json:"variables"`
+	Secrets      []Secret              `json:"secrets"`
+	Environments []EnvironmentSnapshot `json:"environments"`
+	FetchedAt    time.Time             `json:"fetched_at"`
+}
+
+// OrgSnapshot holds the organization-level keys visible to the token
+type OrgSnapshot struct {
+	Org       string     `json:"org"`
+	Variables []Variable `json:"variables"`
+	Secrets   []Secret   `json:"secrets"`
+	FetchedAt time.Time  `json:"fetched_at"`
+}
+
+// Environment returns the snapshot of the named environment, or nil. Like
+// GitHub, names are matched case-insensitively.
+func (s *RepoSnapshot) Environment(name string) *EnvironmentSnapshot {
+	for i := range s.Environments {
+		if strings.EqualFold(s.Environments[i].Name, name) {
+			return &s.Environments[i]
+		}
+	}
+	return nil
+}
+
+// fetchRepoSnapshot lists repository variables and secrets plus those of every environment
+func fetchRepoSnapshot(client *github.Client, ctx context.Context, owner, repo string) (*RepoSnapshot, error) {
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository %s/%s: %v", owner, repo, err)
+	}
+
+	snapshot := &RepoSnapshot{
+		Owner:     owner,
+		Repo:      repo,
+		ID:        repository.GetID(),
+		FetchedAt: time.Now(),
+	}
+
+	if snapshot.Variables, err = listRepoVariables(client, ctx, owner, repo); err != nil {
+		return nil, fmt.Errorf("failed to list variables of %s/%s: %v", owner, repo, err)
+	}
+	if snapshot.Secrets, err = listRepoSecrets(client, ctx, owner, repo); err != nil {
+		return nil, fmt.Errorf("failed to list secrets of %s/%s: %v", owner, repo, err)
+	}
+
+	envNames, err := listEnvironmentNames(client, ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments of %s/%s: %v", owner, repo, err)
+	}
+
+	for _, env := range envNames {
+		envSnapshot := EnvironmentSnapshot{Name: env}
+		if envSnapshot.Variables, err = listEnvironmentVariables(client, ctx, owner, repo, env); err != nil {
+			return nil, fmt.Errorf("failed to list variables of %s/%s:%s: %v", owner, repo, env, err)
+		}
+		if envSnapshot.Secrets, err = listEnvironmentSecrets(client, ctx, snapshot.ID, env); err != nil {
+			return nil, fmt.Errorf("failed to list secrets of %s/%s:%s: %v", owner, repo, env, err)
+		}
+		snapshot.Environments = append(snapshot.Environments, envSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+// fetchOrgSnapshot lists organization variables and secrets
+func fetchOrgSnapshot(client *github.Client, ctx context.Context, org string) (*OrgSnapshot, error) {
+	snapshot := &OrgSnapshot{Org: org, FetchedAt: time.Now()}
+
+	var err error
+	if snapshot.Variables, err = listOrgVariables(client, ctx, org); err != nil {
+		return nil, fmt.Errorf("failed to list variables of org %s: %v", org, err)
+	}
+	if snapshot.Secrets, err = listOrgSecrets(client, ctx, org); err != nil {
+		return nil, fmt.Errorf("failed to list secrets of org %s: %v", org, err)
+	}
+
+	return snapshot, nil
+}
+
+// listRepoOrgVariables returns the organization variables shared with a repository
+func listRepoOrgVariables(client *github.Client, ctx context.Context, owner, repo string) ([]Variable, error) {
+	var all []Variable
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		variables, resp, err := client.Actions.ListRepoOrgVariables(ctx, owner, repo, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Variable{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertVariables(variables.Variables)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
+
+// listRepoOrgSecrets returns the metadata of the organization secrets shared with a repository
+func listRepoOrgSecrets(client *github.Client, ctx context.Context, owner, repo string) ([]Secret, error) {
+	var all []Secret
+	opt := &github.ListOptions{Page: 1, PerPage: 100}
+	for {
+		secrets, resp, err := client.Actions.ListRepoOrgSecrets(ctx, owner, repo, opt)
+		if err != nil {
+			if isNotFound(err) {
+				return []Secret{}, nil
+			}
+			return nil, err
+		}
+		all = append(all, convertSecrets(secrets.Secrets)...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return all, nil
+}
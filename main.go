@@ -1,22 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
-	port = 8005
-	host = "localhost"
+	port       = 8005
+	host       = "localhost"
+	policyFile = ""
+	rbacFile   = ""
 )
 
 func main() {
@@ -36,6 +42,37 @@ func main() {
 
 	rootCmd.Flags().IntVarP(&port, "port", "p", 8005, "Port to run the server on")
 	rootCmd.Flags().StringVarP(&host, "host", "H", "localhost", "Host to bind the server to")
+	rootCmd.PersistentFlags().StringVar(&policyFile, "policy", "", "Path to a required-keys policy file (YAML)")
+	rootCmd.Flags().StringVar(&rbacFile, "rbac", "", "Path to an RBAC file (YAML) mapping users and teams to roles")
+	rootCmd.Flags().StringSliceVar(&protectedEnvironments, "protected-envs", nil, "Environments (globs, e.g. prod*) whose changes need an approved change request")
+	rootCmd.Flags().StringVar(&dataDir, "data-dir", dataDir, "Directory for the local store (rotation history, secret metadata)")
+	rootCmd.Flags().StringVar(&secretFilesDir, "secret-files-dir", "", "Directory that file-based secret sources may read from")
+	rootCmd.Flags().StringVar(&oauthClientID, "oauth-client-id", os.Getenv("GEM_OAUTH_CLIENT_ID"), "Client ID of a GitHub OAuth or GitHub App for device flow login (defaults to $GEM_OAUTH_CLIENT_ID)")
+	rootCmd.Flags().StringVar(&oauthBaseURL, "oauth-base-url", oauthBaseURL, "Base URL of the GitHub OAuth endpoints")
+	rootCmd.Flags().StringVar(&oauthAPIURL, "oauth-api-url", oauthAPIURL, "REST API URL matching --oauth-base-url (e.g. https://ghes.example.com/api/v3/)")
+	rootCmd.Flags().StringVar(&oauthScopes, "oauth-scopes", oauthScopes, "Scopes requested by device flow login (OAuth apps only)")
+	rootCmd.Flags().Int64Var(&appID, "app-id", 0, "ID of a GitHub App to make writes through")
+	rootCmd.Flags().StringVar(&appPrivateKeyFile, "app-private-key", "", "Path to the GitHub App's private key (PEM)")
+	rootCmd.Flags().IntVar(&tokenExpiryWarningDays, "token-expiry-warning-days", tokenExpiryWarningDays, "Warn when the session token expires within this many days")
+	rootCmd.Flags().BoolVar(&sopsServerKey, "sops-server-key", false, "Let SOPS imports without an identity decrypt with the server's age key (SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or ~/.config/sops/age/keys.txt)")
+	rootCmd.Flags().BoolVar(&sopsAllowIgnoreMAC, "sops-allow-ignore-mac", false, "Let SOPS imports set ignore_mac to skip the MAC check")
+	rootCmd.Flags().StringVar(&vaultServerPrefix, "vault-server-prefix", "", "Vault mount/path prefix that sources without their own vault_token may read with the server's VAULT_TOKEN")
+	rootCmd.Flags().StringVar(&secretEnvPrefix, "secret-env-prefix", "", "Prefix of server environment variables usable as secret values (e.g. GEM_SECRET_); the env source is disabled when unset")
+	rootCmd.Flags().StringSliceVar(&vaultAllowedAddrs, "vault-allowed-addrs", nil, "Vault addresses secret sources may name in vault_addr")
+
+	var token string
+	var policyCheckCmd = &cobra.Command{
+		Use:   "policy-check owner/repo...",
+		Short: "Evaluate the policy against live repository data",
+		Args:  cobra.MinimumNArgs(1),
+		// Violations are reported as an error; the usage text would only add noise
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPolicyCheckCommand(args, token)
+		},
+	}
+	policyCheckCmd.Flags().StringVarP(&token, "token", "t", os.Getenv("GITHUB_TOKEN"), "GitHub token (defaults to $GITHUB_TOKEN)")
+	rootCmd.AddCommand(policyCheckCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -44,6 +81,56 @@ func main() {
 }
 
 func startServer() {
+	// Load the required-keys policy, if configured
+	if policyFile != "" {
+		policy, err := loadPolicy(policyFile)
+		if err != nil {
+			log.Fatal("Failed to load policy:", err)
+		}
+		activePolicy = policy
+		logrus.Infof("Enforcing policy from %s", policyFile)
+	}
+
+	// The client secret is only read from the environment so it stays out of process listings
+	oauthClientSecret = os.Getenv("GEM_OAUTH_CLIENT_SECRET")
+	if oauthClientID != "" {
+		logrus.Infof("Device flow login enabled through %s", oauthBaseURL)
+	}
+
+	// Load role-based access control, if configured
+	if rbacFile != "" {
+		rbac, err := loadRBAC(rbacFile)
+		if err != nil {
+			log.Fatal("Failed to load RBAC file:", err)
+		}
+		activeRBAC = rbac
+		logrus.Infof("Enforcing RBAC from %s (%d rules)", rbacFile, len(rbac.Rules))
+	}
+
+	// Open the local store
+	localData, err := openStore(dataDir)
+	if err != nil {
+		log.Fatal("Failed to open local store:", err)
+	}
+	store = localData
+
+	// Route writes through the GitHub App, if configured
+	if appID != 0 || appPrivateKeyFile != "" {
+		if appID == 0 || appPrivateKeyFile == "" {
+			log.Fatal("--app-id and --app-private-key must be set together")
+		}
+		if err := enableGitHubApp(); err != nil {
+			log.Fatal("Failed to enable GitHub App mode:", err)
+		}
+	}
+
+	if len(protectedEnvironments) > 0 {
+		logrus.Infof("Changes to %s need an approved change request", strings.Join(protectedEnvironments, ", "))
+	}
+
+	// Apply and revert scheduled changes
+	startScheduler()
+
 	// Set Gin to release mode for production
 	gin.SetMode(gin.ReleaseMode)
 
@@ -56,13 +143,35 @@ func startServer() {
 
 	// API routes
 	api := router.Group("/api")
+	api.Use(rbacMiddleware())
+	api.Use(protectedEnvironmentsMiddleware())
 	{
 		api.GET("/auth/url", getAuthURL)
 		api.POST("/auth/pat", authenticateWithPAT)
 		api.POST("/auth/validate", validateToken)
+		api.POST("/auth/device", startDeviceFlow)
 		api.GET("/auth/callback", handleAuthCallback)
 		api.GET("/auth/status", getAuthStatus)
+		api.GET("/auth/token-info", getTokenInfo)
+		api.GET("/app/status", getAppStatus)
+		api.GET("/app/audit", getAppAudit)
+		api.GET("/rbac", getRBAC)
+
+		// Change requests
+		api.GET("/change-requests", listChangeRequests)
+		api.POST("/change-requests", createChangeRequest)
+		api.GET("/change-requests/:id", getChangeRequest)
+		api.POST("/change-requests/:id/approve", approveChangeRequest)
+		api.POST("/change-requests/:id/reject", rejectChangeRequest)
+		api.POST("/change-requests/:id/apply", applyChangeRequestHandler)
+
+		// Scheduled and time-boxed changes
+		api.GET("/schedules", listScheduledChanges)
+		api.POST("/schedules", createScheduledChange)
+		api.GET("/schedules/:id", getScheduledChange)
+		api.POST("/schedules/:id/cancel", cancelScheduledChange)
 		api.GET("/repos", getRepositories)
+		api.POST("/repos/refresh", refreshRepositories)
 		api.GET("/repos/:owner/:repo/environments", getEnvironments)
 		api.POST("/repos/:owner/:repo/environments", createEnvironment)
 		api.GET("/repos/:owner/:repo/variables", getVariables)
@@ -81,12 +190,49 @@ func startServer() {
 		api.POST("/repos/:owner/:repo/secrets", createSecret)
 		api.PUT("/repos/:owner/:repo/secrets/:name", updateSecret)
 		api.DELETE("/repos/:owner/:repo/secrets/:name", deleteSecret)
+		api.GET("/repos/:owner/:repo/workflow-references", getWorkflowReferences)
+		api.GET("/repos/:owner/:repo/policy-check", checkPolicy)
+		api.GET("/repos/:owner/:repo/secret-scan", scanRepoForSecrets)
+		api.POST("/repos/:owner/:repo/secret-scan/convert", convertVariableToSecret)
+		api.POST("/repos/:owner/:repo/environments/:env/clone", cloneEnvironmentHandler)
+		api.GET("/repos/:owner/:repo/capabilities", getRepoCapabilities)
+		api.POST("/repos/:owner/:repo/apply-template", applyEnvironmentTemplate)
+		api.GET("/repos/:owner/:repo/layers", getEnvironmentLayers)
+		api.PUT("/repos/:owner/:repo/layers", putEnvironmentLayers)
+		api.GET("/repos/:owner/:repo/layers/effective", getEffectiveEnvironment)
+		api.POST("/repos/:owner/:repo/layers/render", renderEnvironmentLayers)
+		api.POST("/keys/move", moveKeyHandler)
+		api.POST("/secrets/rotate", rotateSecret)
+		api.GET("/secrets/rotations", listRotations)
+		api.GET("/secrets/rotations/:id", getRotationJob)
+		api.GET("/secrets/stale", getStaleSecrets)
+		api.GET("/secrets/metadata", listSecretMetadata)
+		api.PUT("/secrets/metadata", putSecretMetadata)
+		api.DELETE("/secrets/metadata", deleteSecretMetadata)
+		api.POST("/bundles/export", exportBundle)
+		api.POST("/bundles/import", importBundle)
+		api.POST("/sops/import", importSopsFile)
+		api.POST("/sops/export", exportSopsFile)
+		api.POST("/import/kubernetes", importKubernetesManifests)
+		api.POST("/export/terraform", exportTerraform)
+		api.GET("/value-templates", listValueTemplates)
+		api.PUT("/value-templates", putValueTemplate)
+		api.DELETE("/value-templates", deleteValueTemplate)
+		api.POST("/value-templates/resolve", resolveValueTemplates)
+		api.GET("/environment-templates", listEnvironmentTemplates)
+		api.PUT("/environment-templates/:name", putEnvironmentTemplate)
+		api.DELETE("/environment-templates/:name", deleteEnvironmentTemplate)
+		api.POST("/batch", batchEdit)
 		api.POST("/sync", syncVariables)
 		api.POST("/export", exportVariables)
 		api.POST("/import", importVariables)
 		api.GET("/compare", compareEnvironments)
+		api.GET("/search/keys", searchKeys)
 	}
 
+	// Keep cross-repository search results warm
+	startKeySearchRefresher()
+
 	// WebSocket for real-time updates
 	router.GET("/ws", handleWebSocket)
 
@@ -130,3 +276,50 @@ func openBrowser(url string) {
 		logrus.Warnf("Failed to open browser: %v", err)
 	}
 }
+
+// runPolicyCheckCommand prints the violations of each repository as JSON and
+// fails when any repository violates the policy
+func runPolicyCheckCommand(repos []string, token string) error {
+	if policyFile == "" {
+		return fmt.Errorf("--policy is required")
+	}
+	if token == "" {
+		return fmt.Errorf("a GitHub token is required (--token or $GITHUB_TOKEN)")
+	}
+
+	policy, err := loadPolicy(policyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(token)
+
+	failed := 0
+	for _, fullName := range repos {
+		owner, repo, err := parseRepo(fullName)
+		if err != nil {
+			return err
+		}
+
+		violations, err := runPolicyCheck(client, ctx, policy, owner, repo)
+		if err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			failed++
+		}
+
+		output, _ := json.MarshalIndent(map[string]interface{}{
+			"repo":       fullName,
+			"passed":     len(violations) == 0,
+			"violations": violations,
+		}, "", "  ")
+		fmt.Println(string(output))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repositories violate the policy", failed, len(repos))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Move changes a key's type (variable <-> secret) and/or scope (org, repository,
+// environment). The key is created at the target, verified, and only then deleted
+// at the source. If anything fails after the target was created, the target is
+// rolled back so the key ends up in exactly one place.
+
+// MoveStep reports the outcome of one step of a move
+type MoveStep struct {
+	Step   string `json:"step"`
+	Status string `json:"status"` // "ok", "failed" or "skipped"
+	Detail string `json:"detail,omitempty"`
+}
+
+type moveReport struct {
+	Steps []MoveStep `json:"steps"`
+}
+
+func (r *moveReport) ok(step, detail string) {
+	r.Steps = append(r.Steps, MoveStep{Step: step, Status: "ok", Detail: detail})
+}
+
+func (r *moveReport) failed(step string, err error) {
+	r.Steps = append(r.Steps, MoveStep{Step: step, Status: "failed", Detail: err.Error()})
+}
+
+// moveKey runs the move and returns the HTTP status that describes the outcome
+func moveKey(client *github.Client, ctx context.Context, source, target KeyLocation, value string, overwrite bool, report *moveReport) int {
+	// Read the value. Secrets can't be read back, so the caller has to supply it.
+	if source.Type == "variable" {
+		current, err := readVariableValue(client, ctx, source)
+		if err != nil {
+			report.failed("read", fmt.Errorf("failed to read %s: %v", source, err))
+			return http.StatusNotFound
+		}
+		value = current
+		report.ok("read", source.String())
+	} else {
+		exists, err := keyExists(client, ctx, source)
+		if err != nil || !exists {
+			if err == nil {
+				err = fmt.Errorf("%s does not exist", source)
+			}
+			report.failed("read", err)
+			return http.StatusNotFound
+		}
+		report.ok("read", "secret value supplied by caller")
+	}
+
+	targetExisted, err := keyExists(client, ctx, target)
+	if err != nil {
+		report.failed("check-target", err)
+		return http.StatusInternalServerError
+	}
+	if targetExisted && !overwrite {
+		report.failed("check-target", fmt.Errorf("%s already exists, set overwrite to replace it", target))
+		return http.StatusConflict
+	}
+	report.ok("check-target", target.String())
+
+	// Keep the value being overwritten so a rollback can put it back; only
+	// variables can be read, an overwritten secret is lost
+	previous, hasPrevious := "", false
+	previousLoc := target
+	if targetExisted && target.Type == "variable" {
+		if target.Scope == "org" {
+			var variable *github.ActionsVariable
+			if variable, _, err = client.Actions.GetOrgVariable(ctx, target.Org, target.Name); err == nil {
+				previous, previousLoc.Visibility = variable.Value, variable.GetVisibility()
+			}
+		} else {
+			previous, err = readVariableValue(client, ctx, target)
+		}
+		if err != nil {
+			report.failed("check-target", fmt.Errorf("failed to read %s before overwriting it: %v", target, err))
+			return http.StatusInternalServerError
+		}
+		hasPrevious = true
+	}
+
+	rollback := func() {
+		if hasPrevious {
+			if err := writeKey(client, ctx, previousLoc, previous); err != nil {
+				report.failed("rollback", err)
+				return
+			}
+			report.ok("rollback", "restored the previous value of "+target.String())
+			return
+		}
+		if targetExisted {
+			report.Steps = append(report.Steps, MoveStep{Step: "rollback", Status: "skipped", Detail: "target secret existed before the move and was overwritten"})
+			return
+		}
+		if err := deleteKey(client, ctx, target); err != nil {
+			report.failed("rollback", err)
+			return
+		}
+		report.ok("rollback", "removed "+target.String())
+	}
+
+	if err := writeKey(client, ctx, target, value); err != nil {
+		report.failed("create", err)
+		return http.StatusInternalServerError
+	}
+	report.ok("create", target.String())
+
+	// Verify the target before touching the source
+	if target.Type == "variable" {
+		written, err := readVariableValue(client, ctx, target)
+		if err == nil && written != value {
+			err = fmt.Errorf("value read back from %s does not match", target)
+		}
+		if err != nil {
+			report.failed("verify", err)
+			rollback()
+			return http.StatusInternalServerError
+		}
+	} else if exists, err := keyExists(client, ctx, target); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%s not found after writing it", target)
+		}
+		report.failed("verify", err)
+		rollback()
+		return http.StatusInternalServerError
+	}
+	report.ok("verify", target.String())
+
+	if err := deleteKey(client, ctx, source); err != nil {
+		report.failed("delete-source", err)
+		rollback()
+		return http.StatusInternalServerError
+	}
+	report.ok("delete-source", source.String())
+
+	return http.StatusOK
+}
+
+func moveKeyHandler(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Source    KeyLocation `json:"source"`
+		Target    KeyLocation `json:"target"`
+		Value     string      `json:"value"`
+		Overwrite bool        `json:"overwrite"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// The target keeps the source name unless a new one is given
+	if req.Target.Name == "" {
+		req.Target.Name = req.Source.Name
+	}
+
+	if err := req.Source.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source: " + err.Error()})
+		return
+	}
+	if err := req.Target.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target: " + err.Error()})
+		return
+	}
+	if req.Source.sameKey(req.Target) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target are the same"})
+		return
+	}
+	if req.Source.Type == "secret" && req.Value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secrets can't be read back from GitHub, supply the current value"})
+		return
+	}
+
+	// Reject keys the policy doesn't allow in the target environment, or requires
+	// in the source environment
+	violations := []PolicyViolation{}
+	if req.Target.Scope == "environment" {
+		violations = append(violations, activePolicy.checkKey(req.Target.Environment, req.Target.Type, req.Target.Name)...)
+	}
+	if req.Source.Scope == "environment" {
+		violations = append(violations, activePolicy.checkDelete(req.Source.Environment, req.Source.Type, req.Source.Name)...)
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	report := &moveReport{Steps: []MoveStep{}}
+	status := moveKey(client, ctx, req.Source, req.Target, req.Value, req.Overwrite, report)
+
+	response := gin.H{
+		"success": status == http.StatusOK,
+		"source":  req.Source,
+		"target":  req.Target,
+		"steps":   report.Steps,
+	}
+	if status == http.StatusOK {
+		response["message"] = fmt.Sprintf("Moved %s to %s", req.Source, req.Target)
+	} else {
+		response["error"] = fmt.Sprintf("Failed to move %s", req.Source)
+	}
+
+	c.JSON(status, response)
+}
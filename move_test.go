@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v74/github"
+)
+
+func TestKeyLocationSameKey(t *testing.T) {
+	repoKey := KeyLocation{Scope: "repository", Type: "variable", Repo: "acme/svc", Name: "API_URL"}
+	envKey := KeyLocation{Scope: "environment", Type: "variable", Repo: "acme/svc", Environment: "production", Name: "API_URL"}
+	orgKey := KeyLocation{Scope: "org", Type: "secret", Org: "acme", Name: "TOKEN", Visibility: "all"}
+
+	for _, tc := range []struct {
+		a, b KeyLocation
+		same bool
+	}{
+		{repoKey, KeyLocation{Scope: "repository", Type: "variable", Repo: "ACME/Svc", Name: "api_url"}, true},
+		{envKey, KeyLocation{Scope: "environment", Type: "variable", Repo: "acme/svc", Environment: "Production", Name: "API_URL"}, true},
+		{orgKey, KeyLocation{Scope: "org", Type: "secret", Org: "Acme", Name: "token", Visibility: "private"}, true},
+		{repoKey, envKey, false},
+		{envKey, KeyLocation{Scope: "environment", Type: "variable", Repo: "acme/svc", Environment: "staging", Name: "API_URL"}, false},
+		{repoKey, KeyLocation{Scope: "repository", Type: "secret", Repo: "acme/svc", Name: "API_URL"}, false},
+	} {
+		if got := tc.a.sameKey(tc.b); got != tc.same {
+			t.Errorf("%s vs %s: got %v, want %v", tc.a, tc.b, got, tc.same)
+		}
+	}
+}
+
+// fakeRepoVariables keeps repository variables of acme/* in memory; deleting
+// from acme/svc fails
+func fakeRepoVariables(t *testing.T, variables map[string]string) {
+	var mu sync.Mutex
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path := strings.TrimPrefix(r.URL.Path, "/repos/")
+		repo, name, _ := strings.Cut(path, "/actions/variables")
+		key := repo + "/" + strings.TrimPrefix(name, "/")
+
+		var body github.ActionsVariable
+		json.NewDecoder(r.Body).Decode(&body)
+		_, exists := variables[key]
+		switch {
+		case r.Method == "GET" && exists:
+			json.NewEncoder(w).Encode(github.ActionsVariable{Name: name, Value: variables[key]})
+		case r.Method == "PATCH" && exists:
+			variables[key] = body.Value
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "POST":
+			variables[repo+"/"+body.Name] = body.Value
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "DELETE" && repo == "acme/svc":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == "DELETE" && exists:
+			delete(variables, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestMoveRollbackRestoresOverwrittenVariable(t *testing.T) {
+	variables := map[string]string{"acme/svc/API_URL": "https://new", "acme/web/API_URL": "https://old"}
+	fakeRepoVariables(t, variables)
+	client := github.NewClient(nil).WithAuthToken("token")
+
+	source := KeyLocation{Scope: "repository", Type: "variable", Repo: "acme/svc", Name: "API_URL"}
+	target := KeyLocation{Scope: "repository", Type: "variable", Repo: "acme/web", Name: "API_URL"}
+	report := &moveReport{}
+	if status := moveKey(client, context.Background(), source, target, "", true, report); status != http.StatusInternalServerError {
+		t.Fatalf("expected the failed source delete to fail the move, got %d: %+v", status, report.Steps)
+	}
+
+	if variables["acme/web/API_URL"] != "https://old" {
+		t.Fatalf("expected the overwritten target to be restored, got %q: %+v", variables["acme/web/API_URL"], report.Steps)
+	}
+	last := report.Steps[len(report.Steps)-1]
+	if last.Step != "rollback" || last.Status != "ok" {
+		t.Fatalf("expected a successful rollback, got %+v", last)
+	}
+}
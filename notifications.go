@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// Live notifications. Browsers connect to /ws with their session ID and get a
+// JSON message whenever a change request is created, reviewed or applied. With
+// users only hear about repositories their token can read and, with RBAC on,
+// environments their role can read.
+
+// Notification is one message pushed to connected clients
+type Notification struct {
+	Type          string        `json:"type"`
+	Event         string        `json:"event"`
+	ChangeRequest ChangeRequest `json:"change_request"`
+}
+
+type notificationClient struct {
+	conn    *websocket.Conn
+	mayRead func(targets []RBACTarget) bool
+}
+
+type notificationHub struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]*notificationClient
+}
+
+var notifications = &notificationHub{clients: map[*websocket.Conn]*notificationClient{}}
+
+func (h *notificationHub) add(client *notificationClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[client.conn] = client
+}
+
+func (h *notificationHub) remove(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, conn)
+}
+
+// broadcast sends a notification to every client allowed to see it
+func (h *notificationHub) broadcast(notification Notification) {
+	h.mu.Lock()
+	clients := make([]*notificationClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	target := notification.ChangeRequest.target()
+	for _, client := range clients {
+		if !client.mayRead([]RBACTarget{target}) {
+			continue
+		}
+		if err := websocket.JSON.Send(client.conn, notification); err != nil {
+			logrus.Warnf("Failed to notify a WebSocket client: %v", err)
+			client.conn.Close()
+			h.remove(client.conn)
+		}
+	}
+}
+
+func handleWebSocket(c *gin.Context) {
+	// Browsers can't set headers on a WebSocket, so the session comes in the query
+	if c.GetHeader("X-Session-ID") == "" {
+		c.Request.Header.Set("X-Session-ID", c.Query("session"))
+	}
+
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	mayRead := readerFilter(user)
+	server := websocket.Server{Handler: func(conn *websocket.Conn) {
+		notifications.add(&notificationClient{conn: conn, mayRead: mayRead})
+		defer notifications.remove(conn)
+
+		// Clients don't send anything; reading only notices the connection closing
+		var message string
+		for websocket.Message.Receive(conn, &message) == nil {
+		}
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
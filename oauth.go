@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"github.com/sirupsen/logrus"
+)
+
+// OAuth device flow login. The server asks GitHub for a device code, the user
+// enters the short user code at GitHub, and the browser polls until GitHub hands
+// out a token. The device code never leaves the server. With expiring user
+// tokens enabled on the app, tokens are refreshed shortly before they expire.
+
+var (
+	// oauthClientID enables device flow login; empty keeps PAT-only login
+	oauthClientID = ""
+	// oauthClientSecret is only needed to refresh expiring user tokens
+	oauthClientSecret = ""
+	// oauthBaseURL is where the OAuth endpoints live, e.g. a GHES host or a local stand-in
+	oauthBaseURL = "https://github.com"
+	// oauthAPIURL is the REST API matching oauthBaseURL, e.g. https://ghes.example.com/api/v3/
+	oauthAPIURL = "https://api.github.com/"
+	// oauthScopes are requested for OAuth apps; GitHub Apps ignore them
+	oauthScopes = "repo admin:org"
+)
+
+// tokenRefreshMargin is how long before expiry a token is refreshed
+const tokenRefreshMargin = 5 * time.Minute
+
+// deviceFlow is a login in progress
+type deviceFlow struct {
+	deviceCode string
+	interval   int
+	expiresAt  time.Time
+}
+
+var deviceFlows = struct {
+	sync.Mutex
+	flows map[string]*deviceFlow
+}{flows: make(map[string]*deviceFlow)}
+
+// oauthTokenResponse is the answer of the access token endpoint
+type oauthTokenResponse struct {
+	AccessToken           string `json:"access_token"`
+	RefreshToken          string `json:"refresh_token"`
+	ExpiresIn             int    `json:"expires_in"`
+	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in"`
+	Interval              int    `json:"interval"`
+	Error                 string `json:"error"`
+	ErrorDescription      string `json:"error_description"`
+}
+
+// oauthPost posts a form to an OAuth endpoint and decodes the JSON answer
+func oauthPost(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(oauthBaseURL, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// oauthAPIClient returns a client for the API that issued OAuth tokens
+func oauthAPIClient(token string) (*github.Client, error) {
+	client := github.NewClient(nil).WithAuthToken(token)
+	baseURL, err := url.Parse(strings.TrimSuffix(oauthAPIURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid OAuth API URL: %v", err)
+	}
+	client.BaseURL = baseURL
+	return client, nil
+}
+
+// sessionFromOAuthToken creates a session for a token handed out by the OAuth flow
+func sessionFromOAuthToken(ctx context.Context, token *oauthTokenResponse) (string, User, error) {
+	client, err := oauthAPIClient(token.AccessToken)
+	if err != nil {
+		return "", User{}, err
+	}
+	ghUser, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return "", User{}, fmt.Errorf("failed to get the authenticated user: %v", err)
+	}
+
+	user := User{
+		Login:        ghUser.GetLogin(),
+		Name:         ghUser.GetName(),
+		AvatarURL:    ghUser.GetAvatarURL(),
+		Token:        token.AccessToken,
+		RefreshToken: token.RefreshToken,
+	}
+	if token.ExpiresIn > 0 {
+		user.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", User{}, err
+	}
+	saveSession(sessionID, user)
+	return sessionID, user, nil
+}
+
+// refreshUserToken swaps an expiring user token for a new one
+func refreshUserToken(ctx context.Context, sessionID string, user *User) error {
+	var token oauthTokenResponse
+	err := oauthPost(ctx, "/login/oauth/access_token", url.Values{
+		"client_id":     {oauthClientID},
+		"client_secret": {oauthClientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {user.RefreshToken},
+	}, &token)
+	if err != nil {
+		return err
+	}
+	if token.Error != "" {
+		return fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+
+	user.Token = token.AccessToken
+	user.RefreshToken = token.RefreshToken
+	user.ExpiresAt = time.Time{}
+	if token.ExpiresIn > 0 {
+		user.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	saveSession(sessionID, *user)
+	return nil
+}
+
+var tokenRefreshMu sync.Mutex
+
+// refreshIfExpiring refreshes the token of a session about to expire. A failed
+// refresh leaves the current token in place until it stops working.
+func refreshIfExpiring(sessionID string, user *User) {
+	if user.RefreshToken == "" || user.ExpiresAt.IsZero() || oauthClientSecret == "" {
+		return
+	}
+	if time.Until(user.ExpiresAt) > tokenRefreshMargin {
+		return
+	}
+
+	tokenRefreshMu.Lock()
+	defer tokenRefreshMu.Unlock()
+
+	// Another request may have refreshed it while this one waited
+	if current, ok := loadSession(sessionID); ok && current.Token != user.Token {
+		*user = current
+		return
+	}
+	if err := refreshUserToken(context.Background(), sessionID, user); err != nil {
+		logrus.Warnf("Failed to refresh token of %s: %v", user.Login, err)
+	}
+}
+
+func startDeviceFlow(c *gin.Context) {
+	if oauthClientID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device flow login is not configured; use a personal access token"})
+		return
+	}
+
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+		Error           string `json:"error"`
+	}
+	err := oauthPost(c.Request.Context(), "/login/device/code", url.Values{
+		"client_id": {oauthClientID},
+		"scope":     {oauthScopes},
+	}, &code)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to start device flow: %v", err)})
+		return
+	}
+	if code.Error != "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to start device flow: %s", code.Error)})
+		return
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+
+	flowID := newJobID()
+	deviceFlows.Lock()
+	// Drop flows nobody finished
+	for id, flow := range deviceFlows.flows {
+		if time.Now().After(flow.expiresAt) {
+			delete(deviceFlows.flows, id)
+		}
+	}
+	deviceFlows.flows[flowID] = &deviceFlow{
+		deviceCode: code.DeviceCode,
+		interval:   code.Interval,
+		expiresAt:  time.Now().Add(time.Duration(code.ExpiresIn) * time.Second),
+	}
+	deviceFlows.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"flow_id":          flowID,
+		"user_code":        code.UserCode,
+		"verification_uri": code.VerificationURI,
+		"expires_in":       code.ExpiresIn,
+		"interval":         code.Interval,
+	})
+}
+
+// handleAuthCallback completes a device flow. The browser calls it every
+// interval seconds until the user has entered the code at GitHub.
+func handleAuthCallback(c *gin.Context) {
+	flowID := c.Query("flow_id")
+
+	deviceFlows.Lock()
+	flow, ok := deviceFlows.flows[flowID]
+	deviceFlows.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown or finished login"})
+		return
+	}
+	if time.Now().After(flow.expiresAt) {
+		deviceFlows.Lock()
+		delete(deviceFlows.flows, flowID)
+		deviceFlows.Unlock()
+		c.JSON(http.StatusGone, gin.H{"error": "The code expired; start the login again"})
+		return
+	}
+
+	var token oauthTokenResponse
+	err := oauthPost(c.Request.Context(), "/login/oauth/access_token", url.Values{
+		"client_id":   {oauthClientID},
+		"device_code": {flow.deviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}, &token)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to poll for the token: %v", err)})
+		return
+	}
+
+	switch token.Error {
+	case "":
+	case "authorization_pending":
+		c.JSON(http.StatusAccepted, gin.H{"status": "pending", "interval": flow.interval})
+		return
+	case "slow_down":
+		deviceFlows.Lock()
+		if token.Interval > 0 {
+			flow.interval = token.Interval
+		} else {
+			flow.interval += 5
+		}
+		deviceFlows.Unlock()
+		c.JSON(http.StatusAccepted, gin.H{"status": "pending", "interval": flow.interval})
+		return
+	default:
+		deviceFlows.Lock()
+		delete(deviceFlows.flows, flowID)
+		deviceFlows.Unlock()
+		status := http.StatusBadRequest
+		switch token.Error {
+		case "access_denied":
+			status = http.StatusForbidden
+		case "expired_token":
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": fmt.Sprintf("Login failed: %s", token.Error)})
+		return
+	}
+
+	deviceFlows.Lock()
+	delete(deviceFlows.flows, flowID)
+	deviceFlows.Unlock()
+
+	sessionID, user, err := sessionFromOAuthToken(c.Request.Context(), &token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Authentication successful",
+		"user": gin.H{
+			"login":     user.Login,
+			"name":      user.Name,
+			"avatarUrl": user.AvatarURL,
+		},
+		"sessionId": sessionID,
+	})
+}
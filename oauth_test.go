@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeOAuth stands in for both the OAuth endpoints and the REST API. Polls of
+// the device code answer with the queued errors before handing out a token.
+type fakeOAuth struct {
+	mu       sync.Mutex
+	polls    []string
+	refreshs int
+}
+
+func (f *fakeOAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r.ParseForm()
+
+	switch {
+	case r.URL.Path == "/login/device/code":
+		json.NewEncoder(w).Encode(gin.H{"device_code": "dev-1", "user_code": "ABCD-1234", "verification_uri": "https://github.com/login/device", "expires_in": 900, "interval": 5})
+	case r.URL.Path == "/login/oauth/access_token" && r.Form.Get("grant_type") == "refresh_token":
+		f.refreshs++
+		if r.Form.Get("refresh_token") != "refresh-1" || r.Form.Get("client_secret") != "secret" {
+			json.NewEncoder(w).Encode(gin.H{"error": "bad_refresh_token"})
+			return
+		}
+		json.NewEncoder(w).Encode(gin.H{"access_token": "token-2", "refresh_token": "refresh-2", "expires_in": 28800})
+	case r.URL.Path == "/login/oauth/access_token":
+		if r.Form.Get("device_code") != "dev-1" {
+			json.NewEncoder(w).Encode(gin.H{"error": "incorrect_device_code"})
+			return
+		}
+		if len(f.polls) > 0 {
+			answer := f.polls[0]
+			f.polls = f.polls[1:]
+			json.NewEncoder(w).Encode(gin.H{"error": answer, "interval": 10})
+			return
+		}
+		// Expires inside the refresh margin, so the next request refreshes it
+		json.NewEncoder(w).Encode(gin.H{"access_token": "token-1", "refresh_token": "refresh-1", "expires_in": 60})
+	case r.URL.Path == "/api/v3/user":
+		json.NewEncoder(w).Encode(gin.H{"login": "octocat", "name": "The Octocat"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func useFakeOAuth(t *testing.T, fake *fakeOAuth) {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	previous := []string{oauthClientID, oauthClientSecret, oauthBaseURL, oauthAPIURL}
+	oauthClientID, oauthClientSecret = "client-1", "secret"
+	oauthBaseURL, oauthAPIURL = server.URL, server.URL+"/api/v3"
+	t.Cleanup(func() {
+		oauthClientID, oauthClientSecret, oauthBaseURL, oauthAPIURL = previous[0], previous[1], previous[2], previous[3]
+	})
+}
+
+func callHandler(handler gin.HandlerFunc, method, target string, header http.Header) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		c.Request.Header[name] = values
+	}
+	handler(c)
+
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w.Code, body
+}
+
+func TestDeviceFlowLoginAndRefresh(t *testing.T) {
+	fake := &fakeOAuth{polls: []string{"authorization_pending", "slow_down"}}
+	useFakeOAuth(t, fake)
+
+	status, body := callHandler(startDeviceFlow, "POST", "/api/auth/device", nil)
+	if status != http.StatusOK || body["user_code"] != "ABCD-1234" {
+		t.Fatalf("start: %d %v", status, body)
+	}
+	if _, leaked := body["device_code"]; leaked {
+		t.Fatalf("device code was sent to the browser")
+	}
+	callback := fmt.Sprintf("/api/auth/callback?flow_id=%s", body["flow_id"])
+
+	status, body = callHandler(handleAuthCallback, "GET", callback, nil)
+	if status != http.StatusAccepted || body["status"] != "pending" || body["interval"] != float64(5) {
+		t.Fatalf("pending: %d %v", status, body)
+	}
+	status, body = callHandler(handleAuthCallback, "GET", callback, nil)
+	if status != http.StatusAccepted || body["interval"] != float64(10) {
+		t.Fatalf("slow_down: %d %v", status, body)
+	}
+
+	status, body = callHandler(handleAuthCallback, "GET", callback, nil)
+	if status != http.StatusOK {
+		t.Fatalf("login: %d %v", status, body)
+	}
+	sessionID, _ := body["sessionId"].(string)
+	t.Cleanup(func() { deleteSession(sessionID) })
+	if user, ok := loadSession(sessionID); !ok || user.Login != "octocat" || user.Token != "token-1" {
+		t.Fatalf("expected a session for octocat, got %+v", user)
+	}
+
+	// The flow is done; a replayed poll must not log in again
+	if status, _ := callHandler(handleAuthCallback, "GET", callback, nil); status != http.StatusNotFound {
+		t.Fatalf("replayed poll: %d", status)
+	}
+
+	status, body = callHandler(getAuthStatus, "GET", "/api/auth/status", http.Header{"X-Session-Id": {sessionID}})
+	if status != http.StatusOK || body["login"] != "octocat" {
+		t.Fatalf("status: %d %v", status, body)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/repositories", nil)
+	c.Request.Header.Set("X-Session-ID", sessionID)
+	user, err := getAuthenticatedUser(c)
+	if err != nil || user.Token != "token-2" {
+		t.Fatalf("expected the expiring token to be refreshed, got %+v, %v", user, err)
+	}
+	if stored, _ := loadSession(sessionID); stored.Token != "token-2" || stored.RefreshToken != "refresh-2" || fake.refreshs != 1 {
+		t.Fatalf("expected the refreshed token to be stored once, got %+v after %d refreshes", stored, fake.refreshs)
+	}
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	useFakeOAuth(t, &fakeOAuth{polls: []string{"access_denied"}})
+
+	_, body := callHandler(startDeviceFlow, "POST", "/api/auth/device", nil)
+	callback := fmt.Sprintf("/api/auth/callback?flow_id=%s", body["flow_id"])
+	if status, body := callHandler(handleAuthCallback, "GET", callback, nil); status != http.StatusForbidden {
+		t.Fatalf("denied: %d %v", status, body)
+	}
+}
+
+// Run with -race: sessions are read and written from handlers, the scheduler
+// and the GitHub App transport at once
+func TestSessionsConcurrentAccess(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sessionID := testSession(t, fmt.Sprintf("user-%d", i), fmt.Sprintf("token-%d", i))
+			for j := 0; j < 100; j++ {
+				loadSession(sessionID)
+				sessionUser(fmt.Sprintf("token-%d", j%8))
+				schedulerClient(&ScheduledChange{CreatedBy: fmt.Sprintf("user-%d", j%8)})
+				saveSession(sessionID, User{Login: fmt.Sprintf("user-%d", i), Token: fmt.Sprintf("token-%d", i)})
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Required-keys policy. A YAML file declares, per environment name pattern,
+// which keys must exist, which are forbidden and how keys must be named:
+//
+//	environments:
+//	  - match: "prod*"
+//	    required_variables: [API_URL]
+//	    required_secrets: [DATABASE_PASSWORD]
+//	    forbidden: ["DEBUG*"]
+//	    naming: "^[A-Z][A-Z0-9_]*$"
+
+// PolicyRule applies to every environment whose name matches Match (a glob)
+type PolicyRule struct {
+	Match             string   `yaml:"match" json:"match"`
+	RequiredVariables []string `yaml:"required_variables" json:"required_variables,omitempty"`
+	RequiredSecrets   []string `yaml:"required_secrets" json:"required_secrets,omitempty"`
+	Forbidden         []string `yaml:"forbidden" json:"forbidden,omitempty"`
+	Naming            string   `yaml:"naming" json:"naming,omitempty"`
+
+	naming *regexp.Regexp
+}
+
+// Policy is the parsed policy file
+type Policy struct {
+	Environments []PolicyRule `yaml:"environments" json:"environments"`
+}
+
+// PolicyViolation describes a single failed policy check
+type PolicyViolation struct {
+	Environment string `json:"environment"`
+	Rule        string `json:"rule"`
+	Kind        string `json:"kind"` // "missing", "required", "forbidden" or "naming"
+	Type        string `json:"type"` // "variable" or "secret"
+	Name        string `json:"name"`
+	Message     string `json:"message"`
+}
+
+// activePolicy is loaded at startup from --policy; nil means no policy is enforced
+var activePolicy *Policy
+
+// loadPolicy reads and validates a policy file
+func loadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %v", err)
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %v", err)
+	}
+
+	for i := range policy.Environments {
+		rule := &policy.Environments[i]
+		if rule.Match == "" {
+			return nil, fmt.Errorf("policy rule %d has no 'match' pattern", i+1)
+		}
+		if _, err := path.Match(rule.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid match pattern %q: %v", rule.Match, err)
+		}
+		if rule.Naming != "" {
+			re, err := regexp.Compile(rule.Naming)
+			if err != nil {
+				return nil, fmt.Errorf("invalid naming rule %q: %v", rule.Naming, err)
+			}
+			rule.naming = re
+		}
+	}
+
+	return &policy, nil
+}
+
+// rulesFor returns the rules that apply to an environment. Environment names
+// are case-insensitive on GitHub, so the match is too.
+func (p *Policy) rulesFor(env string) []PolicyRule {
+	var rules []PolicyRule
+	if p == nil {
+		return rules
+	}
+	for _, rule := range p.Environments {
+		if matched, _ := path.Match(strings.ToLower(rule.Match), strings.ToLower(env)); matched {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(name)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKey validates a single key about to be written to an environment
+// against the naming and forbidden rules
+func (p *Policy) checkKey(env, keyType, name string) []PolicyViolation {
+	violations := []PolicyViolation{}
+	for _, rule := range p.rulesFor(env) {
+		if matchesAnyGlob(rule.Forbidden, name) {
+			violations = append(violations, PolicyViolation{
+				Environment: env, Rule: rule.Match, Kind: "forbidden", Type: keyType, Name: name,
+				Message: fmt.Sprintf("%s is forbidden in environments matching %s", name, rule.Match),
+			})
+		}
+		if rule.naming != nil && !rule.naming.MatchString(name) {
+			violations = append(violations, PolicyViolation{
+				Environment: env, Rule: rule.Match, Kind: "naming", Type: keyType, Name: name,
+				Message: fmt.Sprintf("%s does not match naming rule %s", name, rule.Naming),
+			})
+		}
+	}
+	return violations
+}
+
+// checkDelete rejects deleting a key an environment is required to define
+func (p *Policy) checkDelete(env, keyType, name string) []PolicyViolation {
+	violations := []PolicyViolation{}
+	for _, rule := range p.rulesFor(env) {
+		required := rule.RequiredVariables
+		if keyType == "secret" {
+			required = rule.RequiredSecrets
+		}
+		for _, requiredName := range required {
+			if strings.EqualFold(requiredName, name) {
+				violations = append(violations, PolicyViolation{
+					Environment: env, Rule: rule.Match, Kind: "required", Type: keyType, Name: name,
+					Message: fmt.Sprintf("%s %s is required in environments matching %s and can't be deleted", keyType, name, rule.Match),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// evaluate checks every environment of a repository snapshot. Required keys may be
+// satisfied at environment, repository or organization level, since that is what
+// a job running in the environment would see.
+func (p *Policy) evaluate(snap *RepoSnapshot, orgVars []Variable, orgSecrets []Secret) []PolicyViolation {
+	violations := []PolicyViolation{}
+
+	inheritedVars := append(variableNames(snap.Variables), variableNames(orgVars)...)
+	inheritedSecrets := append(secretNames(snap.Secrets), secretNames(orgSecrets)...)
+
+	for _, env := range snap.Environments {
+		envVars, envSecrets := variableNames(env.Variables), secretNames(env.Secrets)
+
+		for _, rule := range p.rulesFor(env.Name) {
+			for _, name := range rule.RequiredVariables {
+				name = strings.ToUpper(name)
+				if !contains(envVars, name) && !contains(inheritedVars, name) {
+					violations = append(violations, PolicyViolation{
+						Environment: env.Name, Rule: rule.Match, Kind: "missing", Type: "variable", Name: name,
+						Message: fmt.Sprintf("required variable %s is not defined", name),
+					})
+				}
+			}
+			for _, name := range rule.RequiredSecrets {
+				name = strings.ToUpper(name)
+				if !contains(envSecrets, name) && !contains(inheritedSecrets, name) {
+					violations = append(violations, PolicyViolation{
+						Environment: env.Name, Rule: rule.Match, Kind: "missing", Type: "secret", Name: name,
+						Message: fmt.Sprintf("required secret %s is not defined", name),
+					})
+				}
+			}
+		}
+
+		for _, v := range env.Variables {
+			violations = append(violations, p.checkKey(env.Name, "variable", v.Name)...)
+		}
+		for _, s := range env.Secrets {
+			violations = append(violations, p.checkKey(env.Name, "secret", s.Name)...)
+		}
+	}
+
+	return violations
+}
+
+// runPolicyCheck fetches live data for a repository and evaluates the policy against it
+func runPolicyCheck(client *github.Client, ctx context.Context, policy *Policy, owner, repo string) ([]PolicyViolation, error) {
+	snap, err := fetchRepoSnapshot(client, ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	orgVars, err := listRepoOrgVariables(client, ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch organization variables: %v", err)
+	}
+	orgSecrets, err := listRepoOrgSecrets(client, ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch organization secrets: %v", err)
+	}
+
+	return policy.evaluate(snap, orgVars, orgSecrets), nil
+}
+
+// rejectPolicyViolations is the pre-write guard used by the create/update/delete/sync
+// handlers. It writes a 422 response and returns true when the write must not proceed.
+func rejectPolicyViolations(c *gin.Context, violations []PolicyViolation) bool {
+	if len(violations) == 0 {
+		return false
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":      "Change rejected by policy",
+		"violations": violations,
+	})
+	return true
+}
+
+func checkPolicy(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	if activePolicy == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No policy configured, start the server with --policy"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	violations, err := runPolicyCheck(client, ctx, activePolicy, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repo":       owner + "/" + repo,
+		"passed":     len(violations) == 0,
+		"violations": violations,
+	})
+}
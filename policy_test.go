@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testPolicy(t *testing.T, content string) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := loadPolicy(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := activePolicy
+	activePolicy = policy
+	t.Cleanup(func() { activePolicy = previous })
+}
+
+func TestPolicyRejectsDeletingRequiredKeys(t *testing.T) {
+	testPolicy(t, `
+environments:
+  - match: "Prod*"
+    required_variables: [api_url]
+    required_secrets: [DATABASE_PASSWORD]
+`)
+
+	if violations := activePolicy.checkDelete("production", "variable", "API_URL"); len(violations) != 1 || violations[0].Kind != "required" {
+		t.Fatalf("expected the required variable to be protected, got %+v", violations)
+	}
+	if violations := activePolicy.checkDelete("PRODUCTION", "secret", "database_password"); len(violations) != 1 {
+		t.Fatalf("expected the required secret to be protected, got %+v", violations)
+	}
+	if violations := activePolicy.checkDelete("production", "secret", "API_URL"); len(violations) != 0 {
+		t.Fatalf("a secret named like a required variable isn't required, got %+v", violations)
+	}
+	if violations := activePolicy.checkDelete("staging", "variable", "API_URL"); len(violations) != 0 {
+		t.Fatalf("staging has no rules, got %+v", violations)
+	}
+
+	// The handler rejects the delete before touching GitHub
+	sessionID := testSession(t, "octocat", "token")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("DELETE", "/api/repos/acme/app/environments/Production/variables/API_URL", nil)
+	c.Request.Header.Set("X-Session-ID", sessionID)
+	c.Params = gin.Params{{Key: "owner", Value: "acme"}, {Key: "repo", Value: "app"}, {Key: "env", Value: "Production"}, {Key: "name", Value: "API_URL"}}
+	deleteEnvironmentVariable(c)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Role-based access control for shared deployments. A YAML file maps GitHub
+// users and teams to roles on orgs, repositories and environments:
+//
+//	default_role: viewer
+//	rules:
+//	  - role: editor
+//	    teams: [acme/platform]
+//	    environments: ["production*"]
+//	  - role: viewer
+//	    environments: ["production*"]
+//	  - role: editor
+//	    repos: ["acme/*"]
+//
+// For each target of a request the first rule that matches both the user and the
+// target decides the role; default_role applies when none does. A rule without
+// users and teams matches everyone, and a rule only matches targets that have
+// every field it restricts (a rule with environments never matches repository
+// keys). The check runs as middleware, on targets taken from the route, the
+// query and the JSON body, before any handler sees the request.
+
+// Roles in increasing order of rights
+var rbacRoles = []string{"none", "viewer", "editor", "approver", "admin"}
+
+// Actions and the role each needs
+var rbacActionRoles = map[string]string{
+	"read":    "viewer",
+	"write":   "editor",
+	"approve": "approver",
+	"admin":   "admin",
+}
+
+func rbacRoleLevel(role string) int {
+	for i, name := range rbacRoles {
+		if name == role {
+			return i
+		}
+	}
+	return 0
+}
+
+// RBACRule grants Role to matching users or teams on matching targets
+type RBACRule struct {
+	Role         string   `yaml:"role" json:"role"`
+	Users        []string `yaml:"users" json:"users,omitempty"` // logins, "*" for everyone
+	Teams        []string `yaml:"teams" json:"teams,omitempty"` // "org/team-slug"
+	Orgs         []string `yaml:"orgs" json:"orgs,omitempty"`
+	Repos        []string `yaml:"repos" json:"repos,omitempty"` // "owner/repo" globs
+	Environments []string `yaml:"environments" json:"environments,omitempty"`
+}
+
+// RBACConfig is the parsed RBAC file
+type RBACConfig struct {
+	DefaultRole string     `yaml:"default_role" json:"default_role"`
+	Rules       []RBACRule `yaml:"rules" json:"rules"`
+}
+
+// activeRBAC is loaded at startup from --rbac; nil means no RBAC
+var activeRBAC *RBACConfig
+
+// loadRBAC reads and validates an RBAC file
+func loadRBAC(file string) (*RBACConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RBAC file: %v", err)
+	}
+
+	var config RBACConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse RBAC file: %v", err)
+	}
+
+	if config.DefaultRole == "" {
+		config.DefaultRole = "none"
+	}
+	if !contains(rbacRoles, config.DefaultRole) {
+		return nil, fmt.Errorf("unknown default_role %q", config.DefaultRole)
+	}
+	for i, rule := range config.Rules {
+		if !contains(rbacRoles, rule.Role) {
+			return nil, fmt.Errorf("RBAC rule %d has unknown role %q", i+1, rule.Role)
+		}
+		for _, team := range rule.Teams {
+			if !strings.Contains(team, "/") {
+				return nil, fmt.Errorf("RBAC rule %d: team %q must be 'org/team-slug'", i+1, team)
+			}
+		}
+		for _, patterns := range [][]string{rule.Orgs, rule.Repos, rule.Environments} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("RBAC rule %d: invalid pattern %q: %v", i+1, pattern, err)
+				}
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// RBACTarget is what a request reads or writes
+type RBACTarget struct {
+	Org         string `json:"org,omitempty"`
+	Repo        string `json:"repo,omitempty"` // "owner/repo"
+	Environment string `json:"environment,omitempty"`
+}
+
+func (t RBACTarget) String() string {
+	switch {
+	case t.Environment != "":
+		return t.Repo + ":" + t.Environment
+	case t.Repo != "":
+		return t.Repo
+	}
+	return "org " + t.Org
+}
+
+// RBACPrincipal is a user with the teams they belong to
+type RBACPrincipal struct {
+	Login string
+	Teams []string // "org/team-slug", lowercase
+}
+
+// matchesGlob matches case-insensitively, like GitHub's owner, repository and
+// environment names
+func matchesGlob(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RBACRule) matchesPrincipal(principal RBACPrincipal) bool {
+	if len(r.Users) == 0 && len(r.Teams) == 0 {
+		return true
+	}
+	for _, user := range r.Users {
+		if user == "*" || strings.EqualFold(user, principal.Login) {
+			return true
+		}
+	}
+	for _, team := range r.Teams {
+		if contains(principal.Teams, strings.ToLower(team)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RBACRule) matchesTarget(target RBACTarget) bool {
+	if len(r.Orgs) > 0 && (target.Org == "" || !matchesGlob(r.Orgs, target.Org)) {
+		return false
+	}
+	if len(r.Repos) > 0 && (target.Repo == "" || !matchesGlob(r.Repos, target.Repo)) {
+		return false
+	}
+	if len(r.Environments) > 0 && (target.Environment == "" || !matchesGlob(r.Environments, target.Environment)) {
+		return false
+	}
+	return true
+}
+
+// roleFor returns the role of principal on target
+func (c *RBACConfig) roleFor(principal RBACPrincipal, target RBACTarget) string {
+	for _, rule := range c.Rules {
+		if rule.matchesPrincipal(principal) && rule.matchesTarget(target) {
+			return rule.Role
+		}
+	}
+	return c.DefaultRole
+}
+
+// highestRole returns the best role principal has anywhere; it gates requests
+// without a target, such as listing repositories
+func (c *RBACConfig) highestRole(principal RBACPrincipal) string {
+	best := c.DefaultRole
+	for _, rule := range c.Rules {
+		if rule.matchesPrincipal(principal) && rbacRoleLevel(rule.Role) > rbacRoleLevel(best) {
+			best = rule.Role
+		}
+	}
+	return best
+}
+
+// RBACDenial is a target the user's role doesn't cover
+type RBACDenial struct {
+	Target   string `json:"target"`
+	Role     string `json:"role"`
+	Required string `json:"required"`
+}
+
+// denied returns the targets principal may not perform action on
+func (c *RBACConfig) denied(principal RBACPrincipal, action string, targets []RBACTarget) []RBACDenial {
+	required := rbacActionRoles[action]
+	denials := []RBACDenial{}
+	if len(targets) == 0 {
+		if role := c.highestRole(principal); rbacRoleLevel(role) < rbacRoleLevel(required) {
+			denials = append(denials, RBACDenial{Target: "*", Role: role, Required: required})
+		}
+		return denials
+	}
+	for _, target := range targets {
+		if role := c.roleFor(principal, target); rbacRoleLevel(role) < rbacRoleLevel(required) {
+			denials = append(denials, RBACDenial{Target: target.String(), Role: role, Required: required})
+		}
+	}
+	return denials
+}
+
+func (c *RBACConfig) usesTeams() bool {
+	for _, rule := range c.Rules {
+		if len(rule.Teams) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+const rbacTeamCacheTTL = 10 * time.Minute
+
+var rbacTeamCache = struct {
+	sync.Mutex
+	entries map[string]cachedTeams
+}{entries: make(map[string]cachedTeams)}
+
+type cachedTeams struct {
+	at    time.Time
+	teams []string
+}
+
+// userTeams returns the teams of the token's user as lowercase "org/team-slug"
+func userTeams(client *github.Client, ctx context.Context, token string) ([]string, error) {
+	key := capabilityCacheKey(token, "teams")
+	rbacTeamCache.Lock()
+	cached, ok := rbacTeamCache.entries[key]
+	rbacTeamCache.Unlock()
+	if ok && time.Since(cached.at) < rbacTeamCacheTTL {
+		return cached.teams, nil
+	}
+
+	teams := []string{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.Teams.ListUserTeams(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list teams: %v", err)
+		}
+		for _, team := range page {
+			teams = append(teams, strings.ToLower(team.GetOrganization().GetLogin()+"/"+team.GetSlug()))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	rbacTeamCache.Lock()
+	rbacTeamCache.entries[key] = cachedTeams{at: time.Now(), teams: teams}
+	rbacTeamCache.Unlock()
+	return teams, nil
+}
+
+// rbacPrincipal builds the principal of a user, looking up teams only when a
+// rule needs them
+func rbacPrincipal(user *User) (RBACPrincipal, error) {
+	principal := RBACPrincipal{Login: user.Login}
+	if !activeRBAC.usesTeams() {
+		return principal, nil
+	}
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+	teams, err := userTeams(client, context.Background(), user.Token)
+	if err != nil {
+		return principal, err
+	}
+	principal.Teams = teams
+	return principal, nil
+}
+
+// rbacRouteActions overrides the action of routes whose method is misleading
+var rbacRouteActions = map[string]string{
+	"POST /api/repos/refresh":                 "read",
+	"POST /api/bundles/export":                "read",
+	"POST /api/sops/export":                   "read",
+	"POST /api/export/terraform":              "read",
+	"POST /api/export":                        "read",
+	"PUT /api/environment-templates/:name":    "admin",
+	"DELETE /api/environment-templates/:name": "admin",
+	"GET /api/app/audit":                      "admin",
+	"POST /api/change-requests/:id/approve":   "approve",
+	// Requesters withdraw their own requests; the handler checks everyone else
+	"POST /api/change-requests/:id/reject": "read",
+}
+
+// requestAction returns what a request does: reads, writes, approves or administers
+func requestAction(c *gin.Context, body map[string]interface{}) string {
+	if action, ok := rbacRouteActions[c.Request.Method+" "+c.FullPath()]; ok {
+		return action
+	}
+	if c.Request.Method == http.MethodGet {
+		return "read"
+	}
+	// Previews change nothing
+	if dryRun, _ := body["dry_run"].(bool); dryRun {
+		return "read"
+	}
+	if c.FullPath() == "/api/value-templates/resolve" {
+		if apply, _ := body["apply"].(bool); !apply {
+			return "read"
+		}
+	}
+	return "write"
+}
+
+// bodyStrings returns a string or the strings of an array
+func bodyStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// rbacTargetFromString parses "owner/repo" or "owner/repo:env"
+func rbacTargetFromString(target string) (RBACTarget, bool) {
+	loc, err := targetLocation(target, "variable", "")
+	if err != nil {
+		return RBACTarget{}, false
+	}
+	owner, _, _ := parseRepo(loc.Repo)
+	return RBACTarget{Org: owner, Repo: loc.Repo, Environment: loc.Environment}, true
+}
+
+// sourceRoutes name the source of a copy in their route; it is only read, and
+// the body names the target that is written
+var sourceRoutes = []string{
+	"/api/repos/:owner/:repo/environments/:env/clone",
+}
+
+// sourceTargets returns the route target of a source route, which needs read access only
+func sourceTargets(c *gin.Context) []RBACTarget {
+	owner, repo := c.Param("owner"), c.Param("repo")
+	if !contains(sourceRoutes, c.FullPath()) || owner == "" || repo == "" {
+		return nil
+	}
+	return []RBACTarget{{Org: owner, Repo: owner + "/" + repo, Environment: c.Param("env")}}
+}
+
+// requestTargets collects the orgs, repositories and environments a request
+// names in its route, query and JSON body. The route of a source route isn't
+// one of them; see sourceTargets.
+func requestTargets(c *gin.Context, body map[string]interface{}) []RBACTarget {
+	var repos, envs, orgs []string
+	targets := []RBACTarget{}
+
+	add := func(list *[]string, values ...string) {
+		for _, value := range values {
+			if value != "" && !contains(*list, value) {
+				*list = append(*list, value)
+			}
+		}
+	}
+
+	if !contains(sourceRoutes, c.FullPath()) {
+		if owner, repo := c.Param("owner"), c.Param("repo"); owner != "" && repo != "" {
+			add(&repos, owner+"/"+repo)
+		}
+		add(&envs, c.Param("env"))
+	}
+	add(&repos, c.QueryArray("repo")...)
+	add(&repos, c.QueryArray("repos")...)
+	add(&orgs, c.QueryArray("org")...)
+	add(&envs, c.QueryArray("env")...)
+	add(&envs, c.QueryArray("environment")...)
+
+	for _, key := range []string{"repo", "repos", "target_repos"} {
+		add(&repos, bodyStrings(body[key])...)
+	}
+	for _, key := range []string{"env", "envs", "environment", "environments", "target_envs"} {
+		add(&envs, bodyStrings(body[key])...)
+	}
+	for _, key := range []string{"org", "orgs"} {
+		add(&orgs, bodyStrings(body[key])...)
+	}
+	for _, key := range []string{"target", "targets"} {
+		for _, target := range bodyStrings(body[key]) {
+			if t, ok := rbacTargetFromString(target); ok {
+				targets = append(targets, t)
+			}
+		}
+	}
+	if targetMap, ok := body["target_map"].(map[string]interface{}); ok {
+		for _, target := range targetMap {
+			for _, value := range bodyStrings(target) {
+				if t, ok := rbacTargetFromString(value); ok {
+					targets = append(targets, t)
+				}
+			}
+		}
+	}
+	// Key locations, e.g. the source and target of a move
+	for _, key := range []string{"location", "source", "target"} {
+		if loc, ok := body[key].(map[string]interface{}); ok {
+			t := RBACTarget{}
+			t.Org, _ = loc["org"].(string)
+			t.Repo, _ = loc["repo"].(string)
+			t.Environment, _ = loc["environment"].(string)
+			if t.Repo != "" {
+				t.Org, _, _ = parseRepo(t.Repo)
+			}
+			if t.Org != "" || t.Repo != "" {
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	for _, repo := range repos {
+		owner, _, err := parseRepo(repo)
+		if err != nil {
+			continue
+		}
+		if len(envs) == 0 {
+			targets = append(targets, RBACTarget{Org: owner, Repo: repo})
+		}
+		for _, env := range envs {
+			targets = append(targets, RBACTarget{Org: owner, Repo: repo, Environment: env})
+		}
+	}
+	for _, org := range orgs {
+		targets = append(targets, RBACTarget{Org: org})
+	}
+	return targets
+}
+
+// rejectRBACDenied writes a 403 listing the targets the user's role doesn't cover
+func rejectRBACDenied(c *gin.Context, denials []RBACDenial) bool {
+	if len(denials) == 0 {
+		return false
+	}
+	names := make([]string, len(denials))
+	for i, denial := range denials {
+		names[i] = denial.Target
+	}
+	sort.Strings(names)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":   fmt.Sprintf("Your role doesn't allow this on %s (needs %s)", strings.Join(names, ", "), denials[0].Required),
+		"targets": denials,
+	})
+	return true
+}
+
+// checkRBAC checks a user's role on targets a handler only learns about after
+// reading the request, such as the contents of a bundle
+func checkRBAC(c *gin.Context, user *User, action string, targets []RBACTarget) bool {
+	if activeRBAC == nil {
+		return true
+	}
+	principal, err := rbacPrincipal(user)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Failed to check your role: %v", err)})
+		return false
+	}
+	return !rejectRBACDenied(c, activeRBAC.denied(principal, action, targets))
+}
+
+// requestBody decodes a request body for a middleware and puts it back for the
+// handler. Handlers bind JSON whatever the Content-Type says, so the body is
+// decoded the same way; a body that isn't a JSON object is an error rather than
+// a request without targets.
+func requestBody(c *gin.Context) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	if c.Request.Body == nil {
+		return body, nil
+	}
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(content))
+	if len(bytes.TrimSpace(content)) == 0 {
+		return body, nil
+	}
+	if err := json.Unmarshal(content, &body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// rbacMiddleware enforces the RBAC file on every API request of a logged in user
+func rbacMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if activeRBAC == nil || strings.HasPrefix(c.FullPath(), "/api/auth/") {
+			c.Next()
+			return
+		}
+		user, err := getAuthenticatedUser(c)
+		if err != nil {
+			// The handler answers with 401
+			c.Next()
+			return
+		}
+
+		body, err := requestBody(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		targets := []RBACTarget{}
+		for _, target := range requestTargets(c, body) {
+			if !containsTarget(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+		if checkRBAC(c, user, "read", sourceTargets(c)) && checkRBAC(c, user, requestAction(c, body), targets) {
+			c.Next()
+		}
+	}
+}
+
+// locationTargets returns the targets of key locations
+func locationTargets(locations []KeyLocation) []RBACTarget {
+	targets := []RBACTarget{}
+	for _, loc := range locations {
+		target := RBACTarget{Org: loc.Org, Repo: loc.Repo, Environment: loc.Environment}
+		if loc.Repo != "" {
+			target.Org, _, _ = parseRepo(loc.Repo)
+		}
+		if !containsTarget(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+func containsTarget(targets []RBACTarget, target RBACTarget) bool {
+	for _, existing := range targets {
+		if existing == target {
+			return true
+		}
+	}
+	return false
+}
+
+func getRBAC(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	if activeRBAC == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+
+	principal, err := rbacPrincipal(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":      true,
+		"default_role": activeRBAC.DefaultRole,
+		"rules":        activeRBAC.Rules,
+		"you":          gin.H{"login": principal.Login, "teams": principal.Teams},
+	})
+}
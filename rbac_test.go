@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testRBACRouter(t *testing.T, config *RBACConfig, login string) (*gin.Engine, string) {
+	t.Helper()
+	previousRBAC := activeRBAC
+	activeRBAC = config
+	t.Cleanup(func() { activeRBAC = previousRBAC })
+
+	sessionID := testSession(t, login, "test-token")
+	router := gin.New()
+	api := router.Group("/api")
+	api.Use(rbacMiddleware())
+	api.POST("/batch", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	return router, sessionID
+}
+
+func TestRBACMiddlewareReadsBodyWhateverTheContentType(t *testing.T) {
+	config := &RBACConfig{DefaultRole: "viewer", Rules: []RBACRule{
+		{Role: "editor", Repos: []string{"acme/sandbox"}},
+	}}
+	router, sessionID := testRBACRouter(t, config, "dev")
+
+	for _, contentType := range []string{"application/json", "text/plain", ""} {
+		req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"targets":["acme/svc:production"]}`))
+		req.Header.Set("X-Session-ID", sessionID)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Content-Type %q: got status %d, want 403", contentType, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`not json`))
+	req.Header.Set("X-Session-ID", sessionID)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unparseable body: got status %d, want 400", rec.Code)
+	}
+}
+
+func TestRBACMatchesNamesCaseInsensitively(t *testing.T) {
+	config := &RBACConfig{DefaultRole: "viewer", Rules: []RBACRule{
+		{Role: "editor", Teams: []string{"acme/platform"}, Environments: []string{"production*"}},
+		{Role: "viewer", Environments: []string{"production*"}},
+		{Role: "editor", Repos: []string{"acme/*"}},
+	}}
+	principal := RBACPrincipal{Login: "dev"}
+
+	for _, target := range []RBACTarget{
+		{Org: "acme", Repo: "acme/svc", Environment: "production"},
+		{Org: "acme", Repo: "acme/svc", Environment: "Production"},
+		{Org: "ACME", Repo: "ACME/svc", Environment: "PRODUCTION-eu"},
+	} {
+		if role := config.roleFor(principal, target); role != "viewer" {
+			t.Errorf("%s: got role %s, want viewer", target, role)
+		}
+	}
+	if role := config.roleFor(principal, RBACTarget{Org: "Acme", Repo: "Acme/svc"}); role != "editor" {
+		t.Errorf("Acme/svc: got role %s, want editor", role)
+	}
+}
+
+func TestNewSessionIDIsRandom(t *testing.T) {
+	first, err := newSessionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := newSessionID()
+	if first == second || len(first) < 64 {
+		t.Errorf("session IDs %q and %q aren't random", first, second)
+	}
+}
+
+// Sync is authorized per target environment, so it must write there and not
+// to the repository an environment-scoped editor may not touch
+func TestSyncWritesTheEnvironmentsItIsAuthorizedFor(t *testing.T) {
+	testStore(t)
+	var mu sync.Mutex
+	writes := []string{}
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/source/actions/variables":
+			json.NewEncoder(w).Encode(gin.H{"total_count": 1, "variables": []gin.H{{"name": "API_URL", "value": "https://api"}}})
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/target":
+			json.NewEncoder(w).Encode(gin.H{"id": 1, "permissions": gin.H{"push": true}})
+		case r.Method != http.MethodGet && !strings.Contains(r.URL.Path, "GEM_PREFLIGHT_"):
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	config := &RBACConfig{DefaultRole: "viewer", Rules: []RBACRule{
+		{Role: "editor", Repos: []string{"acme/target"}, Environments: []string{"staging*"}},
+	}}
+	router, sessionID := testRBACRouter(t, config, "dev")
+	router.Group("/api", rbacMiddleware()).POST("/sync", syncVariables)
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/sync", strings.NewReader(body))
+		req.Header.Set("X-Session-ID", sessionID)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if status := post(`{"source_repo":"acme/source","target_repos":["acme/target"]}`); status != http.StatusForbidden {
+		t.Fatalf("repository sync by an environment editor: got %d, want 403", status)
+	}
+	if status := post(`{"source_repo":"acme/source","target_repos":["acme/target"],"target_envs":["staging"]}`); status != http.StatusOK {
+		t.Fatalf("environment sync: got %d", status)
+	}
+	if len(writes) != 1 || writes[0] != "PATCH /repos/acme/target/environments/staging/variables/API_URL" {
+		t.Fatalf("expected only the staging variable to be written, got %v", writes)
+	}
+}
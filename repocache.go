@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Per-session repository listing cache. Within the TTL the listing is served
+// from memory; after it expires every listing page is revalidated with its
+// ETag, so unchanged pages come back as 304 and don't count against the rate limit.
+
+const repoCacheTTL = 10 * time.Minute
+
+type cachedRepoPage struct {
+	etag     string
+	repos    []Repository
+	nextPage int
+}
+
+type repoCacheEntry struct {
+	repos     []Repository
+	pages     map[string]*cachedRepoPage
+	fetchedAt time.Time
+}
+
+type repoListingCache struct {
+	mu      sync.Mutex
+	entries map[string]*repoCacheEntry
+}
+
+var repoCache = &repoListingCache{entries: make(map[string]*repoCacheEntry)}
+
+// get returns the cached repositories of a session, revalidating them when the
+// TTL has expired or a refresh is forced. When revalidation fails the previous
+// entry is kept and the error returned.
+func (rc *repoListingCache) get(sessionID string, client *github.Client, ctx context.Context, force bool) ([]Repository, time.Time, error) {
+	rc.mu.Lock()
+	entry, exists := rc.entries[sessionID]
+	if exists && !force && time.Since(entry.fetchedAt) < repoCacheTTL {
+		repos, fetchedAt := entry.repos, entry.fetchedAt
+		rc.mu.Unlock()
+		return repos, fetchedAt, nil
+	}
+
+	// Copy the known pages so revalidation can run without holding the lock
+	pages := make(map[string]*cachedRepoPage)
+	if exists {
+		for url, page := range entry.pages {
+			pages[url] = page
+		}
+	}
+	rc.mu.Unlock()
+
+	repos, err := fetchAllRepositories(client, ctx, pages)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	fetchedAt := time.Now()
+
+	rc.mu.Lock()
+	rc.entries[sessionID] = &repoCacheEntry{repos: repos, pages: pages, fetchedAt: fetchedAt}
+	rc.mu.Unlock()
+
+	return repos, fetchedAt, nil
+}
+
+// fetchRepositoryPage fetches one listing page, sending the cached ETag so an
+// unchanged page is answered with 304 Not Modified
+func fetchRepositoryPage(client *github.Client, ctx context.Context, repoType string, page int, pages map[string]*cachedRepoPage) (*cachedRepoPage, error) {
+	url := fmt.Sprintf("user/repos?type=%s&page=%d&per_page=100", repoType, page)
+
+	req, err := client.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cached, hasCached := pages[url]
+	if hasCached && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	var repos []*github.Repository
+	resp, err := client.Do(ctx, req, &repos)
+	if resp != nil && resp.StatusCode == http.StatusNotModified && hasCached {
+		return cached, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := &cachedRepoPage{
+		etag:     resp.Header.Get("ETag"),
+		nextPage: resp.NextPage,
+	}
+	for _, repo := range repos {
+		fetched.repos = append(fetched.repos, convertRepository(repo))
+	}
+	pages[url] = fetched
+
+	return fetched, nil
+}
+
+func convertRepository(repo *github.Repository) Repository {
+	return Repository{
+		ID:          repo.GetID(),
+		Name:        repo.GetName(),
+		FullName:    repo.GetFullName(),
+		Description: repo.GetDescription(),
+		Private:     repo.GetPrivate(),
+		Visibility:  repo.GetVisibility(),
+		Archived:    repo.GetArchived(),
+		Topics:      repo.Topics,
+		Owner: Owner{
+			Login: repo.Owner.GetLogin(),
+			ID:    repo.Owner.GetID(),
+		},
+	}
+}
+
+// filterRepositories applies the server-side listing filters. Empty filters match everything.
+func filterRepositories(repos []Repository, org, visibility, archived, topic string) []Repository {
+	if org == "" && visibility == "" && archived == "" && topic == "" {
+		return repos
+	}
+
+	var filtered []Repository
+	for _, repo := range repos {
+		if org != "" && !strings.EqualFold(repo.Owner.Login, org) {
+			continue
+		}
+		if visibility != "" && !strings.EqualFold(repoVisibility(repo), visibility) {
+			continue
+		}
+		if archived != "" && (archived == "true") != repo.Archived {
+			continue
+		}
+		if topic != "" && !contains(repo.Topics, strings.ToLower(topic)) {
+			continue
+		}
+		filtered = append(filtered, repo)
+	}
+	return filtered
+}
+
+// repoVisibility falls back to the private flag when the API omits visibility
+func repoVisibility(repo Repository) string {
+	if repo.Visibility != "" {
+		return repo.Visibility
+	}
+	if repo.Private {
+		return "private"
+	}
+	return "public"
+}
+
+func refreshRepositories(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	sessionID := c.GetHeader("X-Session-ID")
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	repos, fetchedAt, err := repoCache.get(sessionID, client, ctx, true)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to refresh repositories: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Repository cache refreshed",
+		"total_count": len(repos),
+		"cached_at":   fetchedAt.UTC().Format("2006-01-02T15:04:05Z"),
+	})
+}
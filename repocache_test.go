@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+func TestRepoCacheKeepsPreviousListingWhenAPageFails(t *testing.T) {
+	var failing atomic.Bool
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			if failing.Load() {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			json.NewEncoder(w).Encode([]gin.H{{"id": 2, "name": "two", "full_name": "acme/two", "owner": gin.H{"login": "acme"}}})
+			return
+		}
+		w.Header().Set("Link", `<https://api.github.com/user/repos?page=2>; rel="next"`)
+		json.NewEncoder(w).Encode([]gin.H{{"id": 1, "name": "one", "full_name": "acme/one", "owner": gin.H{"login": "acme"}}})
+	}))
+
+	cache := &repoListingCache{entries: make(map[string]*repoCacheEntry)}
+	client := github.NewClient(nil).WithAuthToken("token")
+	repos, fetchedAt, err := cache.get("session", client, context.Background(), false)
+	if err != nil || len(repos) != 2 {
+		t.Fatalf("expected both repositories, got %v, %v", repos, err)
+	}
+
+	failing.Store(true)
+	if _, _, err := cache.get("session", client, context.Background(), true); err == nil {
+		t.Fatalf("expected the failed page to fail the refresh")
+	}
+	if entry := cache.entries["session"]; len(entry.repos) != 2 || !entry.fetchedAt.Equal(fetchedAt) {
+		t.Fatalf("expected the previous listing to be kept, got %+v", entry)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Bulk secret rotation. A rotation finds every repository, environment and org
+// scope that holds a secret, writes the new value to all of them as one job that
+// runs in the background, then reads the secrets back and reports every target
+// whose update time didn't move (stragglers). Successful writes are recorded in
+// the local store.
+
+// RotationRecord is one successful rotation of a secret at one location
+type RotationRecord struct {
+	JobID     string      `json:"job_id"`
+	Location  KeyLocation `json:"location"`
+	RotatedBy string      `json:"rotated_by"`
+	RotatedAt string      `json:"rotated_at"`
+}
+
+// RotationTarget is one location of a rotation job
+type RotationTarget struct {
+	Location  KeyLocation `json:"location"`
+	Status    string      `json:"status"` // "pending", "updated", "verified", "failed" or "straggler"
+	Error     string      `json:"error,omitempty"`
+	UpdatedAt string      `json:"updated_at,omitempty"`
+}
+
+// RotationJob tracks a rotation across all of its targets
+type RotationJob struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	User       string           `json:"user"`
+	Status     string           `json:"status"` // "running", "completed" or "completed_with_errors"
+	StartedAt  string           `json:"started_at"`
+	FinishedAt string           `json:"finished_at,omitempty"`
+	Targets    []RotationTarget `json:"targets"`
+	Stragglers []string         `json:"stragglers"`
+	PublicKey  string           `json:"public_key,omitempty"`
+}
+
+type rotationJobList struct {
+	mu   sync.Mutex
+	jobs map[string]*RotationJob
+}
+
+var rotationJobs = &rotationJobList{jobs: make(map[string]*RotationJob)}
+
+// get returns a copy of a job so it can be serialized while the job is running
+func (l *rotationJobList) get(id string) (RotationJob, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	job, ok := l.jobs[id]
+	if !ok {
+		return RotationJob{}, false
+	}
+	copied := *job
+	copied.Targets = append([]RotationTarget(nil), job.Targets...)
+	copied.Stragglers = append([]string(nil), job.Stragglers...)
+	return copied, true
+}
+
+func (l *rotationJobList) forUser(login string) []RotationJob {
+	l.mu.Lock()
+	ids := []string{}
+	for id, job := range l.jobs {
+		if job.User == login {
+			ids = append(ids, id)
+		}
+	}
+	l.mu.Unlock()
+
+	jobs := []RotationJob{}
+	for _, id := range ids {
+		if job, ok := l.get(id); ok {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt > jobs[j].StartedAt })
+	return jobs
+}
+
+func (l *rotationJobList) setTarget(id string, index int, target RotationTarget) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jobs[id].Targets[index] = target
+}
+
+func newJobID() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// discoverSecretLocations finds every scope of the given repositories and orgs that holds the secret
+func discoverSecretLocations(client *github.Client, ctx context.Context, name string, repos, orgs []string) ([]KeyLocation, []string) {
+	locations := []KeyLocation{}
+	errors := []string{}
+
+	for _, fullName := range repos {
+		owner, repo, err := parseRepo(fullName)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		secrets, err := listRepoSecrets(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", fullName, err))
+			continue
+		}
+		if hasSecret(secrets, name) {
+			locations = append(locations, KeyLocation{Scope: "repository", Type: "secret", Repo: fullName, Name: name})
+		}
+
+		envNames, err := listEnvironmentNames(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", fullName, err))
+			continue
+		}
+		if len(envNames) == 0 {
+			continue
+		}
+		repoID, err := repositoryID(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", fullName, err))
+			continue
+		}
+		for _, env := range envNames {
+			secrets, err := listEnvironmentSecrets(client, ctx, repoID, env)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s:%s: %v", fullName, env, err))
+				continue
+			}
+			if hasSecret(secrets, name) {
+				locations = append(locations, KeyLocation{Scope: "environment", Type: "secret", Repo: fullName, Environment: env, Name: name})
+			}
+		}
+	}
+
+	for _, org := range orgs {
+		secret, _, err := client.Actions.GetOrgSecret(ctx, org, name)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("org %s: %v", org, err))
+			continue
+		}
+		locations = append(locations, KeyLocation{Scope: "org", Type: "secret", Org: org, Name: name, Visibility: secret.Visibility})
+	}
+
+	return locations, errors
+}
+
+// rotateOrgSecret updates an org secret and keeps its visibility and selected repositories
+func rotateOrgSecret(client *github.Client, ctx context.Context, loc KeyLocation, value string) error {
+	if loc.Visibility != "selected" {
+		return putOrgSecret(client, ctx, loc.Org, loc.Name, value, loc.Visibility)
+	}
+
+	// The update replaces the selection, so it must hold every selected repository
+	ids := github.SelectedRepoIDs{}
+	opt := &github.ListOptions{PerPage: 100}
+	for {
+		selected, resp, err := client.Actions.ListSelectedReposForOrgSecret(ctx, loc.Org, loc.Name, opt)
+		if err != nil {
+			return fmt.Errorf("failed to list selected repositories: %v", err)
+		}
+		for _, repo := range selected.Repositories {
+			ids = append(ids, repo.GetID())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	publicKey, _, err := client.Actions.GetOrgPublicKey(ctx, loc.Org)
+	if err != nil {
+		return fmt.Errorf("failed to get organization public key: %v", err)
+	}
+	encryptedValue, err := encryptSecret(publicKey.GetKey(), value)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	_, err = client.Actions.CreateOrUpdateOrgSecret(ctx, loc.Org, &github.EncryptedSecret{
+		Name:                  loc.Name,
+		KeyID:                 publicKey.GetKeyID(),
+		EncryptedValue:        encryptedValue,
+		Visibility:            "selected",
+		SelectedRepositoryIDs: ids,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write organization secret: %v", err)
+	}
+	return nil
+}
+
+// secretUpdatedAt reads the update time of a secret at a location
+func secretUpdatedAt(client *github.Client, ctx context.Context, loc KeyLocation) (time.Time, error) {
+	owner, repo, _ := parseRepo(loc.Repo)
+
+	var secret *github.Secret
+	var err error
+	switch loc.Scope {
+	case "org":
+		secret, _, err = client.Actions.GetOrgSecret(ctx, loc.Org, loc.Name)
+	case "environment":
+		var repoID int64
+		if repoID, err = repositoryID(client, ctx, owner, repo); err == nil {
+			secret, _, err = client.Actions.GetEnvSecret(ctx, int(repoID), loc.Environment, loc.Name)
+		}
+	default:
+		secret, _, err = client.Actions.GetRepoSecret(ctx, owner, repo, loc.Name)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return secret.UpdatedAt.Time, nil
+}
+
+// runRotation writes the value to every target, verifies the writes and records them
+func runRotation(client *github.Client, ctx context.Context, jobID string, targets []KeyLocation, value string) {
+	job, _ := rotationJobs.get(jobID)
+	// GitHub reports update times with second precision
+	started, _ := time.Parse("2006-01-02T15:04:05Z", job.StartedAt)
+
+	for i, loc := range targets {
+		target := RotationTarget{Location: loc, Status: "updated"}
+		var err error
+		switch loc.Scope {
+		case "org":
+			err = rotateOrgSecret(client, ctx, loc, value)
+		default:
+			err = writeKey(client, ctx, loc, value)
+		}
+		if err != nil {
+			target.Status = "failed"
+			target.Error = err.Error()
+		}
+		rotationJobs.setTarget(jobID, i, target)
+	}
+
+	// Read everything back; a target whose update time didn't move is a straggler
+	records := []RotationRecord{}
+	stragglers := []string{}
+	for i, loc := range targets {
+		job, _ := rotationJobs.get(jobID)
+		target := job.Targets[i]
+		if target.Status == "failed" {
+			stragglers = append(stragglers, loc.String())
+			continue
+		}
+
+		updatedAt, err := secretUpdatedAt(client, ctx, loc)
+		switch {
+		case err != nil:
+			target.Status = "straggler"
+			target.Error = fmt.Sprintf("failed to verify: %v", err)
+		case updatedAt.Before(started):
+			target.Status = "straggler"
+			target.Error = "update time did not change"
+		default:
+			target.Status = "verified"
+		}
+		if !updatedAt.IsZero() {
+			target.UpdatedAt = updatedAt.UTC().Format("2006-01-02T15:04:05Z")
+		}
+		rotationJobs.setTarget(jobID, i, target)
+
+		if target.Status == "verified" {
+			records = append(records, RotationRecord{JobID: jobID, Location: loc, RotatedBy: job.User, RotatedAt: target.UpdatedAt})
+		} else {
+			stragglers = append(stragglers, loc.String())
+		}
+	}
+
+	if store != nil && len(records) > 0 {
+		err := store.update(func(data *StoreData) error {
+			data.Rotations = append(data.Rotations, records...)
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Failed to record rotation %s: %v\n", jobID, err)
+		}
+	}
+
+	rotationJobs.mu.Lock()
+	defer rotationJobs.mu.Unlock()
+	j := rotationJobs.jobs[jobID]
+	j.Stragglers = stragglers
+	j.FinishedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+	j.Status = "completed"
+	if len(stragglers) > 0 {
+		j.Status = "completed_with_errors"
+	}
+}
+
+func rotateSecret(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Name      string           `json:"name"`
+		Value     string           `json:"value"`
+		Generator *SecretGenerator `json:"generator"`
+		Repos     []string         `json:"repos"`
+		Orgs      []string         `json:"orgs"`
+		OrgScope  *bool            `json:"org_scope"`
+		DryRun    bool             `json:"dry_run"`
+		Reveal    bool             `json:"reveal"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Name == "" || len(req.Repos) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and repos are required"})
+		return
+	}
+	// GitHub stores secret names uppercased
+	req.Name = strings.ToUpper(req.Name)
+	if !isValidKeyName(req.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret name: " + req.Name})
+		return
+	}
+	if (req.Value == "") == (req.Generator == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either a value or a generator"})
+		return
+	}
+	// Only key pairs leave something behind (the public key); any other generated
+	// value would be lost unless it's handed back
+	if req.Generator != nil && !req.Reveal && (req.Generator.Type == "random" || req.Generator.Type == "passphrase") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Set reveal to get the %s value back, or nobody will ever know it", req.Generator.Type)})
+		return
+	}
+
+	// Org scopes default to the owners of the selected repositories
+	orgs := req.Orgs
+	if len(orgs) == 0 && (req.OrgScope == nil || *req.OrgScope) {
+		for _, fullName := range req.Repos {
+			if owner, _, err := parseRepo(fullName); err == nil && !contains(orgs, owner) {
+				orgs = append(orgs, owner)
+			}
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	locations, errors := discoverSecretLocations(client, ctx, req.Name, req.Repos, orgs)
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"name": req.Name, "targets": locations, "errors": errors})
+		return
+	}
+	if len(locations) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Secret %s not found in the selected scopes", req.Name), "errors": errors})
+		return
+	}
+	// Discovered environments aren't in the request, so the middleware couldn't check them
+	if !authorizeWrite(c, user, locationTargets(locations)) {
+		return
+	}
+	if rejectForbiddenTargets(c, preflightLocations(client, ctx, user.Token, locations)) {
+		return
+	}
+
+	generated := &GeneratedSecret{Value: req.Value}
+	if req.Generator != nil {
+		if generated, err = req.Generator.generate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to generate value: %v", err)})
+			return
+		}
+	}
+
+	job := &RotationJob{
+		ID:         newJobID(),
+		Name:       req.Name,
+		User:       user.Login,
+		Status:     "running",
+		StartedAt:  time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Targets:    make([]RotationTarget, len(locations)),
+		Stragglers: []string{},
+		PublicKey:  generated.PublicKey,
+	}
+	for i, loc := range locations {
+		job.Targets[i] = RotationTarget{Location: loc, Status: "pending"}
+	}
+
+	rotationJobs.mu.Lock()
+	rotationJobs.jobs[job.ID] = job
+	rotationJobs.mu.Unlock()
+
+	go runRotation(client, context.Background(), job.ID, locations, generated.Value)
+
+	response := gin.H{
+		"message": fmt.Sprintf("Rotating %s in %d locations", req.Name, len(locations)),
+		"job_id":  job.ID,
+		"targets": locations,
+		"errors":  errors,
+	}
+	if generated.PublicKey != "" {
+		response["public_key"] = generated.PublicKey
+	}
+	// Generated values are only ever shown once, and only on request
+	if req.Reveal && req.Generator != nil {
+		response["value"] = generated.Value
+	}
+
+	c.JSON(http.StatusAccepted, response)
+}
+
+func getRotationJob(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	job, ok := rotationJobs.get(c.Param("id"))
+	if !ok || job.User != user.Login {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rotation job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func listRotations(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	// Like jobs, the history only holds the caller's own rotations
+	name := c.Query("name")
+	history := []RotationRecord{}
+	if store != nil {
+		store.view(func(data *StoreData) {
+			for _, record := range data.Rotations {
+				if record.RotatedBy == user.Login && (name == "" || record.Location.Name == name) {
+					history = append(history, record)
+				}
+			}
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":    rotationJobs.forUser(user.Login),
+		"history": history,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+func TestRotateOrgSecretKeepsEverySelectedRepository(t *testing.T) {
+	var stored []interface{}
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/acme/actions/secrets/TOKEN/repositories":
+			// Two pages of selected repositories
+			page := r.URL.Query().Get("page")
+			id := 1
+			if page == "2" {
+				id = 2
+			} else {
+				w.Header().Set("Link", fmt.Sprintf(`<https://api.github.com%s?page=2&per_page=100>; rel="next"`, r.URL.Path))
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 2, "repositories": []map[string]int{{"id": id}}})
+		case "/orgs/acme/actions/secrets/public-key":
+			json.NewEncoder(w).Encode(map[string]string{"key_id": "k1", "key": base64.StdEncoding.EncodeToString(make([]byte, 32))})
+		case "/orgs/acme/actions/secrets/TOKEN":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			stored, _ = body["selected_repository_ids"].([]interface{})
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	client := github.NewClient(nil).WithAuthToken("token")
+	loc := KeyLocation{Scope: "org", Type: "secret", Org: "acme", Name: "TOKEN", Visibility: "selected"}
+	if err := rotateOrgSecret(client, context.Background(), loc, "new-value"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{float64(1), float64(2)}; !reflect.DeepEqual(stored, want) {
+		t.Fatalf("expected both pages to stay selected, got %v", stored)
+	}
+}
+
+func TestListRotationsOnlyShowsOwnHistory(t *testing.T) {
+	testStore(t)
+	store.update(func(data *StoreData) error {
+		data.Rotations = append(data.Rotations,
+			RotationRecord{JobID: "1", Location: KeyLocation{Name: "TOKEN"}, RotatedBy: "alice"},
+			RotationRecord{JobID: "2", Location: KeyLocation{Name: "TOKEN"}, RotatedBy: "bob"})
+		return nil
+	})
+	sessionID := testSession(t, "alice", "alice-token")
+
+	status, body := callHandler(listRotations, "GET", "/api/secrets/rotations", http.Header{"X-Session-Id": {sessionID}})
+	history, _ := body["history"].([]interface{})
+	if status != http.StatusOK || len(history) != 1 || history[0].(map[string]interface{})["rotated_by"] != "alice" {
+		t.Fatalf("expected only alice's rotation, got %d %v", status, body)
+	}
+}
+
+func TestRotateSecretFindsLowercaseNames(t *testing.T) {
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/acme/svc/actions/secrets":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 1, "secrets": []map[string]string{{"name": "DEPLOY_TOKEN"}}})
+		case "/repos/acme/svc/environments":
+			json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 0, "environments": []interface{}{}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	sessionID := testSession(t, "octocat", "token")
+
+	rotate := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/secrets/rotate", strings.NewReader(body))
+		c.Request.Header.Set("X-Session-ID", sessionID)
+		rotateSecret(c)
+		return w
+	}
+
+	w := rotate(`{"name": "deploy_token", "repos": ["acme/svc"], "value": "new", "dry_run": true}`)
+	var response struct {
+		Name    string        `json:"name"`
+		Targets []KeyLocation `json:"targets"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Name != "DEPLOY_TOKEN" || len(response.Targets) != 1 || response.Targets[0].Name != "DEPLOY_TOKEN" {
+		t.Fatalf("expected the repository secret to be found, got %d %s", w.Code, w.Body)
+	}
+
+	// A generated value nobody gets to see would be lost
+	for _, generator := range []string{"random", "passphrase"} {
+		if w := rotate(`{"name": "DEPLOY_TOKEN", "repos": ["acme/svc"], "generator": {"type": "` + generator + `"}}`); w.Code != http.StatusBadRequest {
+			t.Errorf("%s without reveal: got %d, want 400", generator, w.Code)
+		}
+	}
+}
+
+func TestPassphraseNeedsEightWords(t *testing.T) {
+	for words, ok := range map[int]bool{4: false, 7: false, 8: true, 32: true, 33: false} {
+		generated, err := (&SecretGenerator{Type: "passphrase", Words: words}).generate()
+		if (err == nil) != ok {
+			t.Errorf("%d words: got error %v", words, err)
+			continue
+		}
+		if ok && len(strings.Split(generated.Value, "-")) != words {
+			t.Errorf("%d words: got %q", words, generated.Value)
+		}
+	}
+
+	generated, err := (&SecretGenerator{Type: "passphrase"}).generate()
+	if err != nil || len(strings.Split(generated.Value, "-")) != 12 {
+		t.Errorf("expected 12 words by default, got %q, %v", generated.Value, err)
+	}
+}
@@ -0,0 +1,744 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"github.com/sirupsen/logrus"
+)
+
+// Scheduled and time-boxed changes. A change set is applied to its targets at a
+// given time, and a time-boxed change is reverted after a duration (turn DEBUG on
+// in production for two hours). Changes live in the local store, so they survive
+// restarts; a change that came due while the server was down runs as soon as it's
+// back. Applying snapshots the values a change replaces and stores the snapshot
+// before writing anything, so a crash halfway resumes the writes instead of
+// snapshotting values the change already wrote. The revert writes the snapshot
+// back and keeps retrying until every key is done; keys someone changed in the
+// meantime are left alone.
+
+// schedulerInterval is how often due changes are looked for
+var schedulerInterval = 30 * time.Second
+
+// schedulerMu serializes the scheduler and cancellations
+var schedulerMu sync.Mutex
+
+// ScheduledKey is one key of a scheduled change
+type ScheduledKey struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Delete bool   `json:"delete,omitempty"`
+	Value  string `json:"value,omitempty"` // new variable value
+
+	// Secret values, encrypted for the target when scheduled
+	KeyID          string `json:"key_id,omitempty"`
+	EncryptedValue string `json:"encrypted_value,omitempty"`
+
+	// Filled in when applied: what the change did and the value it replaced
+	Action   string `json:"action,omitempty"` // "create", "update", "delete", "unchanged" or "absent"
+	Previous string `json:"previous,omitempty"`
+	Status   string `json:"status,omitempty"` // "pending" between snapshot and write, then "applied", "skipped", "failed", "reverted" or "kept"
+	Error    string `json:"error,omitempty"`
+}
+
+// ScheduledTarget is the keys of one "owner/repo:env" or "owner/repo" target
+type ScheduledTarget struct {
+	Target string         `json:"target"`
+	Keys   []ScheduledKey `json:"keys"`
+}
+
+// ScheduledChange is a change set applied at a given time and optionally reverted later
+type ScheduledChange struct {
+	ID          string            `json:"id"`
+	Title       string            `json:"title,omitempty"`
+	Targets     []ScheduledTarget `json:"targets"`
+	Status      string            `json:"status"` // "scheduled", "applying", "active", "completed", "reverted", "cancelled" or "failed"
+	ApplyAt     string            `json:"apply_at"`
+	RevertAfter string            `json:"revert_after,omitempty"`
+	RevertAt    string            `json:"revert_at,omitempty"`
+	CreatedBy   string            `json:"created_by"`
+	CreatedAt   string            `json:"created_at"`
+	AppliedAt   string            `json:"applied_at,omitempty"`
+	RevertedAt  string            `json:"reverted_at,omitempty"`
+	CancelledBy string            `json:"cancelled_by,omitempty"`
+	LastError   string            `json:"last_error,omitempty"` // why a due change hasn't run yet
+	Errors      []string          `json:"errors,omitempty"`
+}
+
+// public returns a copy without the encrypted secret values
+func (s ScheduledChange) public() ScheduledChange {
+	s.Targets = append([]ScheduledTarget(nil), s.Targets...)
+	for i := range s.Targets {
+		s.Targets[i].Keys = append([]ScheduledKey(nil), s.Targets[i].Keys...)
+		for j := range s.Targets[i].Keys {
+			s.Targets[i].Keys[j].KeyID = ""
+			s.Targets[i].Keys[j].EncryptedValue = ""
+		}
+	}
+	return s
+}
+
+func (s *ScheduledChange) rbacTargets() []RBACTarget {
+	targets := []RBACTarget{}
+	for _, target := range s.Targets {
+		if t, ok := rbacTargetFromString(target.Target); ok && !containsTarget(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// due reports whether a change has to be applied or reverted at now
+func (s *ScheduledChange) due(now time.Time) bool {
+	var at string
+	switch s.Status {
+	case "scheduled", "applying":
+		at = s.ApplyAt
+	case "active":
+		at = s.RevertAt
+	default:
+		return false
+	}
+	when, err := time.Parse(time.RFC3339, at)
+	return err == nil && !when.After(now)
+}
+
+func formatScheduleTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// loadScheduledChanges returns copies of the stored changes
+func loadScheduledChanges() []ScheduledChange {
+	changes := []ScheduledChange{}
+	store.view(func(data *StoreData) {
+		for _, change := range data.ScheduledChanges {
+			copied := change
+			copied.Targets = nil
+			for _, target := range change.Targets {
+				target.Keys = append([]ScheduledKey(nil), target.Keys...)
+				copied.Targets = append(copied.Targets, target)
+			}
+			changes = append(changes, copied)
+		}
+	})
+	return changes
+}
+
+func loadScheduledChange(id string) *ScheduledChange {
+	for _, change := range loadScheduledChanges() {
+		if change.ID == id {
+			return &change
+		}
+	}
+	return nil
+}
+
+func saveScheduledChange(change *ScheduledChange) error {
+	return store.update(func(data *StoreData) error {
+		for i := range data.ScheduledChanges {
+			if data.ScheduledChanges[i].ID == change.ID {
+				data.ScheduledChanges[i] = *change
+				return nil
+			}
+		}
+		data.ScheduledChanges = append(data.ScheduledChanges, *change)
+		return nil
+	})
+}
+
+// schedulerClient returns a client for the user who scheduled a change. It
+// runs with their live session only, never with server credentials, and their
+// role is checked again since it may have changed after scheduling.
+func schedulerClient(change *ScheduledChange) (*github.Client, error) {
+	sessionID, user, ok := findSession(func(user User) bool { return user.Login == change.CreatedBy })
+	if !ok {
+		return nil, fmt.Errorf("%s has no live session; the change runs once they log in again", change.CreatedBy)
+	}
+	refreshIfExpiring(sessionID, &user)
+
+	if activeRBAC != nil {
+		targets := []RBACTarget{}
+		for _, target := range change.Targets {
+			if t, ok := rbacTargetFromString(target.Target); ok {
+				targets = append(targets, t)
+			}
+		}
+		principal, err := rbacPrincipal(&user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check the role of %s: %v", user.Login, err)
+		}
+		if denials := activeRBAC.denied(principal, "write", targets); len(denials) > 0 {
+			return nil, fmt.Errorf("the role of %s no longer allows writing %s", user.Login, denials[0].Target)
+		}
+	}
+	return github.NewClient(nil).WithAuthToken(user.Token), nil
+}
+
+// scheduleTarget turns a change set into the keys of one target. Secrets are
+// sealed now; time-boxed changes can only add secrets, since GitHub never
+// returns the value a revert would need to restore.
+func scheduleTarget(client *github.Client, ctx context.Context, target string, changes *BatchChangeSet, timeBoxed bool) (ScheduledTarget, error) {
+	scheduled := ScheduledTarget{Target: target, Keys: []ScheduledKey{}}
+
+	var secrets []Secret
+	if timeBoxed && (len(changes.SetSecrets) > 0 || len(changes.DeleteSecrets) > 0) {
+		if len(changes.DeleteSecrets) > 0 {
+			return scheduled, fmt.Errorf("time-boxed changes can't delete secrets: GitHub doesn't return the value to restore")
+		}
+		var err error
+		if _, secrets, err = listTargetKeys(client, ctx, target); err != nil {
+			return scheduled, err
+		}
+	}
+
+	for _, name := range sortedKeys(changes.SetVariables) {
+		scheduled.Keys = append(scheduled.Keys, ScheduledKey{Type: "variable", Name: name, Value: changes.SetVariables[name]})
+	}
+	for _, name := range sortedKeys(changes.SetSecrets) {
+		if timeBoxed && hasSecret(secrets, name) {
+			return scheduled, fmt.Errorf("%s already exists in %s; time-boxed changes can only add secrets, since GitHub doesn't return the value to restore", name, target)
+		}
+		loc, _ := targetLocation(target, "secret", name)
+		sealed, err := sealSecret(client, ctx, loc, changes.SetSecrets[name])
+		if err != nil {
+			return scheduled, err
+		}
+		scheduled.Keys = append(scheduled.Keys, ScheduledKey{Type: "secret", Name: name, KeyID: sealed.KeyID, EncryptedValue: sealed.EncryptedValue})
+	}
+	for _, name := range changes.DeleteVariables {
+		scheduled.Keys = append(scheduled.Keys, ScheduledKey{Type: "variable", Name: name, Delete: true})
+	}
+	for _, name := range changes.DeleteSecrets {
+		scheduled.Keys = append(scheduled.Keys, ScheduledKey{Type: "secret", Name: name, Delete: true})
+	}
+	return scheduled, nil
+}
+
+// snapshotScheduledTarget records what each key of a target will do and the
+// variable values it replaces. Nothing is written yet; keys to write are left
+// "pending".
+func snapshotScheduledTarget(client *github.Client, ctx context.Context, target *ScheduledTarget, timeBoxed bool) []string {
+	fail := func(err error) []string {
+		for i := range target.Keys {
+			target.Keys[i].Status = "failed"
+			target.Keys[i].Error = err.Error()
+		}
+		return []string{err.Error()}
+	}
+	if loc, _ := targetLocation(target.Target, "variable", ""); isProtectedEnvironment(loc.Environment) {
+		return fail(fmt.Errorf("%s is protected; changes to it need a change request", target.Target))
+	}
+
+	variables, secrets, err := listTargetKeys(client, ctx, target.Target)
+	if err != nil {
+		return fail(fmt.Errorf("%s: %v", target.Target, err))
+	}
+	current := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		current[variable.Name] = variable.Value
+	}
+
+	errors := []string{}
+	for i := range target.Keys {
+		key := &target.Keys[i]
+		loc, _ := targetLocation(target.Target, key.Type, key.Name)
+
+		var exists bool
+		if key.Type == "variable" {
+			key.Previous, exists = current[key.Name]
+		} else {
+			exists = hasSecret(secrets, key.Name)
+		}
+		switch {
+		case key.Delete && exists:
+			key.Action = "delete"
+		case key.Delete:
+			key.Action = "absent"
+		case !exists:
+			key.Action = "create"
+		case key.Type == "variable" && key.Previous == key.Value:
+			key.Action = "unchanged"
+		default:
+			key.Action = "update"
+		}
+
+		switch {
+		case key.Action == "unchanged" || key.Action == "absent":
+			key.Status = "skipped"
+		case timeBoxed && key.Type == "secret" && key.Action == "update":
+			// Created by someone else since the change was scheduled
+			key.Status = "failed"
+			key.Error = fmt.Sprintf("%s exists now and couldn't be restored by the revert", loc)
+			errors = append(errors, key.Error)
+		default:
+			key.Status = "pending"
+		}
+	}
+	return errors
+}
+
+// applyScheduledTarget writes the pending keys of a snapshotted target. Writes
+// are idempotent, so keys left pending by a crash are simply written again.
+func applyScheduledTarget(client *github.Client, ctx context.Context, target *ScheduledTarget) []string {
+	errors := []string{}
+	for i := range target.Keys {
+		key := &target.Keys[i]
+		if key.Status != "pending" {
+			continue
+		}
+		loc, _ := targetLocation(target.Target, key.Type, key.Name)
+
+		var err error
+		switch {
+		case key.Action == "delete":
+			if err = deleteKey(client, ctx, loc); isNotFound(err) {
+				err = nil
+			}
+		case key.Type == "variable":
+			err = writeKey(client, ctx, loc, key.Value)
+		default:
+			err = writeSealedSecret(client, ctx, loc, &github.EncryptedSecret{Name: key.Name, KeyID: key.KeyID, EncryptedValue: key.EncryptedValue})
+		}
+		if err != nil {
+			key.Status = "failed"
+			key.Error = err.Error()
+			errors = append(errors, err.Error())
+			continue
+		}
+		key.Status = "applied"
+	}
+	return errors
+}
+
+// revertScheduledTarget writes back the values an applied target replaced,
+// leaving keys that changed since alone
+func revertScheduledTarget(client *github.Client, ctx context.Context, target *ScheduledTarget) []string {
+	variables, secrets, err := listTargetKeys(client, ctx, target.Target)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %v", target.Target, err)}
+	}
+	current := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		current[variable.Name] = variable.Value
+	}
+
+	errors := []string{}
+	for i := range target.Keys {
+		key := &target.Keys[i]
+		if key.Status != "applied" {
+			continue
+		}
+		loc, _ := targetLocation(target.Target, key.Type, key.Name)
+
+		value, exists := current[key.Name]
+		if key.Type == "secret" {
+			exists = hasSecret(secrets, key.Name)
+		}
+		// A revert cut short may already have restored some keys
+		restored := false
+		changed := false
+		switch {
+		case key.Action == "create":
+			restored = !exists
+			changed = exists && key.Type == "variable" && value != key.Value
+		case key.Type == "variable":
+			restored = exists && value == key.Previous
+			changed = key.Action == "delete" && exists || key.Action != "delete" && (!exists || value != key.Value)
+		}
+		if restored {
+			key.Status = "reverted"
+			key.Error = ""
+			continue
+		}
+		if changed {
+			key.Status = "kept"
+			key.Error = "changed since it was applied; left as is"
+			continue
+		}
+
+		switch {
+		case key.Action == "create":
+			err = deleteKey(client, ctx, loc)
+		case key.Type == "variable":
+			err = writeKey(client, ctx, loc, key.Previous)
+		default:
+			// Secrets are never updated or deleted by time-boxed changes
+			continue
+		}
+		if err != nil {
+			key.Error = err.Error()
+			errors = append(errors, err.Error())
+			continue
+		}
+		key.Status = "reverted"
+		key.Error = ""
+	}
+	return errors
+}
+
+// applyScheduledChange applies every target of a change. A scheduled change is
+// snapshotted and saved as "applying" before anything is written; an applying
+// change (interrupted by a crash) resumes from its stored snapshot.
+func applyScheduledChange(client *github.Client, ctx context.Context, change *ScheduledChange, now time.Time, save func(*ScheduledChange) error) error {
+	revertAfter, _ := time.ParseDuration(change.RevertAfter)
+	if change.Status == "scheduled" {
+		for i := range change.Targets {
+			change.Errors = append(change.Errors, snapshotScheduledTarget(client, ctx, &change.Targets[i], revertAfter > 0)...)
+		}
+		change.Status = "applying"
+		if err := save(change); err != nil {
+			return err
+		}
+	}
+
+	applied := 0
+	for i := range change.Targets {
+		change.Errors = append(change.Errors, applyScheduledTarget(client, ctx, &change.Targets[i])...)
+		for _, key := range change.Targets[i].Keys {
+			if key.Status == "applied" {
+				applied++
+			}
+		}
+	}
+
+	change.AppliedAt = formatScheduleTime(now)
+	change.LastError = ""
+	switch {
+	case applied == 0 && len(change.Errors) > 0:
+		change.Status = "failed"
+	case revertAfter > 0:
+		change.Status = "active"
+		change.RevertAt = formatScheduleTime(now.Add(revertAfter))
+	default:
+		change.Status = "completed"
+	}
+	return nil
+}
+
+// revertScheduledChange reverts every target of an active change. Until every
+// applied key is reverted or kept the change stays active, and LastError says
+// what is left for the next try.
+func revertScheduledChange(client *github.Client, ctx context.Context, change *ScheduledChange, now time.Time) {
+	errors := []string{}
+	for i := range change.Targets {
+		errors = append(errors, revertScheduledTarget(client, ctx, &change.Targets[i])...)
+	}
+
+	for _, target := range change.Targets {
+		for _, key := range target.Keys {
+			if key.Status == "applied" {
+				change.LastError = "revert incomplete: " + strings.Join(errors, "; ")
+				return
+			}
+		}
+	}
+	change.Status = "reverted"
+	change.RevertedAt = formatScheduleTime(now)
+	change.LastError = ""
+}
+
+// runDueChanges applies and reverts every change that is due
+func runDueChanges(now time.Time) {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	for _, change := range loadScheduledChanges() {
+		if !change.due(now) {
+			continue
+		}
+
+		client, err := schedulerClient(&change)
+		if err != nil {
+			// Try again on the next tick
+			if change.LastError != err.Error() {
+				logrus.Warnf("Scheduled change %s is due but can't run: %v", change.ID, err)
+				change.LastError = err.Error()
+				saveScheduledChange(&change)
+			}
+			continue
+		}
+
+		ctx := context.Background()
+		if change.Status == "active" {
+			revertScheduledChange(client, ctx, &change, now)
+			if change.Status == "reverted" {
+				logrus.Infof("Reverted time-boxed change %s", change.ID)
+			} else {
+				logrus.Warnf("Time-boxed change %s is not fully reverted, retrying: %s", change.ID, change.LastError)
+			}
+		} else {
+			if err := applyScheduledChange(client, ctx, &change, now, saveScheduledChange); err != nil {
+				// Nothing was written; try again on the next tick
+				logrus.Warnf("Failed to save the snapshot of scheduled change %s: %v", change.ID, err)
+				continue
+			}
+			logrus.Infof("Applied scheduled change %s (%s)", change.ID, change.Status)
+		}
+		if err := saveScheduledChange(&change); err != nil {
+			logrus.Warnf("Failed to save scheduled change %s: %v", change.ID, err)
+		}
+	}
+}
+
+// startScheduler runs due changes now, to catch up on any that came due while
+// the server was down, and then every schedulerInterval
+func startScheduler() {
+	go func() {
+		runDueChanges(time.Now())
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueChanges(now)
+		}
+	}()
+}
+
+func createScheduledChange(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Targets []string `json:"targets"`
+		Title   string   `json:"title"`
+		BatchChangeSet
+		ApplyAt     string `json:"apply_at"`     // RFC 3339; empty applies now
+		RevertAfter string `json:"revert_after"` // e.g. "2h"; empty keeps the change
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Targets) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one target is required"})
+		return
+	}
+	if req.empty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The change set is empty"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Targets = uniqueTargets(req.Targets)
+	if req.ApplyAt == "" && req.RevertAfter == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set apply_at, revert_after or both; use POST /api/batch for a plain change"})
+		return
+	}
+
+	now := time.Now()
+	applyAt := now
+	if req.ApplyAt != "" {
+		if applyAt, err = time.Parse(time.RFC3339, req.ApplyAt); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid apply_at %q, use RFC 3339 like 2024-05-01T18:00:00Z", req.ApplyAt)})
+			return
+		}
+	}
+	var revertAfter time.Duration
+	if req.RevertAfter != "" {
+		if revertAfter, err = time.ParseDuration(req.RevertAfter); err != nil || revertAfter <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid revert_after %q, use a duration like 30m or 2h", req.RevertAfter)})
+			return
+		}
+	}
+
+	// Check the change set now rather than when nobody is watching
+	violations := []PolicyViolation{}
+	locations := []KeyLocation{}
+	for _, target := range req.Targets {
+		loc, err := targetLocation(target, "variable", "")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, name := range sortedKeys(req.SetVariables) {
+			loc.Type, loc.Name = "variable", name
+			locations = append(locations, loc)
+			if loc.Scope == "environment" {
+				violations = append(violations, activePolicy.checkKey(loc.Environment, "variable", name)...)
+			}
+		}
+		for _, name := range sortedKeys(req.SetSecrets) {
+			loc.Type, loc.Name = "secret", name
+			locations = append(locations, loc)
+			if loc.Scope == "environment" {
+				violations = append(violations, activePolicy.checkKey(loc.Environment, "secret", name)...)
+			}
+		}
+		for _, name := range req.DeleteVariables {
+			loc.Type, loc.Name = "variable", name
+			locations = append(locations, loc)
+			if loc.Scope == "environment" {
+				violations = append(violations, activePolicy.checkDelete(loc.Environment, "variable", name)...)
+			}
+		}
+		for _, name := range req.DeleteSecrets {
+			loc.Type, loc.Name = "secret", name
+			locations = append(locations, loc)
+			if loc.Scope == "environment" {
+				violations = append(violations, activePolicy.checkDelete(loc.Environment, "secret", name)...)
+			}
+		}
+	}
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	if rejectForbiddenTargets(c, preflightLocations(client, ctx, user.Token, locations)) {
+		return
+	}
+
+	change := &ScheduledChange{
+		ID:        newJobID(),
+		Title:     req.Title,
+		Targets:   []ScheduledTarget{},
+		Status:    "scheduled",
+		ApplyAt:   formatScheduleTime(applyAt),
+		CreatedBy: user.Login,
+		CreatedAt: formatScheduleTime(now),
+	}
+	if revertAfter > 0 {
+		change.RevertAfter = revertAfter.String()
+	}
+	for _, target := range req.Targets {
+		scheduled, err := scheduleTarget(client, ctx, target, &req.BatchChangeSet, revertAfter > 0)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		change.Targets = append(change.Targets, scheduled)
+	}
+
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	status := http.StatusCreated
+	if !applyAt.After(now) {
+		if err := applyScheduledChange(client, ctx, change, now, saveScheduledChange); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save scheduled change: %v", err)})
+			return
+		}
+		status = http.StatusOK
+	}
+	if err := saveScheduledChange(change); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save scheduled change: %v", err)})
+		return
+	}
+
+	c.JSON(status, gin.H{"schedule": change.public()})
+}
+
+func listScheduledChanges(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	// Changes hold live values, so only show those the user can read
+	status := c.Query("status")
+	mayRead := readerFilter(user)
+	changes := []ScheduledChange{}
+	for _, change := range loadScheduledChanges() {
+		if (status == "" || change.Status == status) && mayRead(change.rbacTargets()) {
+			changes = append(changes, change.public())
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].CreatedAt > changes[j].CreatedAt })
+
+	c.JSON(http.StatusOK, gin.H{"schedules": changes})
+}
+
+func getScheduledChange(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	change := loadScheduledChange(c.Param("id"))
+	if change == nil || !readerFilter(user)(change.rbacTargets()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled change not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schedule": change.public()})
+}
+
+// cancelScheduledChange drops a change that hasn't run yet. An active time-boxed
+// change is reverted right away, unless keep is set, which drops the revert instead.
+func cancelScheduledChange(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Keep bool `json:"keep"`
+	}
+	// The body is optional
+	c.ShouldBindJSON(&req)
+
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	change := loadScheduledChange(c.Param("id"))
+	if change == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled change not found"})
+		return
+	}
+	if change.Status != "scheduled" && change.Status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Scheduled change is %s", change.Status)})
+		return
+	}
+	if !checkRBAC(c, user, "write", change.rbacTargets()) {
+		return
+	}
+
+	var message string
+	switch {
+	case change.Status == "scheduled":
+		change.Status = "cancelled"
+		message = fmt.Sprintf("Cancelled scheduled change %s", change.ID)
+	case req.Keep:
+		change.Status = "completed"
+		change.RevertAt = ""
+		message = fmt.Sprintf("Kept change %s; it won't be reverted", change.ID)
+	default:
+		now := time.Now()
+		revertScheduledChange(github.NewClient(nil).WithAuthToken(user.Token), context.Background(), change, now)
+		message = fmt.Sprintf("Reverted change %s early", change.ID)
+		if change.Status == "active" {
+			// The scheduler finishes the rest from the next tick on
+			change.RevertAt = formatScheduleTime(now)
+			message = fmt.Sprintf("Started reverting change %s early; the rest is retried (%s)", change.ID, change.LastError)
+		}
+	}
+	change.CancelledBy = user.Login
+	if err := saveScheduledChange(change); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save scheduled change: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "schedule": change.public()})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// fakeStagingVariables keeps the variables of acme/svc:staging in memory.
+// failWrites makes that many variable writes fail first.
+type fakeStagingVariables struct {
+	mu         sync.Mutex
+	values     map[string]string
+	writes     int
+	failWrites int
+}
+
+func (f *fakeStagingVariables) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const prefix = "/repos/acme/svc/environments/staging/variables"
+
+	switch {
+	case r.URL.Path == "/repos/acme/svc":
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1})
+	case r.URL.Path == "/repositories/1/environments/staging/secrets":
+		json.NewEncoder(w).Encode(map[string]interface{}{"total_count": 0, "secrets": []interface{}{}})
+	case r.URL.Path == prefix && r.Method == "GET":
+		variables := []map[string]string{}
+		for name, value := range f.values {
+			variables = append(variables, map[string]string{"name": name, "value": value})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"total_count": len(variables), "variables": variables})
+	case strings.HasPrefix(r.URL.Path, prefix) && (r.Method == "PATCH" || r.Method == "POST"):
+		f.writes++
+		if f.failWrites > 0 {
+			f.failWrites--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var variable github.ActionsVariable
+		json.NewDecoder(r.Body).Decode(&variable)
+		f.values[variable.Name] = variable.Value
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func timeBoxedDebug() *ScheduledChange {
+	return &ScheduledChange{
+		ID:          "sc1",
+		Status:      "scheduled",
+		ApplyAt:     "2026-01-01T10:00:00Z",
+		RevertAfter: "2h0m0s",
+		CreatedBy:   "alice",
+		Targets: []ScheduledTarget{{Target: "acme/svc:staging", Keys: []ScheduledKey{
+			{Type: "variable", Name: "DEBUG", Value: "true"},
+		}}},
+	}
+}
+
+func TestScheduledChangeResumesFromStoredSnapshot(t *testing.T) {
+	fake := &fakeStagingVariables{values: map[string]string{"DEBUG": "false"}}
+	fakeGitHub(t, fake)
+	client := github.NewClient(nil).WithAuthToken("token")
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// The snapshot can't be saved: nothing may be written
+	change := timeBoxedDebug()
+	failing := func(*ScheduledChange) error { return errors.New("disk full") }
+	if err := applyScheduledChange(client, context.Background(), change, now, failing); err == nil || fake.writes != 0 {
+		t.Fatalf("expected no writes without a saved snapshot, got %v after %d writes", err, fake.writes)
+	}
+
+	// Saved snapshot, then the server dies right after writing DEBUG
+	change = timeBoxedDebug()
+	var saved ScheduledChange
+	crash := func(c *ScheduledChange) error {
+		saved = *c
+		saved.Targets = []ScheduledTarget{{Target: c.Targets[0].Target, Keys: append([]ScheduledKey(nil), c.Targets[0].Keys...)}}
+		return nil
+	}
+	if err := applyScheduledChange(client, context.Background(), change, now, crash); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != "applying" || saved.Targets[0].Keys[0].Previous != "false" || saved.Targets[0].Keys[0].Status != "pending" {
+		t.Fatalf("expected the snapshot to be saved before writing, got %+v", saved)
+	}
+
+	// After the restart the stored change resumes without snapshotting "true"
+	resumed := saved
+	if !resumed.due(now) {
+		t.Fatalf("expected an applying change to be due")
+	}
+	if err := applyScheduledChange(client, context.Background(), &resumed, now, crash); err != nil {
+		t.Fatal(err)
+	}
+	key := resumed.Targets[0].Keys[0]
+	if resumed.Status != "active" || key.Status != "applied" || key.Previous != "false" {
+		t.Fatalf("expected the resumed change to keep its snapshot, got %s %+v", resumed.Status, key)
+	}
+
+	revertScheduledChange(client, context.Background(), &resumed, now.Add(2*time.Hour))
+	if resumed.Status != "reverted" || fake.values["DEBUG"] != "false" {
+		t.Fatalf("expected DEBUG to be reverted to false, got %s, %q", resumed.Status, fake.values["DEBUG"])
+	}
+}
+
+func TestScheduledRevertRetriesUntilDone(t *testing.T) {
+	fake := &fakeStagingVariables{values: map[string]string{"DEBUG": "false"}}
+	fakeGitHub(t, fake)
+	client := github.NewClient(nil).WithAuthToken("token")
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	change := timeBoxedDebug()
+	if err := applyScheduledChange(client, context.Background(), change, now, func(*ScheduledChange) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.failWrites = 1
+	later := now.Add(2 * time.Hour)
+	revertScheduledChange(client, context.Background(), change, later)
+	if change.Status != "active" || change.LastError == "" || !change.due(later) {
+		t.Fatalf("expected a failed revert to stay active and due, got %s %q", change.Status, change.LastError)
+	}
+
+	revertScheduledChange(client, context.Background(), change, later.Add(time.Minute))
+	if change.Status != "reverted" || change.LastError != "" || fake.values["DEBUG"] != "false" {
+		t.Fatalf("expected the retry to finish the revert, got %s %q, DEBUG=%q", change.Status, change.LastError, fake.values["DEBUG"])
+	}
+}
+
+func TestScheduledChangeCantDeleteRequiredKeys(t *testing.T) {
+	testPolicy(t, `
+environments:
+  - match: "production"
+    required_variables: [API_URL]
+`)
+	sessionID := testSession(t, "alice", "token")
+
+	body := `{"targets":["acme/svc:production"],"delete_variables":["api_url"],"apply_at":"2099-01-01T00:00:00Z"}`
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/scheduled-changes", strings.NewReader(body))
+	c.Request.Header.Set("X-Session-ID", sessionID)
+	createScheduledChange(c)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSchedulerRunsOnlyAsTheCreatorWithTheirCurrentRole(t *testing.T) {
+	change := timeBoxedDebug()
+	if _, err := schedulerClient(change); err == nil {
+		t.Fatalf("expected no client without a live session of the creator")
+	}
+
+	testSession(t, "alice", "alice-token")
+	if _, err := schedulerClient(change); err != nil {
+		t.Fatalf("expected the creator's session to be used, got %v", err)
+	}
+
+	// Her role was taken away after she scheduled the change
+	previous := activeRBAC
+	activeRBAC = &RBACConfig{DefaultRole: "viewer"}
+	t.Cleanup(func() { activeRBAC = previous })
+	if _, err := schedulerClient(change); err == nil || !strings.Contains(err.Error(), "no longer allows") {
+		t.Fatalf("expected the change to be refused, got %v", err)
+	}
+}
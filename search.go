@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"github.com/sirupsen/logrus"
+)
+
+// Cross-repository key search. Listing every environment of dozens of
+// repositories is expensive, so snapshots are cached per session and kept
+// warm by a background refresher instead of being fetched on every query.
+
+const (
+	keySearchTTL          = 5 * time.Minute
+	keySearchIdleTimeout  = 30 * time.Minute
+	keySearchRefreshEvery = 1 * time.Minute
+)
+
+type keySearchEntry struct {
+	token    string
+	owner    string
+	repo     string
+	org      string
+	repoSnap *RepoSnapshot
+	orgSnap  *OrgSnapshot
+	err      error
+	lastUsed time.Time
+}
+
+func (e *keySearchEntry) fetchedAt() time.Time {
+	if e.repoSnap != nil {
+		return e.repoSnap.FetchedAt
+	}
+	if e.orgSnap != nil {
+		return e.orgSnap.FetchedAt
+	}
+	return time.Time{}
+}
+
+// refresh fetches a fresh snapshot. It does not touch the cache lock, the
+// caller stores the result.
+func (e *keySearchEntry) refresh() (*RepoSnapshot, *OrgSnapshot, error) {
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(e.token)
+
+	if e.org != "" {
+		snap, err := fetchOrgSnapshot(client, ctx, e.org)
+		return nil, snap, err
+	}
+	snap, err := fetchRepoSnapshot(client, ctx, e.owner, e.repo)
+	return snap, nil, err
+}
+
+type keySearchCache struct {
+	mu      sync.Mutex
+	entries map[string]*keySearchEntry
+}
+
+var searchCache = &keySearchCache{entries: make(map[string]*keySearchEntry)}
+
+func keySearchCacheKey(sessionID, scope string) string {
+	return sessionID + "|" + scope
+}
+
+// get returns a copy of the cached entry for a scope, fetching it synchronously on
+// first use or when forced.
+func (sc *keySearchCache) get(sessionID, token string, template keySearchEntry, force bool) keySearchEntry {
+	scope := "repo:" + template.owner + "/" + template.repo
+	if template.org != "" {
+		scope = "org:" + template.org
+	}
+	key := keySearchCacheKey(sessionID, scope)
+
+	sc.mu.Lock()
+	entry, exists := sc.entries[key]
+	var cached keySearchEntry
+	if exists {
+		entry.lastUsed = time.Now()
+		entry.token = token
+		cached = *entry
+	}
+	sc.mu.Unlock()
+
+	if exists && !force && cached.err == nil {
+		return cached
+	}
+
+	fresh := template
+	fresh.token = token
+	fresh.lastUsed = time.Now()
+	fresh.repoSnap, fresh.orgSnap, fresh.err = fresh.refresh()
+
+	sc.mu.Lock()
+	stored := fresh
+	sc.entries[key] = &stored
+	sc.mu.Unlock()
+
+	return fresh
+}
+
+// refreshStale re-fetches entries older than the TTL and evicts idle ones
+func (sc *keySearchCache) refreshStale() {
+	sc.mu.Lock()
+	var stale []string
+	for key, entry := range sc.entries {
+		if time.Since(entry.lastUsed) > keySearchIdleTimeout {
+			delete(sc.entries, key)
+			continue
+		}
+		if time.Since(entry.fetchedAt()) > keySearchTTL {
+			stale = append(stale, key)
+		}
+	}
+	sc.mu.Unlock()
+
+	for _, key := range stale {
+		sc.mu.Lock()
+		entry, exists := sc.entries[key]
+		var snapshot keySearchEntry
+		if exists {
+			snapshot = *entry
+		}
+		sc.mu.Unlock()
+		if !exists {
+			continue
+		}
+
+		repoSnap, orgSnap, err := snapshot.refresh()
+		if err != nil {
+			logrus.Warnf("Background refresh of search cache failed: %v", err)
+			continue
+		}
+
+		sc.mu.Lock()
+		if current, ok := sc.entries[key]; ok {
+			current.repoSnap, current.orgSnap, current.err = repoSnap, orgSnap, nil
+		}
+		sc.mu.Unlock()
+	}
+}
+
+// startKeySearchRefresher keeps cached snapshots warm in the background
+func startKeySearchRefresher() {
+	go func() {
+		ticker := time.NewTicker(keySearchRefreshEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			searchCache.refreshStale()
+		}
+	}()
+}
+
+// KeySearchResult is a single key found by the cross-repository search
+type KeySearchResult struct {
+	Scope       string `json:"scope"` // "org", "repository" or "environment"
+	Org         string `json:"org,omitempty"`
+	Repo        string `json:"repo,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Type        string `json:"type"` // "variable" or "secret"
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// buildKeyMatcher turns a query into a predicate. Mode "regex" compiles the query
+// as a case-insensitive regular expression, "glob" uses shell-style patterns and
+// "auto" picks glob when the query contains wildcards and substring otherwise.
+// Globs match the whole string and their * matches any character, so patterns
+// also work against values holding URLs and paths.
+func buildKeyMatcher(query, mode string) (func(string) bool, error) {
+	switch mode {
+	case "regex":
+		re, err := regexp.Compile("(?i)" + query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return re.MatchString, nil
+	case "glob", "auto", "":
+		pattern := strings.ToUpper(query)
+		if mode == "glob" || strings.ContainsAny(pattern, "*?[") {
+			re, err := globRegexp(query)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern: %v", err)
+			}
+			return re.MatchString, nil
+		}
+		return func(s string) bool {
+			return strings.Contains(strings.ToUpper(s), pattern)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+}
+
+// globRegexp compiles a shell-style glob into a case-insensitive regular
+// expression anchored at both ends. * and ? match any character, including /
+// and newlines, and [...] classes are kept ([!...] negates).
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class in %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if negated, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + negated
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+func searchKeys(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	sessionID := c.GetHeader("X-Session-ID")
+
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter 'q' is required"})
+		return
+	}
+
+	repos := c.QueryArray("repos")
+	if len(repos) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one repository is required"})
+		return
+	}
+
+	nameMatches, err := buildKeyMatcher(query, c.DefaultQuery("mode", "auto"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	searchValues := c.Query("values") == "true"
+	force := c.Query("refresh") == "true"
+
+	// Organizations default to the owners of the selected repositories
+	orgs := c.QueryArray("orgs")
+	if len(orgs) == 0 && c.Query("org_scope") != "false" {
+		for _, repo := range repos {
+			if owner, _, err := parseRepo(repo); err == nil && !contains(orgs, owner) {
+				orgs = append(orgs, owner)
+			}
+		}
+	}
+
+	matchVariable := func(v Variable) bool {
+		return nameMatches(v.Name) || (searchValues && nameMatches(v.Value))
+	}
+
+	results := []KeySearchResult{}
+	errors := []string{}
+	var oldest time.Time
+
+	track := func(entry keySearchEntry) {
+		if fetched := entry.fetchedAt(); !fetched.IsZero() && (oldest.IsZero() || fetched.Before(oldest)) {
+			oldest = fetched
+		}
+	}
+
+	for _, org := range orgs {
+		entry := searchCache.get(sessionID, user.Token, keySearchEntry{org: org}, force)
+		if entry.err != nil {
+			errors = append(errors, fmt.Sprintf("org %s: %v", org, entry.err))
+			continue
+		}
+		track(entry)
+		for _, v := range entry.orgSnap.Variables {
+			if matchVariable(v) {
+				results = append(results, KeySearchResult{Scope: "org", Org: org, Type: "variable", Name: v.Name, Value: v.Value, UpdatedAt: v.UpdatedAt})
+			}
+		}
+		for _, s := range entry.orgSnap.Secrets {
+			if nameMatches(s.Name) {
+				results = append(results, KeySearchResult{Scope: "org", Org: org, Type: "secret", Name: s.Name, UpdatedAt: s.UpdatedAt})
+			}
+		}
+	}
+
+	for _, repo := range repos {
+		owner, repoName, err := parseRepo(repo)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		entry := searchCache.get(sessionID, user.Token, keySearchEntry{owner: owner, repo: repoName}, force)
+		if entry.err != nil {
+			errors = append(errors, entry.err.Error())
+			continue
+		}
+		track(entry)
+
+		snap := entry.repoSnap
+		for _, v := range snap.Variables {
+			if matchVariable(v) {
+				results = append(results, KeySearchResult{Scope: "repository", Repo: repo, Type: "variable", Name: v.Name, Value: v.Value, UpdatedAt: v.UpdatedAt})
+			}
+		}
+		for _, s := range snap.Secrets {
+			if nameMatches(s.Name) {
+				results = append(results, KeySearchResult{Scope: "repository", Repo: repo, Type: "secret", Name: s.Name, UpdatedAt: s.UpdatedAt})
+			}
+		}
+		for _, env := range snap.Environments {
+			for _, v := range env.Variables {
+				if matchVariable(v) {
+					results = append(results, KeySearchResult{Scope: "environment", Repo: repo, Environment: env.Name, Type: "variable", Name: v.Name, Value: v.Value, UpdatedAt: v.UpdatedAt})
+				}
+			}
+			for _, s := range env.Secrets {
+				if nameMatches(s.Name) {
+					results = append(results, KeySearchResult{Scope: "environment", Repo: repo, Environment: env.Name, Type: "secret", Name: s.Name, UpdatedAt: s.UpdatedAt})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Name != results[j].Name {
+			return results[i].Name < results[j].Name
+		}
+		return results[i].Repo < results[j].Repo
+	})
+
+	response := gin.H{
+		"query":       query,
+		"results":     results,
+		"total_count": len(results),
+	}
+	if !oldest.IsZero() {
+		response["cached_at"] = oldest.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	if len(errors) > 0 {
+		response["errors"] = errors
+	}
+
+	c.JSON(http.StatusOK, response)
+}
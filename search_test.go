@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestBuildKeyMatcherGlobs(t *testing.T) {
+	cases := []struct {
+		query, mode, value string
+		want               bool
+	}{
+		{"https://*.example.com/*", "glob", "https://api.example.com/v1/users", true},
+		{"https://*.example.com/*", "glob", "http://api.example.com/v1", false},
+		{"db_*", "auto", "DB_PASSWORD", true},
+		{"db_*", "auto", "MY_DB_PASSWORD", false},
+		{"*/var/?og/*", "auto", "mount /var/log/app", true},
+		{"KEY_[!0-9]", "glob", "KEY_A", true},
+		{"KEY_[!0-9]", "glob", "KEY_1", false},
+		{"a.b", "glob", "axb", false},
+		{"token", "auto", "GITHUB_TOKEN_OLD", true},
+	}
+	for _, tc := range cases {
+		match, err := buildKeyMatcher(tc.query, tc.mode)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		if got := match(tc.value); got != tc.want {
+			t.Errorf("%q against %q: got %v, want %v", tc.query, tc.value, got, tc.want)
+		}
+	}
+
+	if _, err := buildKeyMatcher("KEY_[A", "glob"); err == nil {
+		t.Errorf("expected an unterminated class to be rejected")
+	}
+}
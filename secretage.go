@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Secret age and expiry tracking. GitHub only tells us when a secret was last
+// updated; owner, rotation interval, expiry date and notes are kept in the local
+// store and combined with that timestamp into a credential-age report.
+
+// SecretMetadata is locally stored information about one secret
+type SecretMetadata struct {
+	Location             KeyLocation `json:"location"`
+	Owner                string      `json:"owner,omitempty"`
+	RotationIntervalDays int         `json:"rotation_interval_days,omitempty"`
+	ExpiresAt            string      `json:"expires_at,omitempty"` // YYYY-MM-DD
+	Notes                string      `json:"notes,omitempty"`
+	UpdatedBy            string      `json:"updated_by,omitempty"`
+	UpdatedAt            string      `json:"updated_at,omitempty"`
+}
+
+// SecretAgeEntry is one secret of the age report
+type SecretAgeEntry struct {
+	Location             KeyLocation `json:"location"`
+	UpdatedAt            string      `json:"updated_at"`
+	AgeDays              int         `json:"age_days"`
+	RotationIntervalDays int         `json:"rotation_interval_days,omitempty"`
+	RotationDueAt        string      `json:"rotation_due_at,omitempty"`
+	ExpiresAt            string      `json:"expires_at,omitempty"`
+	Owner                string      `json:"owner,omitempty"`
+	Notes                string      `json:"notes,omitempty"`
+	Stale                bool        `json:"stale"`
+	Reasons              []string    `json:"reasons"` // "rotation_overdue", "expired", "expiring_soon"
+}
+
+// sameSecret compares two secret locations, ignoring org visibility
+func sameSecret(a, b KeyLocation) bool {
+	return a.Scope == b.Scope && a.Org == b.Org && a.Repo == b.Repo && a.Environment == b.Environment && a.Name == b.Name
+}
+
+func findSecretMetadata(metadata []SecretMetadata, loc KeyLocation) *SecretMetadata {
+	for i := range metadata {
+		if sameSecret(metadata[i].Location, loc) {
+			return &metadata[i]
+		}
+	}
+	return nil
+}
+
+// secretAge evaluates one secret against its metadata. defaultInterval applies to
+// secrets without a rotation interval of their own; 0 disables it.
+func secretAge(loc KeyLocation, updatedAt string, meta *SecretMetadata, defaultInterval, expiringWithin int, now time.Time) SecretAgeEntry {
+	entry := SecretAgeEntry{Location: loc, UpdatedAt: updatedAt, Reasons: []string{}}
+
+	updated, err := time.Parse("2006-01-02T15:04:05Z", updatedAt)
+	if err == nil {
+		entry.AgeDays = int(now.Sub(updated).Hours() / 24)
+	}
+
+	entry.RotationIntervalDays = defaultInterval
+	if meta != nil {
+		entry.Owner = meta.Owner
+		entry.Notes = meta.Notes
+		entry.ExpiresAt = meta.ExpiresAt
+		if meta.RotationIntervalDays > 0 {
+			entry.RotationIntervalDays = meta.RotationIntervalDays
+		}
+	}
+
+	if entry.RotationIntervalDays > 0 && err == nil {
+		due := updated.AddDate(0, 0, entry.RotationIntervalDays)
+		entry.RotationDueAt = due.Format("2006-01-02T15:04:05Z")
+		if now.After(due) {
+			entry.Reasons = append(entry.Reasons, "rotation_overdue")
+		}
+	}
+
+	if entry.ExpiresAt != "" {
+		if expires, err := time.Parse("2006-01-02", entry.ExpiresAt); err == nil {
+			switch {
+			case !now.Before(expires):
+				entry.Reasons = append(entry.Reasons, "expired")
+			case now.AddDate(0, 0, expiringWithin).After(expires):
+				entry.Reasons = append(entry.Reasons, "expiring_soon")
+			}
+		}
+	}
+
+	entry.Stale = len(entry.Reasons) > 0
+	return entry
+}
+
+// secretAgeReport lists the secrets of the repositories (and orgs) with their age
+func secretAgeReport(client *github.Client, ctx context.Context, repos, orgs []string, metadata []SecretMetadata, defaultInterval, expiringWithin int) ([]SecretAgeEntry, []string) {
+	now := time.Now().UTC()
+	entries := []SecretAgeEntry{}
+	errors := []string{}
+
+	add := func(loc KeyLocation, secret Secret) {
+		entries = append(entries, secretAge(loc, secret.UpdatedAt, findSecretMetadata(metadata, loc), defaultInterval, expiringWithin, now))
+	}
+
+	for _, org := range orgs {
+		secrets, err := listOrgSecrets(client, ctx, org)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("org %s: %v", org, err))
+			continue
+		}
+		for _, secret := range secrets {
+			add(KeyLocation{Scope: "org", Type: "secret", Org: org, Name: secret.Name}, secret)
+		}
+	}
+
+	for _, fullName := range repos {
+		owner, repo, err := parseRepo(fullName)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		snap, err := fetchRepoSnapshot(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		for _, secret := range snap.Secrets {
+			add(KeyLocation{Scope: "repository", Type: "secret", Repo: fullName, Name: secret.Name}, secret)
+		}
+		for _, env := range snap.Environments {
+			for _, secret := range env.Secrets {
+				add(KeyLocation{Scope: "environment", Type: "secret", Repo: fullName, Environment: env.Name, Name: secret.Name}, secret)
+			}
+		}
+	}
+
+	// Oldest first
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].AgeDays > entries[j].AgeDays })
+	return entries, errors
+}
+
+func getStaleSecrets(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	repos := c.QueryArray("repos")
+	if len(repos) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one repository is required"})
+		return
+	}
+
+	defaultInterval, err := strconv.Atoi(c.DefaultQuery("max_age_days", "0"))
+	if err != nil || defaultInterval < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_age_days must be a positive number"})
+		return
+	}
+	expiringWithin, err := strconv.Atoi(c.DefaultQuery("expiring_within_days", "14"))
+	if err != nil || expiringWithin < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiring_within_days must be a positive number"})
+		return
+	}
+	includeAll := c.Query("all") == "true"
+
+	// Organizations default to the owners of the selected repositories
+	orgs := c.QueryArray("orgs")
+	if len(orgs) == 0 && c.Query("org_scope") != "false" {
+		for _, repo := range repos {
+			if owner, _, err := parseRepo(repo); err == nil && !contains(orgs, owner) {
+				orgs = append(orgs, owner)
+			}
+		}
+	}
+
+	var metadata []SecretMetadata
+	store.view(func(data *StoreData) {
+		metadata = append(metadata, data.SecretMetadata...)
+	})
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	entries, errors := secretAgeReport(client, ctx, repos, orgs, metadata, defaultInterval, expiringWithin)
+
+	secrets := []SecretAgeEntry{}
+	staleCount := 0
+	for _, entry := range entries {
+		if entry.Stale {
+			staleCount++
+		}
+		if entry.Stale || includeAll {
+			secrets = append(secrets, entry)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secrets":      secrets,
+		"total_count":  len(entries),
+		"stale_count":  staleCount,
+		"generated_at": time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"errors":       errors,
+	})
+}
+
+func listSecretMetadata(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	repo := c.Query("repo")
+	org := c.Query("org")
+
+	stored := []SecretMetadata{}
+	store.view(func(data *StoreData) {
+		for _, meta := range data.SecretMetadata {
+			if (repo == "" || meta.Location.Repo == repo) && (org == "" || meta.Location.Org == org) {
+				stored = append(stored, meta)
+			}
+		}
+	})
+
+	// Only show metadata of locations the user can read
+	mayRead := readerFilter(user)
+	metadata := []SecretMetadata{}
+	for _, meta := range stored {
+		if mayRead(locationTargets([]KeyLocation{meta.Location})) {
+			metadata = append(metadata, meta)
+		}
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+func putSecretMetadata(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req SecretMetadata
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Location.Type = "secret"
+	req.Location.Visibility = ""
+	if err := req.Location.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location: " + err.Error()})
+		return
+	}
+	if !authorizeLocationEdit(c, user, req.Location) {
+		return
+	}
+	if req.RotationIntervalDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rotation_interval_days must be a positive number"})
+		return
+	}
+	if req.ExpiresAt != "" {
+		if _, err := time.Parse("2006-01-02", req.ExpiresAt); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+
+	req.UpdatedBy = user.Login
+	req.UpdatedAt = time.Now().UTC().Format("2006-01-02T15:04:05Z")
+
+	err = store.update(func(data *StoreData) error {
+		if existing := findSecretMetadata(data.SecretMetadata, req.Location); existing != nil {
+			*existing = req
+			return nil
+		}
+		data.SecretMetadata = append(data.SecretMetadata, req)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save metadata: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func deleteSecretMetadata(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	loc := KeyLocation{
+		Scope:       c.Query("scope"),
+		Type:        "secret",
+		Org:         c.Query("org"),
+		Repo:        c.Query("repo"),
+		Environment: c.Query("environment"),
+		Name:        c.Query("name"),
+	}
+	if err := loc.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location: " + err.Error()})
+		return
+	}
+	if !authorizeLocationEdit(c, user, loc) {
+		return
+	}
+
+	found := false
+	err = store.update(func(data *StoreData) error {
+		kept := data.SecretMetadata[:0]
+		for _, meta := range data.SecretMetadata {
+			if sameSecret(meta.Location, loc) {
+				found = true
+				continue
+			}
+			kept = append(kept, meta)
+		}
+		data.SecretMetadata = kept
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to delete metadata: %v", err)})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No metadata for " + loc.String()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Secret metadata deleted successfully"})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecretAge(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	loc := KeyLocation{Scope: "repository", Type: "secret", Repo: "acme/svc", Name: "API_KEY"}
+
+	tests := []struct {
+		name      string
+		updatedAt string
+		meta      *SecretMetadata
+		interval  int
+		reasons   []string
+		ageDays   int
+	}{
+		{"fresh", "2026-05-01T00:00:00Z", nil, 90, []string{}, 31},
+		{"no interval", "2020-01-01T00:00:00Z", nil, 0, []string{}, 2343},
+		{"rotation overdue", "2026-01-01T00:00:00Z", nil, 90, []string{"rotation_overdue"}, 151},
+		{"metadata interval wins", "2026-05-01T00:00:00Z", &SecretMetadata{RotationIntervalDays: 7}, 90, []string{"rotation_overdue"}, 31},
+		{"expired", "2026-05-01T00:00:00Z", &SecretMetadata{ExpiresAt: "2026-06-01"}, 90, []string{"expired"}, 31},
+		{"expiring soon", "2026-05-01T00:00:00Z", &SecretMetadata{ExpiresAt: "2026-06-10"}, 90, []string{"expiring_soon"}, 31},
+		{"expiring later", "2026-05-01T00:00:00Z", &SecretMetadata{ExpiresAt: "2026-08-01"}, 90, []string{}, 31},
+		{"overdue and expired", "2025-01-01T00:00:00Z", &SecretMetadata{ExpiresAt: "2026-01-01"}, 90, []string{"rotation_overdue", "expired"}, 516},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := secretAge(loc, tt.updatedAt, tt.meta, tt.interval, 14, now)
+			if !reflect.DeepEqual(entry.Reasons, tt.reasons) {
+				t.Errorf("reasons = %v, want %v", entry.Reasons, tt.reasons)
+			}
+			if entry.Stale != (len(tt.reasons) > 0) {
+				t.Errorf("stale = %t with reasons %v", entry.Stale, entry.Reasons)
+			}
+			if entry.AgeDays != tt.ageDays {
+				t.Errorf("age = %d days, want %d", entry.AgeDays, tt.ageDays)
+			}
+		})
+	}
+}
+
+func TestSecretMetadataNeedsAccessToTheLocation(t *testing.T) {
+	testStore(t)
+	fakeRepoPermissions(t, map[string]string{"meta-reader": "pull", "meta-writer": "push"})
+
+	stored := SecretMetadata{Location: KeyLocation{Scope: "repository", Type: "secret", Repo: "acme/svc", Name: "API_KEY"}, Owner: "team-a"}
+	store.update(func(data *StoreData) error {
+		data.SecretMetadata = append(data.SecretMetadata, stored)
+		return nil
+	})
+
+	router := gin.New()
+	router.GET("/api/secrets/metadata", listSecretMetadata)
+	router.PUT("/api/secrets/metadata", putSecretMetadata)
+	router.DELETE("/api/secrets/metadata", deleteSecretMetadata)
+	call := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Session-ID", testSession(t, token, token))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(token string) []SecretMetadata {
+		var metadata []SecretMetadata
+		json.Unmarshal(call(http.MethodGet, "/api/secrets/metadata", "", token).Body.Bytes(), &metadata)
+		return metadata
+	}
+
+	if metadata := list("meta-reader"); len(metadata) != 1 {
+		t.Errorf("reader: got %d entries, want 1", len(metadata))
+	}
+	if metadata := list("meta-stranger"); len(metadata) != 0 {
+		t.Errorf("user without access: got %d entries, want 0", len(metadata))
+	}
+
+	body := `{"location":{"scope":"repository","repo":"acme/svc","name":"API_KEY"},"owner":"mallory"}`
+	if rec := call(http.MethodPut, "/api/secrets/metadata", body, "meta-reader"); rec.Code != http.StatusForbidden {
+		t.Errorf("reader put: status %d, want 403", rec.Code)
+	}
+	query := "/api/secrets/metadata?scope=repository&repo=acme/svc&name=API_KEY"
+	if rec := call(http.MethodDelete, query, "", "meta-reader"); rec.Code != http.StatusForbidden {
+		t.Errorf("reader delete: status %d, want 403", rec.Code)
+	}
+	if metadata := list("meta-writer"); len(metadata) != 1 || metadata[0].Owner != "team-a" {
+		t.Fatalf("metadata changed by a reader: %+v", metadata)
+	}
+
+	if rec := call(http.MethodPut, "/api/secrets/metadata", body, "meta-writer"); rec.Code != http.StatusOK {
+		t.Errorf("writer put: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(http.MethodDelete, query, "", "meta-writer"); rec.Code != http.StatusOK {
+		t.Errorf("writer delete: status %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Generators for new secret values. Key pairs are returned in OpenSSH format:
+// the private key becomes the secret value and the public key is handed back to
+// the caller so it can be installed where it's needed (deploy keys, servers).
+
+// SecretGenerator describes how to generate a new secret value
+type SecretGenerator struct {
+	Type      string `json:"type"`                // "random", "passphrase", "rsa" or "ed25519"
+	Length    int    `json:"length,omitempty"`    // random: number of bytes (default 32)
+	Encoding  string `json:"encoding,omitempty"`  // random: "base64" (default), "base64url" or "hex"
+	Words     int    `json:"words,omitempty"`     // passphrase: number of words (default 12, at least 8)
+	Separator string `json:"separator,omitempty"` // passphrase: word separator (default "-")
+	Bits      int    `json:"bits,omitempty"`      // rsa: key size (default 4096)
+	Comment   string `json:"comment,omitempty"`   // rsa/ed25519: key comment
+}
+
+// GeneratedSecret is a freshly generated value. PublicKey is set for key pairs.
+type GeneratedSecret struct {
+	Value     string
+	PublicKey string
+}
+
+func (g *SecretGenerator) generate() (*GeneratedSecret, error) {
+	switch g.Type {
+	case "random":
+		return g.generateRandom()
+	case "passphrase":
+		return g.generatePassphrase()
+	case "rsa":
+		bits := g.Bits
+		if bits == 0 {
+			bits = 4096
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		return marshalKeyPair(key, &key.PublicKey, g.Comment)
+	case "ed25519":
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return marshalKeyPair(private, public, g.Comment)
+	default:
+		return nil, fmt.Errorf("unknown generator %q", g.Type)
+	}
+}
+
+func (g *SecretGenerator) generateRandom() (*GeneratedSecret, error) {
+	length := g.Length
+	if length == 0 {
+		length = 32
+	}
+	if length < 16 || length > 1024 {
+		return nil, fmt.Errorf("length must be between 16 and 1024 bytes")
+	}
+
+	raw := make([]byte, length)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+
+	switch g.Encoding {
+	case "", "base64":
+		return &GeneratedSecret{Value: base64.StdEncoding.EncodeToString(raw)}, nil
+	case "base64url":
+		return &GeneratedSecret{Value: base64.RawURLEncoding.EncodeToString(raw)}, nil
+	case "hex":
+		return &GeneratedSecret{Value: hex.EncodeToString(raw)}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", g.Encoding)
+	}
+}
+
+func (g *SecretGenerator) generatePassphrase() (*GeneratedSecret, error) {
+	// 8 bits per word: 64 bits at the least, 96 by default
+	count := g.Words
+	if count == 0 {
+		count = 12
+	}
+	if count < 8 || count > 32 {
+		return nil, fmt.Errorf("words must be between 8 and 32")
+	}
+	separator := g.Separator
+	if separator == "" {
+		separator = "-"
+	}
+
+	words := make([]string, count)
+	max := big.NewInt(int64(len(passphraseWords)))
+	for i := range words {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		words[i] = passphraseWords[n.Int64()]
+	}
+	return &GeneratedSecret{Value: strings.Join(words, separator)}, nil
+}
+
+func marshalKeyPair(private interface{}, public interface{}, comment string) (*GeneratedSecret, error) {
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublic)))
+	if comment != "" {
+		authorized += " " + comment
+	}
+	return &GeneratedSecret{
+		Value:     string(pem.EncodeToMemory(block)),
+		PublicKey: authorized,
+	}, nil
+}
+
+// passphraseWords is a list of 256 short, unambiguous words (8 bits per word)
+var passphraseWords = []string{
+	"acid", "acorn", "actor", "adobe", "agent", "alarm", "album", "alley",
+	"amber", "anchor", "angle", "ankle", "apple", "apron", "arena", "arrow",
+	"aspen", "atlas", "attic", "audio", "award", "bacon", "badge", "bagel",
+	"baker", "bamboo", "banjo", "barn", "basil", "basin", "beach", "beacon",
+	"bean", "bench", "berry", "bison", "blade", "blaze", "bloom", "board",
+	"boat", "bonus", "boots", "brain", "brick", "bride", "brook", "brush",
+	"bucket", "buddy", "bugle", "cabin", "cable", "cactus", "camel", "candle",
+	"canoe", "canyon", "cargo", "carpet", "castle", "cedar", "chalk", "charm",
+	"cheese", "cherry", "chess", "chief", "cider", "cinema", "circus", "citrus",
+	"clay", "cliff", "clock", "cloud", "clover", "coast", "cobra", "cocoa",
+	"comet", "coral", "cotton", "cowboy", "crane", "crayon", "creek", "cricket",
+	"crown", "cubic", "curry", "daisy", "dance", "delta", "denim", "desert",
+	"diary", "dingo", "disco", "dock", "dolphin", "donut", "dragon", "drum",
+	"dune", "eagle", "easel", "echo", "eclipse", "elbow", "elder", "ember",
+	"emerald", "engine", "falcon", "fable", "fabric", "feather", "fender", "fern",
+	"ferry", "fiddle", "field", "flame", "flask", "fleet", "flint", "flute",
+	"forest", "fossil", "fox", "frost", "fudge", "galaxy", "garden", "garlic",
+	"gecko", "geyser", "giant", "ginger", "glacier", "globe", "glove", "goat",
+	"gopher", "grape", "gravel", "guitar", "hammer", "harbor", "harp", "hazel",
+	"hedge", "helmet", "heron", "hiker", "honey", "horizon", "hornet", "igloo",
+	"indigo", "island", "ivory", "jacket", "jaguar", "jelly", "jewel", "jungle",
+	"kayak", "kettle", "kiwi", "koala", "ladder", "lagoon", "lantern", "laser",
+	"lemon", "lever", "lilac", "lizard", "llama", "lobster", "locket", "lotus",
+	"magnet", "mango", "maple", "marble", "meadow", "melon", "meteor", "mint",
+	"mirror", "mosaic", "motor", "muffin", "nectar", "needle", "nickel", "noodle",
+	"nutmeg", "oasis", "ocean", "olive", "onion", "opal", "orbit", "orchid",
+	"otter", "oyster", "paddle", "panda", "paper", "parrot", "peach", "pebble",
+	"pepper", "piano", "pilot", "pixel", "planet", "plum", "pony", "prism",
+	"puzzle", "quartz", "quill", "rabbit", "radar", "raven", "reef", "ribbon",
+	"rocket", "saddle", "salmon", "sandal", "scarf", "shovel", "silver", "sketch",
+	"sparrow", "spider", "spruce", "squid", "stone", "sunset", "tablet", "tango",
+	"teapot", "thunder", "tiger", "timber", "tulip", "turtle", "velvet", "walnut",
+}
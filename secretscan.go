@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Scanner for credentials stored as plain variables. Variables are returned in
+// cleartext by the API and shown in the UI and exports, so anything that looks
+// like a token, key or password is flagged and can be converted to a secret.
+
+var knownTokenPrefixes = []struct {
+	prefix string
+	label  string
+}{
+	{"ghp_", "GitHub personal access token"},
+	{"gho_", "GitHub OAuth token"},
+	{"ghu_", "GitHub user-to-server token"},
+	{"ghs_", "GitHub server-to-server token"},
+	{"ghr_", "GitHub refresh token"},
+	{"github_pat_", "GitHub fine-grained token"},
+	{"AKIA", "AWS access key ID"},
+	{"ASIA", "AWS temporary access key ID"},
+	{"xoxb-", "Slack bot token"},
+	{"xoxp-", "Slack user token"},
+	{"xoxa-", "Slack app token"},
+	{"xapp-", "Slack app-level token"},
+	{"sk_live_", "Stripe secret key"},
+	{"rk_live_", "Stripe restricted key"},
+	{"glpat-", "GitLab personal access token"},
+	{"AIza", "Google API key"},
+	{"npm_", "npm access token"},
+}
+
+var (
+	privateKeyBlock      = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY( BLOCK)?-----`)
+	connectionWithSecret = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:[^/\s@]+@`)
+	passwordParameter    = regexp.MustCompile(`(?i)(password|pwd|secret|token)=[^;&\s]+`)
+	secretNameHint       = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|PRIVATE_KEY|API_KEY|CREDENTIAL)`)
+	// URLs and ARNs mix many characters without being random; the credential
+	// patterns above still catch the ones with embedded passwords
+	structuredValue = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://|arn:[a-z-]+:)`)
+)
+
+// Values at least this long with at least this many bits of entropy per character
+// are treated as random credentials. Ordinary config values (hostnames, URLs,
+// ARNs) stay below it or are excluded; a random 32-character alphanumeric key
+// averages about 4.5.
+const (
+	entropyMinLength = 24
+	entropyThreshold = 4.3
+)
+
+// SecretFinding is a variable whose value looks like a credential
+type SecretFinding struct {
+	Scope       string   `json:"scope"` // "repository" or "environment"
+	Environment string   `json:"environment,omitempty"`
+	Name        string   `json:"name"`
+	Severity    string   `json:"severity"` // "high" or "medium"
+	Reasons     []string `json:"reasons"`
+	Preview     string   `json:"preview"`
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// maskValue keeps just enough of a value to recognise it
+func maskValue(value string) string {
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", 8) + value[len(value)-2:]
+}
+
+// scanValue returns why a variable value looks like a credential and how sure we are
+func scanValue(name, value string) ([]string, string) {
+	var reasons []string
+	severity := ""
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return nil, ""
+	}
+
+	for _, known := range knownTokenPrefixes {
+		if strings.HasPrefix(trimmed, known.prefix) && len(trimmed) > len(known.prefix)+8 {
+			reasons = append(reasons, "known token prefix: "+known.label)
+			severity = "high"
+			break
+		}
+	}
+	if privateKeyBlock.MatchString(trimmed) {
+		reasons = append(reasons, "contains a private key block")
+		severity = "high"
+	}
+	if connectionWithSecret.MatchString(trimmed) || passwordParameter.MatchString(trimmed) {
+		reasons = append(reasons, "connection string with an embedded password")
+		severity = "high"
+	}
+	if len(trimmed) >= entropyMinLength && !strings.ContainsAny(trimmed, " \t\n") && !structuredValue.MatchString(trimmed) {
+		if entropy := shannonEntropy(trimmed); entropy >= entropyThreshold {
+			reasons = append(reasons, fmt.Sprintf("high entropy value (%.1f bits/char)", entropy))
+			if severity == "" {
+				severity = "medium"
+			}
+		}
+	}
+	if secretNameHint.MatchString(name) {
+		reasons = append(reasons, "name suggests a credential")
+		if severity == "" {
+			severity = "medium"
+		}
+	}
+
+	return reasons, severity
+}
+
+func scanVariables(scope, env string, variables []Variable) []SecretFinding {
+	findings := []SecretFinding{}
+	for _, v := range variables {
+		reasons, severity := scanValue(v.Name, v.Value)
+		if len(reasons) == 0 {
+			continue
+		}
+		findings = append(findings, SecretFinding{
+			Scope:       scope,
+			Environment: env,
+			Name:        v.Name,
+			Severity:    severity,
+			Reasons:     reasons,
+			Preview:     maskValue(v.Value),
+		})
+	}
+	return findings
+}
+
+func scanRepoForSecrets(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	snap, err := fetchRepoSnapshot(client, ctx, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	findings := scanVariables("repository", "", snap.Variables)
+	for _, env := range snap.Environments {
+		findings = append(findings, scanVariables("environment", env.Name, env.Variables)...)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repo":     owner + "/" + repo,
+		"findings": findings,
+	})
+}
+
+// convertVariableToSecret creates a secret with the variable's current value and
+// only deletes the variable once the secret has been written. An existing secret
+// of the same name is only replaced with overwrite.
+func convertVariableToSecret(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	var req struct {
+		Name        string `json:"name"`
+		Environment string `json:"environment"`
+		Overwrite   bool   `json:"overwrite"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Variable name is required"})
+		return
+	}
+
+	if req.Environment != "" {
+		// Reject secrets the policy doesn't allow in this environment, and
+		// removing a variable it requires
+		violations := activePolicy.checkKey(req.Environment, "secret", req.Name)
+		violations = append(violations, activePolicy.checkDelete(req.Environment, "variable", req.Name)...)
+		if rejectPolicyViolations(c, violations) {
+			return
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	if !req.Overwrite {
+		loc := KeyLocation{Scope: "repository", Type: "secret", Repo: owner + "/" + repo, Name: req.Name}
+		if req.Environment != "" {
+			loc.Scope, loc.Environment = "environment", req.Environment
+		}
+		exists, err := keyExists(client, ctx, loc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check for an existing secret: %v", err)})
+			return
+		}
+		if exists {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Secret %s already exists; set overwrite to replace it", req.Name)})
+			return
+		}
+	}
+
+	if req.Environment == "" {
+		variable, _, err := client.Actions.GetRepoVariable(ctx, owner, repo, req.Name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Failed to read variable: %v", err)})
+			return
+		}
+		if err := putRepoSecret(client, ctx, owner, repo, req.Name, variable.Value); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := client.Actions.DeleteRepoVariable(ctx, owner, repo, req.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Secret created but failed to delete variable: %v", err)})
+			return
+		}
+	} else {
+		repository, _, err := client.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get repository: %v", err)})
+			return
+		}
+		variable, _, err := client.Actions.GetEnvVariable(ctx, owner, repo, req.Environment, req.Name)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Failed to read variable: %v", err)})
+			return
+		}
+		if err := putEnvironmentSecret(client, ctx, repository.GetID(), req.Environment, req.Name, variable.Value); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := client.Actions.DeleteEnvVariable(ctx, owner, repo, req.Environment, req.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Secret created but failed to delete variable: %v", err)})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Converted %s to a secret", req.Name)})
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Secret value sources. GitHub never returns secret values, so anything that
+// writes secrets to many targets needs the values from somewhere else: inline
+// values, an uploaded encrypted bundle, a file on the server, the server's own
+// environment, or a HashiCorp Vault KV path.
+
+var (
+	// secretFilesDir restricts the "file" source to files under this directory;
+	// the source is disabled when it is empty
+	secretFilesDir = ""
+	// secretEnvPrefix is prepended to key names for the "env" source so only
+	// variables meant for this tool can be read from the server environment;
+	// the source is disabled when it is empty
+	secretEnvPrefix = ""
+	// vaultServerPrefix is the only part of Vault ("mount/path") that requests
+	// without their own vault_token may read with the server's VAULT_TOKEN; the
+	// server token is not used at all when it is empty
+	vaultServerPrefix = ""
+	// vaultAllowedAddrs are the only Vault addresses a request may name in
+	// vault_addr, so the server can't be pointed at arbitrary internal URLs
+	vaultAllowedAddrs []string
+)
+
+// SecretSource describes where secret values come from
+type SecretSource struct {
+	Type string `json:"type"` // "values", "bundle", "file", "env" or "vault"
+
+	// values
+	Values map[string]string `json:"values,omitempty"`
+
+	// bundle: base64 of an encrypted bundle, opened with a passphrase or an age identity
+	Bundle     string `json:"bundle,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+
+	// file: path relative to --secret-files-dir, dotenv or JSON object
+	// vault: KV path below the mount
+	Path string `json:"path,omitempty"`
+
+	// vault
+	VaultAddr    string `json:"vault_addr,omitempty"`
+	VaultToken   string `json:"vault_token,omitempty"`
+	VaultMount   string `json:"vault_mount,omitempty"`
+	VaultVersion int    `json:"vault_kv_version,omitempty"`
+}
+
+// resolveSecretValues loads the values of a source. When names is non-empty only
+// those keys are returned and a missing one is an error. Server-side sources
+// ("file" and "env") only hand out the names asked for.
+func resolveSecretValues(source *SecretSource, names []string) (map[string]string, error) {
+	var values map[string]string
+	var err error
+
+	if (source.Type == "file" || source.Type == "env") && len(names) == 0 {
+		return nil, fmt.Errorf("the %s secret source needs the secret names to read", source.Type)
+	}
+
+	switch source.Type {
+	case "values":
+		values = source.Values
+	case "bundle":
+		values, err = secretsFromBundle(source)
+	case "file":
+		values, err = secretsFromFile(source.Path)
+	case "env":
+		values, err = secretsFromEnvironment(names)
+	case "vault":
+		values, err = secretsFromVault(source)
+	default:
+		return nil, fmt.Errorf("unknown secret source %q", source.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		if values == nil {
+			values = map[string]string{}
+		}
+		return values, nil
+	}
+
+	selected := make(map[string]string, len(names))
+	var missing []string
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		selected[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("secret source has no value for %s", strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
+
+func secretsFromBundle(source *SecretSource) (map[string]string, error) {
+	data, err := base64.StdEncoding.DecodeString(source.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("bundle must be base64 encoded: %v", err)
+	}
+	payload, err := openBundle(data, BundleKeys{Passphrase: source.Passphrase, Identity: source.Identity})
+	if err != nil {
+		return nil, err
+	}
+	return payload.allSecrets(), nil
+}
+
+// parseDotenv parses KEY=VALUE lines, ignoring comments, blank lines and an
+// optional "export " prefix, and strips matching surrounding quotes
+func parseDotenv(content string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values
+}
+
+func secretsFromFile(path string) (map[string]string, error) {
+	if secretFilesDir == "" {
+		return nil, fmt.Errorf("file sources are disabled, start the server with --secret-files-dir")
+	}
+
+	base, err := filepath.Abs(secretFilesDir)
+	if err != nil {
+		return nil, err
+	}
+	full, err := filepath.Abs(filepath.Join(base, path))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(full, base+string(filepath.Separator)) {
+		return nil, fmt.Errorf("path %q is outside the secret files directory", path)
+	}
+
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret file: %v", err)
+	}
+
+	if strings.HasSuffix(full, ".json") {
+		var values map[string]string
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("secret file must be a JSON object of strings: %v", err)
+		}
+		return values, nil
+	}
+	return parseDotenv(string(data)), nil
+}
+
+// secretsFromEnvironment reads <prefix><NAME> from the server environment
+func secretsFromEnvironment(names []string) (map[string]string, error) {
+	if secretEnvPrefix == "" {
+		return nil, fmt.Errorf("env sources are disabled, start the server with --secret-env-prefix")
+	}
+	values := make(map[string]string)
+	for _, name := range names {
+		if value, ok := os.LookupEnv(secretEnvPrefix + name); ok {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// vaultAddrAllowed reports whether a request may read from addr
+func vaultAddrAllowed(addr string) bool {
+	for _, allowed := range vaultAllowedAddrs {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), strings.TrimRight(addr, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretsFromVault reads a KV secret. Address and token default to VAULT_ADDR and
+// VAULT_TOKEN, the mount to "secret" and the engine version to 2. Other
+// addresses must be listed in --vault-allowed-addrs.
+func secretsFromVault(source *SecretSource) (map[string]string, error) {
+	mount := source.VaultMount
+	if mount == "" {
+		mount = "secret"
+	}
+	if source.Path == "" {
+		return nil, fmt.Errorf("vault path is required")
+	}
+
+	addr := source.VaultAddr
+	token := source.VaultToken
+	if token == "" {
+		// The server's token only ever goes to the server's Vault, and only for
+		// paths the operator opened up; otherwise anyone could point it at their
+		// own address or read whatever the server identity can
+		if source.VaultAddr != "" {
+			return nil, fmt.Errorf("vault_addr can only be set together with vault_token")
+		}
+		if vaultServerPrefix == "" {
+			return nil, fmt.Errorf("vault_token is required, start the server with --vault-server-prefix to use its VAULT_TOKEN")
+		}
+		if !withinVaultPrefix(mount+"/"+source.Path, vaultServerPrefix) {
+			return nil, fmt.Errorf("vault path %s/%s is outside %s", strings.Trim(mount, "/"), strings.TrimLeft(source.Path, "/"), vaultServerPrefix)
+		}
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	switch {
+	case addr == "":
+		addr = os.Getenv("VAULT_ADDR")
+	case !vaultAddrAllowed(addr):
+		return nil, fmt.Errorf("vault address %s is not allowed, add it with --vault-allowed-addrs", addr)
+	}
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/%s", strings.TrimRight(addr, "/"), strings.Trim(mount, "/"), strings.TrimLeft(source.Path, "/"))
+	if source.VaultVersion != 1 {
+		url = fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimRight(addr, "/"), strings.Trim(mount, "/"), strings.TrimLeft(source.Path, "/"))
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d for %s", resp.StatusCode, source.Path)
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse vault response: %v", err)
+	}
+
+	// KV v2 nests the key/value pairs one level deeper, next to the metadata
+	data := response.Data
+	if source.VaultVersion != 1 {
+		nested, _ := data["data"].(map[string]interface{})
+		data = nested
+	}
+
+	values := make(map[string]string, len(data))
+	for key, value := range data {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		default:
+			encoded, _ := json.Marshal(v)
+			values[key] = string(encoded)
+		}
+	}
+	return values, nil
+}
+
+// syncSecretValues writes secrets to every target repository, or to every target
+// environment of it when environments are given. Existing secrets are left alone
+// unless overwrite is set. It returns the number of secrets written, the ones
+// skipped and any errors.
+func syncSecretValues(client *github.Client, ctx context.Context, targetRepos, targetEnvs []string, values map[string]string, overwrite bool) (int, []string, []string) {
+	synced := 0
+	skipped := []string{}
+	errors := []string{}
+	names := sortedKeys(values)
+
+	for _, targetRepo := range targetRepos {
+		owner, repo, err := parseRepo(targetRepo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("Invalid target repo format: %s", targetRepo))
+			continue
+		}
+
+		if len(targetEnvs) == 0 {
+			existing := []Secret{}
+			if !overwrite {
+				if existing, err = listRepoSecrets(client, ctx, owner, repo); err != nil {
+					errors = append(errors, fmt.Sprintf("%s: %v", targetRepo, err))
+					continue
+				}
+			}
+			for _, name := range names {
+				if hasSecret(existing, name) {
+					skipped = append(skipped, fmt.Sprintf("%s %s", targetRepo, name))
+					continue
+				}
+				if err := putRepoSecret(client, ctx, owner, repo, name, values[name]); err != nil {
+					errors = append(errors, fmt.Sprintf("%s %s: %v", targetRepo, name, err))
+					continue
+				}
+				synced++
+			}
+			continue
+		}
+
+		repoID, err := repositoryID(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", targetRepo, err))
+			continue
+		}
+		for _, env := range targetEnvs {
+			existing := []Secret{}
+			if !overwrite {
+				if existing, err = listEnvironmentSecrets(client, ctx, repoID, env); err != nil {
+					errors = append(errors, fmt.Sprintf("%s:%s: %v", targetRepo, env, err))
+					continue
+				}
+			}
+			for _, name := range names {
+				if hasSecret(existing, name) {
+					skipped = append(skipped, fmt.Sprintf("%s:%s %s", targetRepo, env, name))
+					continue
+				}
+				if err := putEnvironmentSecret(client, ctx, repoID, env, name, values[name]); err != nil {
+					errors = append(errors, fmt.Sprintf("%s:%s %s: %v", targetRepo, env, name, err))
+					continue
+				}
+				synced++
+			}
+		}
+	}
+
+	return synced, skipped, errors
+}
+
+func hasSecret(secrets []Secret, name string) bool {
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in a stable order for reports
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withinVaultPrefix reports whether a "mount/path" lies under prefix, after
+// resolving dot segments so ".." can't climb out of it. Escapes and query
+// characters are refused outright since Vault would decode them after us.
+func withinVaultPrefix(p, prefix string) bool {
+	if strings.ContainsAny(p, "%?#") {
+		return false
+	}
+	clean := path.Clean("/" + p)
+	base := path.Clean("/" + prefix)
+	return clean == base || strings.HasPrefix(clean, strings.TrimRight(base, "/")+"/")
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeVault answers every KV v2 read with one value and counts the requests
+func fakeVault(t *testing.T, token string) (*httptest.Server, *int) {
+	t.Helper()
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"API_KEY":"from-vault"}}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestVaultServerTokenNeverLeavesServerAddress(t *testing.T) {
+	serverVault, _ := fakeVault(t, "server-token")
+	attacker, attackerHits := fakeVault(t, "server-token")
+	t.Setenv("VAULT_ADDR", serverVault.URL)
+	t.Setenv("VAULT_TOKEN", "server-token")
+	vaultServerPrefix = "secret/apps"
+	t.Cleanup(func() { vaultServerPrefix = "" })
+
+	_, err := secretsFromVault(&SecretSource{Type: "vault", Path: "apps/billing", VaultAddr: attacker.URL})
+	if err == nil || !strings.Contains(err.Error(), "vault_token") {
+		t.Fatalf("expected a client vault_addr without vault_token to be refused, got %v", err)
+	}
+	if *attackerHits != 0 {
+		t.Fatalf("server token was sent to the client's address")
+	}
+
+	values, err := secretsFromVault(&SecretSource{Type: "vault", Path: "apps/billing"})
+	if err != nil || values["API_KEY"] != "from-vault" {
+		t.Fatalf("expected the server's Vault to be read, got %v, %v", values, err)
+	}
+
+	// Even with its own token a client may only name allowed addresses
+	if _, err := secretsFromVault(&SecretSource{Type: "vault", Path: "anything", VaultAddr: attacker.URL, VaultToken: "server-token"}); err == nil || *attackerHits != 0 {
+		t.Fatalf("expected an unlisted vault_addr to be refused, got %v", err)
+	}
+	vaultAllowedAddrs = []string{attacker.URL + "/"}
+	t.Cleanup(func() { vaultAllowedAddrs = nil })
+	values, err = secretsFromVault(&SecretSource{Type: "vault", Path: "anything", VaultAddr: attacker.URL, VaultToken: "server-token"})
+	if err != nil || values["API_KEY"] != "from-vault" || *attackerHits != 1 {
+		t.Fatalf("expected the allowed Vault to be read, got %v, %v", values, err)
+	}
+}
+
+func TestServerSideSourcesAreOptInAndNeedNames(t *testing.T) {
+	t.Setenv("GEM_SECRET_API_KEY", "from-env")
+	t.Setenv("GEM_SECRET_OTHER", "not-asked-for")
+
+	if _, err := resolveSecretValues(&SecretSource{Type: "env"}, []string{"API_KEY"}); err == nil {
+		t.Fatalf("expected the env source to be off without --secret-env-prefix")
+	}
+
+	secretEnvPrefix = "GEM_SECRET_"
+	t.Cleanup(func() { secretEnvPrefix = "" })
+	if values, err := resolveSecretValues(&SecretSource{Type: "env"}, nil); err == nil {
+		t.Fatalf("expected the env source to refuse listing everything, got %v", values)
+	}
+	values, err := resolveSecretValues(&SecretSource{Type: "env"}, []string{"API_KEY"})
+	if err != nil || len(values) != 1 || values["API_KEY"] != "from-env" {
+		t.Fatalf("expected only API_KEY, got %v, %v", values, err)
+	}
+
+	secretFilesDir = t.TempDir()
+	t.Cleanup(func() { secretFilesDir = "" })
+	if _, err := resolveSecretValues(&SecretSource{Type: "file", Path: "app.env"}, nil); err == nil {
+		t.Fatalf("expected the file source to need names")
+	}
+}
+
+func TestVaultServerTokenLimitedToPrefix(t *testing.T) {
+	serverVault, hits := fakeVault(t, "server-token")
+	t.Setenv("VAULT_ADDR", serverVault.URL)
+	t.Setenv("VAULT_TOKEN", "server-token")
+
+	if _, err := secretsFromVault(&SecretSource{Type: "vault", Path: "apps/billing"}); err == nil {
+		t.Fatalf("expected the server token to be unusable without --vault-server-prefix")
+	}
+
+	vaultServerPrefix = "secret/apps"
+	t.Cleanup(func() { vaultServerPrefix = "" })
+	for _, source := range []SecretSource{
+		{Path: "admin/root"},
+		{Path: "apps/../admin/root"},
+		{Path: "apps/%2e%2e/admin"},
+		{Path: "appsx/billing"},
+		{Path: "apps/billing", VaultMount: "other"},
+	} {
+		source.Type = "vault"
+		if _, err := secretsFromVault(&source); err == nil {
+			t.Errorf("expected %s/%s to be outside the prefix", source.VaultMount, source.Path)
+		}
+	}
+	if *hits != 0 {
+		t.Fatalf("vault was queried for a refused path")
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,12 +23,15 @@ import (
 
 // GitHub API response structures
 type Repository struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	FullName    string `json:"full_name"`
-	Description string `json:"description"`
-	Private     bool   `json:"private"`
-	Owner       Owner  `json:"owner"`
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	FullName    string   `json:"full_name"`
+	Description string   `json:"description"`
+	Private     bool     `json:"private"`
+	Visibility  string   `json:"visibility"`
+	Archived    bool     `json:"archived"`
+	Topics      []string `json:"topics"`
+	Owner       Owner    `json:"owner"`
 }
 
 type Owner struct {
@@ -49,17 +54,66 @@ type Secret struct {
 
 // Production-ready GitHub Environment Manager - No mock data
 
-// Store authenticated users in memory (in production, use a proper session store)
-var authenticatedUsers = make(map[string]User)
+// Store authenticated users in memory (in production, use a proper session store).
+// Handlers, the scheduler and the GitHub App transport all reach it concurrently,
+// so it is only touched through the session helpers below.
+var (
+	authenticatedUsers = make(map[string]User)
+	sessionsMu         sync.RWMutex
+)
+
+func loadSession(sessionID string) (User, bool) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	user, exists := authenticatedUsers[sessionID]
+	return user, exists
+}
+
+func saveSession(sessionID string, user User) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	authenticatedUsers[sessionID] = user
+}
+
+func deleteSession(sessionID string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	delete(authenticatedUsers, sessionID)
+}
+
+// findSession returns a session whose user matches
+func findSession(match func(user User) bool) (string, User, bool) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for sessionID, user := range authenticatedUsers {
+		if match(user) {
+			return sessionID, user, true
+		}
+	}
+	return "", User{}, false
+}
 
 type User struct {
 	Login     string `json:"login"`
 	Name      string `json:"name"`
 	AvatarURL string `json:"avatarUrl"`
 	Token     string `json:"-"` // Don't expose token in JSON
+
+	// Set for device flow logins with expiring user tokens
+	RefreshToken string    `json:"-"`
+	ExpiresAt    time.Time `json:"-"`
 }
 
 func getAuthURL(c *gin.Context) {
+	if oauthClientID != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"type":         "device",
+			"instructions": "Sign in with GitHub, or use a Personal Access Token with 'repo' and 'workflow' scopes",
+			"url":          "https://github.com/settings/tokens/new",
+		})
+		return
+	}
+
 	// For PAT authentication, we don't need a URL - just instructions
 	c.JSON(http.StatusOK, gin.H{
 		"type":         "pat",
@@ -90,15 +144,19 @@ func validateToken(c *gin.Context) {
 	}
 
 	// Generate a new session ID
-	sessionID := fmt.Sprintf("session_%s", user.GetLogin())
+	sessionID, err := newSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
 
 	// Store the authenticated user
-	authenticatedUsers[sessionID] = User{
+	saveSession(sessionID, User{
 		Login:     user.GetLogin(),
 		Name:      user.GetName(),
 		AvatarURL: user.GetAvatarURL(),
 		Token:     req.Token,
-	}
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"sessionId": sessionID,
@@ -110,11 +168,6 @@ func validateToken(c *gin.Context) {
 	})
 }
 
-func handleAuthCallback(c *gin.Context) {
-	// This endpoint is not used for PAT authentication
-	c.JSON(http.StatusBadRequest, gin.H{"error": "PAT authentication doesn't use callbacks"})
-}
-
 func authenticateWithPAT(c *gin.Context) {
 	var req struct {
 		Token string `json:"token"`
@@ -168,9 +221,13 @@ func authenticateWithPAT(c *gin.Context) {
 		Token:     req.Token,
 	}
 
-	// Generate a simple session ID (in production, use proper session management)
-	sessionID := fmt.Sprintf("session_%s", userData.Login)
-	authenticatedUsers[sessionID] = user
+	// Generate a session ID
+	sessionID, err := newSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	saveSession(sessionID, user)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Authentication successful",
@@ -180,6 +237,7 @@ func authenticateWithPAT(c *gin.Context) {
 			"avatarUrl": user.AvatarURL,
 		},
 		"sessionId": sessionID,
+		"token":     tokenInfoFromHeader(req.Token, resp.Header, tokenExpiryWarningDays, time.Now()),
 	})
 }
 
@@ -191,7 +249,7 @@ func getAuthStatus(c *gin.Context) {
 		return
 	}
 
-	user, exists := authenticatedUsers[sessionID]
+	user, exists := loadSession(sessionID)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session"})
 		return
@@ -233,12 +291,20 @@ func getRepositories(c *gin.Context) {
 
 	query := c.Query("q")
 
+	// Serve the listing from the per-session cache and apply server-side filters
+	allRepos, fetchedAt, err := repoCache.get(c.GetHeader("X-Session-ID"), client, ctx, c.Query("refresh") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Failed to fetch repositories: %v", err)})
+		return
+	}
+	allRepos = filterRepositories(allRepos, c.Query("org"), c.Query("visibility"), c.Query("archived"), c.Query("topic"))
+
 	var repos []Repository
 
 	// If there's a search query, use the fallback method which is more reliable
 	if query != "" {
 		fmt.Printf("Searching for query: %s\n", query)
-		repos, totalCount := fallbackRepositorySearch(client, ctx, query, page, perPage)
+		repos, totalCount := fallbackRepositorySearch(allRepos, query, page, perPage)
 		fmt.Printf("Found %d repositories matching query, total count: %d\n", len(repos), totalCount)
 
 		totalPages := (totalCount + perPage - 1) / perPage
@@ -255,13 +321,11 @@ func getRepositories(c *gin.Context) {
 				"has_prev":    hasPrev,
 				"total_pages": totalPages,
 			},
+			"cached_at": fetchedAt.UTC().Format("2006-01-02T15:04:05Z"),
 		})
 		return
 	}
 
-	// For regular browsing (no search query), fetch more repositories to ensure we get all accessible ones
-	allRepos := fetchAllRepositories(client, ctx)
-
 	// Apply pagination
 	start := (page - 1) * perPage
 	end := start + perPage
@@ -292,11 +356,15 @@ func getRepositories(c *gin.Context) {
 			"has_prev":    hasPrev,
 			"total_pages": totalPages,
 		},
+		"cached_at": fetchedAt.UTC().Format("2006-01-02T15:04:05Z"),
 	})
 }
 
-// Helper function to fetch all repositories a user has access to
-func fetchAllRepositories(client *github.Client, ctx context.Context) []Repository {
+// Helper function to fetch all repositories a user has access to.
+// Pages already present in the map are revalidated with their ETag and the map is
+// updated in place with the pages that changed. A page that fails to load fails
+// the whole listing, so a partial list is never cached as if it were complete.
+func fetchAllRepositories(client *github.Client, ctx context.Context, pages map[string]*cachedRepoPage) ([]Repository, error) {
 	var allRepos []Repository
 
 	// Fetch repositories with different types to ensure we get all accessible ones
@@ -308,40 +376,19 @@ func fetchAllRepositories(client *github.Client, ctx context.Context) []Reposito
 	for _, repoType := range types {
 		page := 1
 		for {
-			opt := &github.RepositoryListOptions{
-				ListOptions: github.ListOptions{
-					Page:    page,
-					PerPage: 100, // Maximum per page
-				},
-				Type: repoType,
-			}
-
-			repos, resp, err := client.Repositories.List(ctx, "", opt)
+			fetched, err := fetchRepositoryPage(client, ctx, repoType, page, pages)
 			if err != nil {
 				fmt.Printf("Failed to fetch %s repositories: %v\n", repoType, err)
-				break
+				return nil, fmt.Errorf("failed to fetch %s repositories (page %d): %v", repoType, page, err)
 			}
 
-			// Convert repositories
-			for _, repo := range repos {
-				allRepos = append(allRepos, Repository{
-					ID:          repo.GetID(),
-					Name:        repo.GetName(),
-					FullName:    repo.GetFullName(),
-					Description: repo.GetDescription(),
-					Private:     repo.GetPrivate(),
-					Owner: Owner{
-						Login: repo.Owner.GetLogin(),
-						ID:    repo.Owner.GetID(),
-					},
-				})
-			}
+			allRepos = append(allRepos, fetched.repos...)
 
 			// Check if there are more pages
-			if resp.NextPage == 0 {
+			if fetched.nextPage == 0 {
 				break
 			}
-			page = resp.NextPage
+			page = fetched.nextPage
 		}
 	}
 
@@ -356,16 +403,13 @@ func fetchAllRepositories(client *github.Client, ctx context.Context) []Reposito
 	}
 
 	fmt.Printf("Total repositories fetched: %d, after deduplication: %d\n", len(allRepos), len(uniqueRepos))
-	return uniqueRepos
+	return uniqueRepos, nil
 }
 
 // Fallback search function when GitHub Search API fails
-func fallbackRepositorySearch(client *github.Client, ctx context.Context, query string, page, perPage int) ([]Repository, int) {
+func fallbackRepositorySearch(allRepos []Repository, query string, page, perPage int) ([]Repository, int) {
 	var repos []Repository
 
-	// Fetch all repositories and filter them
-	allRepos := fetchAllRepositories(client, ctx)
-
 	// Filter repositories based on search query
 	var filteredRepos []Repository
 	queryLower := strings.ToLower(query)
@@ -455,8 +499,10 @@ func createEnvironment(c *gin.Context) {
 	repo := c.Param("repo")
 
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name        string               `json:"name"`
+		Description string               `json:"description"`
+		Settings    *EnvironmentSettings `json:"settings"` // protection rules
+		Template    string               `json:"template"` // stamp this environment of a template
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -480,6 +526,56 @@ func createEnvironment(c *gin.Context) {
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
 
+	// A template environment brings its settings and required keys
+	if req.Template != "" {
+		template := loadEnvironmentTemplate(req.Template)
+		if template == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found: " + req.Template})
+			return
+		}
+		env := template.environment(req.Name)
+		if env == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Template %s has no environment %s", req.Template, req.Name)})
+			return
+		}
+		stamped := *env
+		if req.Settings != nil {
+			stamped.Settings = req.Settings
+		}
+
+		keys, settingsAction, warnings, err := applyTemplateEnvironment(client, ctx, owner, repo, stamped, nil, nil, false, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create environment: %v", err)})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"name":       req.Name,
+			"template":   req.Template,
+			"settings":   settingsAction,
+			"keys":       keys,
+			"warnings":   warnings,
+			"created_at": time.Now().Format("2006-01-02T15:04:05Z"),
+		})
+		return
+	}
+
+	// Protection settings need the environments API
+	if req.Settings != nil {
+		warnings, failed, err := applyEnvironmentSettings(client, ctx, owner, repo, req.Name, req.Settings, false)
+		warnings = append(warnings, failed...)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create environment: %v", err)})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"name":        req.Name,
+			"description": req.Description,
+			"warnings":    warnings,
+			"created_at":  time.Now().Format("2006-01-02T15:04:05Z"),
+		})
+		return
+	}
+
 	// GitHub environments are created automatically when first referenced
 	// We'll create a simple environment by creating a deployment
 	deploymentReq := &github.DeploymentRequest{
@@ -803,8 +899,9 @@ func createEnvironmentVariable(c *gin.Context) {
 	env := c.Param("env")
 
 	var req struct {
-		Name  string `json:"name"`
-		Value string `json:"value"`
+		Name        string `json:"name"`
+		Value       string `json:"value"`
+		Interpolate bool   `json:"interpolate"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -812,13 +909,31 @@ func createEnvironmentVariable(c *gin.Context) {
 		return
 	}
 
+	// Reject keys the policy doesn't allow in this environment
+	if rejectPolicyViolations(c, activePolicy.checkKey(env, "variable", req.Name)) {
+		return
+	}
+
+	// Resolve references when the value is a template
+	loc := KeyLocation{Scope: "environment", Type: "variable", Repo: owner + "/" + repo, Environment: env, Name: req.Name}
+	value, saveTemplate, err := interpolateOnWrite(github.NewClient(nil).WithAuthToken(user.Token), context.Background(), loc, req.Value, req.Interpolate, user.Login)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to resolve template: %v", err)})
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, loc, value, false) {
+		return
+	}
+
 	// Create environment variable using direct HTTP call
 	client := &http.Client{}
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/environments/%s/variables", owner, repo, env)
 
 	payload := map[string]interface{}{
 		"name":  req.Name,
-		"value": req.Value,
+		"value": value,
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -850,6 +965,12 @@ func createEnvironmentVariable(c *gin.Context) {
 		return
 	}
 
+	// The stored template only changes once GitHub has the value
+	if err := saveTemplate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Environment variable created, but its template couldn't be saved: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Environment variable created successfully"})
 }
 
@@ -867,7 +988,8 @@ func updateEnvironmentVariable(c *gin.Context) {
 	name := c.Param("name")
 
 	var req struct {
-		Value string `json:"value"`
+		Value       string `json:"value"`
+		Interpolate bool   `json:"interpolate"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -875,10 +997,28 @@ func updateEnvironmentVariable(c *gin.Context) {
 		return
 	}
 
+	// Reject keys the policy doesn't allow in this environment
+	if rejectPolicyViolations(c, activePolicy.checkKey(env, "variable", name)) {
+		return
+	}
+
 	// Create GitHub client
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
 
+	// Resolve references when the value is a template
+	loc := KeyLocation{Scope: "environment", Type: "variable", Repo: owner + "/" + repo, Environment: env, Name: name}
+	value, saveTemplate, err := interpolateOnWrite(client, ctx, loc, req.Value, req.Interpolate, user.Login)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to resolve template: %v", err)})
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, loc, value, false) {
+		return
+	}
+
 	// Delete the existing environment variable
 	_, err = client.Actions.DeleteEnvVariable(ctx, owner, repo, env, name)
 	if err != nil {
@@ -889,7 +1029,7 @@ func updateEnvironmentVariable(c *gin.Context) {
 	// Create the new environment variable
 	variable := &github.ActionsVariable{
 		Name:  name,
-		Value: req.Value,
+		Value: value,
 	}
 
 	_, err = client.Actions.CreateEnvVariable(ctx, owner, repo, env, variable)
@@ -899,6 +1039,12 @@ func updateEnvironmentVariable(c *gin.Context) {
 		return
 	}
 
+	// The stored template only changes once GitHub has the value
+	if err := saveTemplate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Environment variable updated, but its template couldn't be saved: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Environment variable updated successfully"})
 }
 
@@ -915,6 +1061,16 @@ func deleteEnvironmentVariable(c *gin.Context) {
 	env := c.Param("env")
 	name := c.Param("name")
 
+	// Required keys can't be deleted
+	if rejectPolicyViolations(c, activePolicy.checkDelete(env, "variable", name)) {
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, KeyLocation{Scope: "environment", Type: "variable", Repo: owner + "/" + repo, Environment: env, Name: name}, "", true) {
+		return
+	}
+
 	// Delete environment variable using direct HTTP call
 	client := &http.Client{}
 	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/environments/%s/variables/%s", owner, repo, env, name)
@@ -966,6 +1122,16 @@ func createEnvironmentSecret(c *gin.Context) {
 		return
 	}
 
+	// Reject keys the policy doesn't allow in this environment
+	if rejectPolicyViolations(c, activePolicy.checkKey(env, "secret", req.Name)) {
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, KeyLocation{Scope: "environment", Type: "secret", Repo: owner + "/" + repo, Environment: env, Name: req.Name}, req.Value, false) {
+		return
+	}
+
 	// Create GitHub client using go-github library
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
@@ -1034,6 +1200,16 @@ func updateEnvironmentSecret(c *gin.Context) {
 		return
 	}
 
+	// Reject keys the policy doesn't allow in this environment
+	if rejectPolicyViolations(c, activePolicy.checkKey(env, "secret", name)) {
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, KeyLocation{Scope: "environment", Type: "secret", Repo: owner + "/" + repo, Environment: env, Name: name}, req.Value, false) {
+		return
+	}
+
 	// Create GitHub client using go-github library
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
@@ -1093,6 +1269,16 @@ func deleteEnvironmentSecret(c *gin.Context) {
 	env := c.Param("env")
 	name := c.Param("name")
 
+	// Required keys can't be deleted
+	if rejectPolicyViolations(c, activePolicy.checkDelete(env, "secret", name)) {
+		return
+	}
+
+	// Protected environments only change through an approved change request
+	if stageProtectedChange(c, user, KeyLocation{Scope: "environment", Type: "secret", Repo: owner + "/" + repo, Environment: env, Name: name}, "", true) {
+		return
+	}
+
 	// Create GitHub client using go-github library
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
@@ -1129,8 +1315,9 @@ func createVariable(c *gin.Context) {
 	repo := c.Param("repo")
 
 	var req struct {
-		Name  string `json:"name"`
-		Value string `json:"value"`
+		Name        string `json:"name"`
+		Value       string `json:"value"`
+		Interpolate bool   `json:"interpolate"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1142,10 +1329,18 @@ func createVariable(c *gin.Context) {
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
 
+	// Resolve references when the value is a template
+	loc := KeyLocation{Scope: "repository", Repo: owner + "/" + repo, Name: req.Name}
+	value, saveTemplate, err := interpolateOnWrite(client, ctx, loc, req.Value, req.Interpolate, user.Login)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to resolve template: %v", err)})
+		return
+	}
+
 	// Create Actions variable
 	variable := &github.ActionsVariable{
 		Name:  req.Name,
-		Value: req.Value,
+		Value: value,
 	}
 
 	_, err = client.Actions.CreateRepoVariable(ctx, owner, repo, variable)
@@ -1154,6 +1349,12 @@ func createVariable(c *gin.Context) {
 		return
 	}
 
+	// The stored template only changes once GitHub has the value
+	if err := saveTemplate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Variable created, but its template couldn't be saved: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Variable created successfully"})
 }
 
@@ -1170,7 +1371,8 @@ func updateVariable(c *gin.Context) {
 	name := c.Param("name")
 
 	var req struct {
-		Value string `json:"value"`
+		Value       string `json:"value"`
+		Interpolate bool   `json:"interpolate"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1182,10 +1384,18 @@ func updateVariable(c *gin.Context) {
 	ctx := context.Background()
 	client := github.NewClient(nil).WithAuthToken(user.Token)
 
+	// Resolve references when the value is a template
+	loc := KeyLocation{Scope: "repository", Repo: owner + "/" + repo, Name: name}
+	value, saveTemplate, err := interpolateOnWrite(client, ctx, loc, req.Value, req.Interpolate, user.Login)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Failed to resolve template: %v", err)})
+		return
+	}
+
 	// Update Actions variable
 	variable := &github.ActionsVariable{
 		Name:  name,
-		Value: req.Value,
+		Value: value,
 	}
 
 	_, err = client.Actions.UpdateRepoVariable(ctx, owner, repo, variable)
@@ -1194,6 +1404,12 @@ func updateVariable(c *gin.Context) {
 		return
 	}
 
+	// The stored template only changes once GitHub has the value
+	if err := saveTemplate(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Variable updated, but its template couldn't be saved: %v", err)})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Variable updated successfully"})
 }
 
@@ -1384,12 +1600,14 @@ func syncVariables(c *gin.Context) {
 	}
 
 	var req struct {
-		SourceRepo    string   `json:"source_repo"`
-		SourceEnv     string   `json:"source_env"`
-		TargetRepos   []string `json:"target_repos"`
-		TargetEnvs    []string `json:"target_envs"`
-		VariableNames []string `json:"variable_names"`
-		Overwrite     bool     `json:"overwrite"`
+		SourceRepo    string        `json:"source_repo"`
+		SourceEnv     string        `json:"source_env"`
+		TargetRepos   []string      `json:"target_repos"`
+		TargetEnvs    []string      `json:"target_envs"`
+		VariableNames []string      `json:"variable_names"`
+		SecretNames   []string      `json:"secret_names"`
+		SecretSource  *SecretSource `json:"secret_source"`
+		Overwrite     bool          `json:"overwrite"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -1397,94 +1615,155 @@ func syncVariables(c *gin.Context) {
 		return
 	}
 
-	// Parse source repo (format: "owner/repo")
-	parts := strings.Split(req.SourceRepo, "/")
-	if len(parts) != 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source repo format. Use 'owner/repo'"})
-		return
+	// Resolve secret values up front so a bad source fails before any writes
+	var secretValues map[string]string
+	if len(req.SecretNames) > 0 {
+		if req.SecretSource == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "secret_source is required to sync secrets"})
+			return
+		}
+		secretValues, err = resolveSecretValues(req.SecretSource, req.SecretNames)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to load secret values: %v", err)})
+			return
+		}
 	}
-	sourceOwner, sourceRepo := parts[0], parts[1]
 
-	// Get source variables - Use Actions variables endpoint
+	// Variables come from the source repository; it may be omitted when only secrets are synced
 	client := &http.Client{}
-	sourceURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/variables", sourceOwner, sourceRepo)
+	var sourceVariables []Variable
+	if req.SourceRepo != "" || len(req.SecretNames) == 0 {
+		// Parse source repo (format: "owner/repo")
+		parts := strings.Split(req.SourceRepo, "/")
+		if len(parts) != 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source repo format. Use 'owner/repo'"})
+			return
+		}
+		sourceOwner, sourceRepo := parts[0], parts[1]
 
-	sourceReq, err := http.NewRequest("GET", sourceURL, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-		return
-	}
+		// Get source variables - Use Actions variables endpoint
+		sourceURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/variables", sourceOwner, sourceRepo)
+
+		sourceReq, err := http.NewRequest("GET", sourceURL, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+			return
+		}
 
-	sourceReq.Header.Set("Authorization", "token "+user.Token)
-	sourceReq.Header.Set("Accept", "application/vnd.github.v3+json")
+		sourceReq.Header.Set("Authorization", "token "+user.Token)
+		sourceReq.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	sourceResp, err := client.Do(sourceReq)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source variables"})
-		return
+		sourceResp, err := client.Do(sourceReq)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source variables"})
+			return
+		}
+		defer sourceResp.Body.Close()
+
+		if sourceResp.StatusCode != http.StatusOK {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source variables from GitHub"})
+			return
+		}
+
+		var sourceVariablesResponse struct {
+			Variables []Variable `json:"variables"`
+		}
+		if err := json.NewDecoder(sourceResp.Body).Decode(&sourceVariablesResponse); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse source variables"})
+			return
+		}
+		sourceVariables = sourceVariablesResponse.Variables
 	}
-	defer sourceResp.Body.Close()
 
-	if sourceResp.StatusCode != http.StatusOK {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch source variables from GitHub"})
+	// Check the whole sync against the policy before writing anything
+	violations := []PolicyViolation{}
+	for _, targetEnv := range req.TargetEnvs {
+		for _, variable := range sourceVariables {
+			if len(req.VariableNames) == 0 || contains(req.VariableNames, variable.Name) {
+				violations = append(violations, activePolicy.checkKey(targetEnv, "variable", variable.Name)...)
+			}
+		}
+		for _, name := range req.SecretNames {
+			violations = append(violations, activePolicy.checkKey(targetEnv, "secret", name)...)
+		}
+	}
+	if rejectPolicyViolations(c, violations) {
 		return
 	}
 
-	var sourceVariablesResponse struct {
-		Variables []Variable `json:"variables"`
+	// Keys go to each target environment, or to the repository itself when no
+	// environments are given; RBAC and the policy check the same targets
+	syncTargets := func(targetRepo string) []KeyLocation {
+		if len(req.TargetEnvs) == 0 {
+			return []KeyLocation{{Scope: "repository", Repo: targetRepo}}
+		}
+		targets := []KeyLocation{}
+		for _, targetEnv := range req.TargetEnvs {
+			targets = append(targets, KeyLocation{Scope: "environment", Repo: targetRepo, Environment: targetEnv})
+		}
+		return targets
+	}
+
+	// Fail fast on targets the token can't write
+	targetLocations := []KeyLocation{}
+	for _, targetRepo := range req.TargetRepos {
+		for _, target := range syncTargets(targetRepo) {
+			if len(sourceVariables) > 0 {
+				target.Type = "variable"
+				targetLocations = append(targetLocations, target)
+			}
+			if len(secretValues) > 0 {
+				target.Type = "secret"
+				targetLocations = append(targetLocations, target)
+			}
+		}
 	}
-	if err := json.NewDecoder(sourceResp.Body).Decode(&sourceVariablesResponse); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse source variables"})
+	ghClient := github.NewClient(nil).WithAuthToken(user.Token)
+	ctx := context.Background()
+	if rejectForbiddenTargets(c, preflightLocations(ghClient, ctx, user.Token, targetLocations)) {
 		return
 	}
-	sourceVariables := sourceVariablesResponse.Variables
 
 	syncedCount := 0
 	errors := []string{}
 
+	// Templated source variables are resolved against each target
+	resolver := newValueResolver(ghClient, ctx)
+
 	// Sync to each target
 	for _, targetRepo := range req.TargetRepos {
-		targetParts := strings.Split(targetRepo, "/")
-		if len(targetParts) != 2 {
+		if _, _, err := parseRepo(targetRepo); err != nil {
 			errors = append(errors, fmt.Sprintf("Invalid target repo format: %s", targetRepo))
 			continue
 		}
-		targetOwner, targetRepoName := targetParts[0], targetParts[1]
 
-		for _, targetEnv := range req.TargetEnvs {
+		for _, target := range syncTargets(targetRepo) {
 			for _, variable := range sourceVariables {
 				// Check if variable should be synced
-				if len(req.VariableNames) == 0 || contains(req.VariableNames, variable.Name) {
-					// Create/update variable in target - Use Actions variables endpoint
-					targetURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/actions/variables", targetOwner, targetRepoName)
-
-					payload := map[string]string{
-						"name":  variable.Name,
-						"value": variable.Value,
-					}
-
-					jsonPayload, _ := json.Marshal(payload)
-					targetReq, err := http.NewRequest("PUT", targetURL, strings.NewReader(string(jsonPayload)))
-					if err != nil {
-						errors = append(errors, fmt.Sprintf("Failed to create request for %s/%s/%s", targetRepo, targetEnv, variable.Name))
-						continue
-					}
-
-					targetReq.Header.Set("Authorization", "token "+user.Token)
-					targetReq.Header.Set("Accept", "application/vnd.github.v3+json")
-					targetReq.Header.Set("Content-Type", "application/json")
-
-					targetResp, err := client.Do(targetReq)
-					if err != nil {
-						errors = append(errors, fmt.Sprintf("Failed to sync %s to %s/%s/%s", variable.Name, targetRepo, targetEnv, variable.Name))
+				if len(req.VariableNames) > 0 && !contains(req.VariableNames, variable.Name) {
+					continue
+				}
+				value := variable.Value
+				targetLoc := target
+				targetLoc.Type, targetLoc.Name = "variable", variable.Name
+				template := findValueTemplate(resolver.templates, KeyLocation{Scope: "repository", Type: "variable", Repo: req.SourceRepo, Name: variable.Name})
+				if template != nil {
+					resolver.withTemplate(targetLoc, template.Template)
+					if value, err = resolver.resolve(targetLoc); err != nil {
+						errors = append(errors, fmt.Sprintf("Failed to resolve %s for %s: %v", variable.Name, targetLoc, err))
 						continue
 					}
-					targetResp.Body.Close()
+				}
 
-					if targetResp.StatusCode == http.StatusCreated || targetResp.StatusCode == http.StatusNoContent {
-						syncedCount++
-					} else {
-						errors = append(errors, fmt.Sprintf("Failed to sync %s to %s/%s (status: %d)", variable.Name, targetRepo, targetEnv, targetResp.StatusCode))
+				if err := writeKey(ghClient, ctx, targetLoc, value); err != nil {
+					errors = append(errors, fmt.Sprintf("Failed to sync %s: %v", targetLoc, err))
+					continue
+				}
+				syncedCount++
+				if template != nil {
+					// The target follows the template from now on
+					if err := saveValueTemplate(ValueTemplate{Location: targetLoc, Template: template.Template, UpdatedBy: user.Login}); err != nil {
+						errors = append(errors, fmt.Sprintf("Failed to save template of %s: %v", targetLoc, err))
 					}
 				}
 			}
@@ -1496,6 +1775,14 @@ func syncVariables(c *gin.Context) {
 		"synced_count": syncedCount,
 	}
 
+	if len(secretValues) > 0 {
+		secretsSynced, secretsSkipped, secretErrors := syncSecretValues(ghClient, ctx, req.TargetRepos, req.TargetEnvs, secretValues, req.Overwrite)
+		errors = append(errors, secretErrors...)
+		response["secrets_synced"] = secretsSynced
+		response["secrets_skipped"] = secretsSkipped
+		response["message"] = fmt.Sprintf("Successfully synced %d variables and %d secrets", syncedCount, secretsSynced)
+	}
+
 	if len(errors) > 0 {
 		response["errors"] = errors
 	}
@@ -1779,10 +2066,6 @@ func compareEnvironments(c *gin.Context) {
 	c.JSON(http.StatusOK, comparison)
 }
 
-func handleWebSocket(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "WebSocket endpoint"})
-}
-
 // Helper functions
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -1793,16 +2076,28 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// newSessionID returns a random session ID. Sessions carry the user's token and
+// RBAC, change request reviews and the app audit trail trust them, so they must
+// not be guessable.
+func newSessionID() (string, error) {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return "session_" + hex.EncodeToString(id), nil
+}
+
 func getAuthenticatedUser(c *gin.Context) (*User, error) {
 	sessionID := c.GetHeader("X-Session-ID")
 	if sessionID == "" {
 		return nil, fmt.Errorf("no session")
 	}
 
-	user, exists := authenticatedUsers[sessionID]
+	user, exists := loadSession(sessionID)
 	if !exists {
 		return nil, fmt.Errorf("invalid session")
 	}
+	refreshIfExpiring(sessionID, &user)
 
 	return &user, nil
 }
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// SOPS-compatible documents. Values are encrypted with AES-256-GCM under a random
+// data key, using the key path as additional data; the data key is encrypted to
+// age recipients and stored in the "sops" metadata together with a MAC over all
+// plaintext values. Only age key groups are supported. Files written here can be
+// opened with `sops -d` and files written by sops can be imported.
+
+const (
+	sopsVersion           = "3.8.1"
+	sopsUnencryptedSuffix = "_unencrypted"
+	sopsNonceSize         = 32
+)
+
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]*),tag:([^,]*),type:([a-z]+)\]$`)
+
+// sopsAgeKey is one age recipient of the data key
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsMetadata is the part of the "sops" section we read and write
+type sopsMetadata struct {
+	Age               []sopsAgeKey `yaml:"age"`
+	LastModified      string       `yaml:"lastmodified"`
+	MAC               string       `yaml:"mac"`
+	UnencryptedSuffix string       `yaml:"unencrypted_suffix"`
+	Version           string       `yaml:"version"`
+}
+
+// SopsEntry is one decrypted key. Section is "variables" or "secrets" when the
+// document groups keys that way, and empty for flat documents.
+type SopsEntry struct {
+	Section string `json:"section,omitempty"`
+	Name    string `json:"name"`
+	Value   string `json:"-"`
+}
+
+func sopsEncryptValue(value string, key []byte, additionalData, valueType string) (string, error) {
+	// sops leaves empty values as they are
+	if value == "" {
+		return "", nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	tagStart := len(sealed) - aes.BlockSize
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(sealed[:tagStart]),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(sealed[tagStart:]),
+		valueType), nil
+}
+
+// sopsDecryptValue decrypts an ENC[...] value and returns the plaintext and its sops type
+func sopsDecryptValue(value string, key []byte, additionalData string) (string, string, error) {
+	match := sopsValuePattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", "", fmt.Errorf("malformed encrypted value")
+	}
+
+	data, err1 := base64.StdEncoding.DecodeString(match[1])
+	iv, err2 := base64.StdEncoding.DecodeString(match[2])
+	tag, err3 := base64.StdEncoding.DecodeString(match[3])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", "", fmt.Errorf("malformed encrypted value")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(plaintext), match[4], nil
+}
+
+func sopsAdditionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// sopsServerKey lets imports without an identity decrypt with the server's own
+// age key. Off by default: with it on, any signed-in user can have the server
+// decrypt anything encrypted to that key.
+var sopsServerKey = false
+
+// sopsAllowIgnoreMAC lets imports set ignore_mac and skip the integrity check of
+// a document. Off by default, since a document whose MAC doesn't match may have
+// been tampered with.
+var sopsAllowIgnoreMAC = false
+
+// sopsIdentities returns the age identity text to decrypt with. With
+// --sops-server-key it falls back to SOPS_AGE_KEY, SOPS_AGE_KEY_FILE and the
+// default sops key file, like sops does.
+func sopsIdentities(identity string) string {
+	if identity != "" || !sopsServerKey {
+		return identity
+	}
+	if key := os.Getenv("SOPS_AGE_KEY"); key != "" {
+		return key
+	}
+	file := os.Getenv("SOPS_AGE_KEY_FILE")
+	if file == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			file = filepath.Join(dir, "sops", "age", "keys.txt")
+		}
+	}
+	if content, err := os.ReadFile(file); err == nil {
+		return string(content)
+	}
+	return ""
+}
+
+// newSopsDataKey creates a data key and encrypts it to every recipient
+func newSopsDataKey(recipients []string) ([]byte, []sopsAgeKey, error) {
+	if len(recipients) == 0 {
+		return nil, nil, fmt.Errorf("at least one age recipient is required")
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	keys := []sopsAgeKey{}
+	for _, recipient := range recipients {
+		recipient = strings.TrimSpace(recipient)
+		parsed, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid age recipient %q: %v", recipient, err)
+		}
+
+		var out bytes.Buffer
+		armored := armor.NewWriter(&out)
+		w, err := age.Encrypt(armored, parsed)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := w.Write(dataKey); err != nil {
+			return nil, nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, nil, err
+		}
+		if err := armored.Close(); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, sopsAgeKey{Recipient: recipient, Enc: out.String()})
+	}
+	return dataKey, keys, nil
+}
+
+// openSopsDataKey decrypts the data key with any of the identities
+func openSopsDataKey(keys []sopsAgeKey, identity string) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("document has no age recipients; only age key groups are supported")
+	}
+	identityText := sopsIdentities(identity)
+	if identityText == "" {
+		return nil, fmt.Errorf("an age identity is required")
+	}
+	identities, err := age.ParseIdentities(strings.NewReader(identityText))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity: %v", err)
+	}
+
+	for _, key := range keys {
+		reader := armor.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(key.Enc))))
+		r, err := age.Decrypt(reader, identities...)
+		if err != nil {
+			continue
+		}
+		dataKey, err := io.ReadAll(r)
+		if err == nil && len(dataKey) == 32 {
+			return dataKey, nil
+		}
+	}
+
+	recipients := []string{}
+	for _, key := range keys {
+		recipients = append(recipients, key.Recipient)
+	}
+	return nil, fmt.Errorf("none of the identities can decrypt the data key (recipients: %s)", strings.Join(recipients, ", "))
+}
+
+// sopsMAC hashes the plaintext values in document order
+type sopsMAC struct {
+	values [][]byte
+}
+
+func (m *sopsMAC) add(value string) {
+	m.values = append(m.values, []byte(value))
+}
+
+func (m *sopsMAC) sum() string {
+	hash := sha512.New()
+	for _, value := range m.values {
+		hash.Write(value)
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil))
+}
+
+func (m *sopsMAC) encrypt(dataKey []byte, lastModified string) (string, error) {
+	return sopsEncryptValue(m.sum(), dataKey, lastModified, "str")
+}
+
+func (m *sopsMAC) verify(encrypted string, dataKey []byte, lastModified string) error {
+	stored, _, err := sopsDecryptValue(encrypted, dataKey, lastModified)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt MAC: %v", err)
+	}
+	if stored != m.sum() {
+		return fmt.Errorf("MAC mismatch, the document was modified without sops")
+	}
+	return nil
+}
+
+// walkSopsNode calls fn for every scalar of a YAML tree with its key path.
+// Sequence items share the path of their parent, as in sops.
+func walkSopsNode(node *yaml.Node, path []string, fn func(node *yaml.Node, path []string) error) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := append(append([]string{}, path...), node.Content[i].Value)
+			if err := walkSopsNode(node.Content[i+1], childPath, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := walkSopsNode(item, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return fn(node, path)
+	}
+	return nil
+}
+
+// sopsScalarBytes is the MAC input of an unencrypted scalar, formatted like sops
+func sopsScalarBytes(node *yaml.Node) string {
+	if node.Tag == "!!bool" {
+		if value, err := strconv.ParseBool(node.Value); err == nil {
+			if value {
+				return "True"
+			}
+			return "False"
+		}
+	}
+	return node.Value
+}
+
+func sopsScalarType(node *yaml.Node) string {
+	switch node.Tag {
+	case "!!int":
+		return "int"
+	case "!!float":
+		return "float"
+	case "!!bool":
+		return "bool"
+	default:
+		return "str"
+	}
+}
+
+// decryptSopsYAML decrypts a SOPS YAML document and returns its keys
+func decryptSopsYAML(content []byte, identity string, ignoreMAC bool) ([]SopsEntry, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("document must be a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	var metadata *sopsMetadata
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			metadata = &sopsMetadata{}
+			if err := root.Content[i+1].Decode(metadata); err != nil {
+				return nil, nil, fmt.Errorf("invalid sops metadata: %v", err)
+			}
+			root.Content = append(root.Content[:i], root.Content[i+2:]...)
+			break
+		}
+	}
+	if metadata == nil {
+		return nil, nil, fmt.Errorf("not a SOPS document: no sops metadata")
+	}
+
+	dataKey, err := openSopsDataKey(metadata.Age, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac := &sopsMAC{}
+	err = walkSopsNode(root, nil, func(node *yaml.Node, path []string) error {
+		if !sopsValuePattern.MatchString(node.Value) {
+			mac.add(sopsScalarBytes(node))
+			return nil
+		}
+		plaintext, valueType, err := sopsDecryptValue(node.Value, dataKey, sopsAdditionalData(path))
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.Join(path, "."), err)
+		}
+		if valueType != "comment" {
+			mac.add(plaintext)
+		}
+		node.Value = plaintext
+		node.Tag = "!!str"
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ignoreMAC {
+		if err := mac.verify(metadata.MAC, dataKey, metadata.LastModified); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return sopsYAMLEntries(root, metadata.UnencryptedSuffix)
+}
+
+// sopsYAMLEntries reads keys from "variables" and "secrets" mappings, or from the
+// top level of a flat document. Nested values that aren't scalars are reported
+// as warnings and skipped.
+func sopsYAMLEntries(root *yaml.Node, suffix string) ([]SopsEntry, []string, error) {
+	entries := []SopsEntry{}
+	warnings := []string{}
+
+	add := func(section string, mapping *yaml.Node) {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			name := strings.TrimSuffix(mapping.Content[i].Value, suffix)
+			value := mapping.Content[i+1]
+			if value.Kind != yaml.ScalarNode {
+				warnings = append(warnings, fmt.Sprintf("%s is not a scalar value and was skipped", name))
+				continue
+			}
+			entries = append(entries, SopsEntry{Section: section, Name: name, Value: value.Value})
+		}
+	}
+
+	grouped := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		if (key == "variables" || key == "secrets") && root.Content[i+1].Kind == yaml.MappingNode {
+			grouped = true
+		}
+	}
+
+	if !grouped {
+		add("", root)
+		return entries, warnings, nil
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		if (key == "variables" || key == "secrets") && root.Content[i+1].Kind == yaml.MappingNode {
+			add(key, root.Content[i+1])
+		} else {
+			warnings = append(warnings, fmt.Sprintf("top-level key %s is not variables or secrets and was skipped", key))
+		}
+	}
+	return entries, warnings, nil
+}
+
+func yamlString(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// encryptSopsYAML writes variables and secrets as a SOPS YAML document
+func encryptSopsYAML(variables, secrets map[string]string, recipients []string) ([]byte, error) {
+	dataKey, ageKeys, err := newSopsDataKey(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, section := range []struct {
+		name   string
+		values map[string]string
+	}{{"variables", variables}, {"secrets", secrets}} {
+		if len(section.values) == 0 {
+			continue
+		}
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		for _, name := range sortedKeys(section.values) {
+			mapping.Content = append(mapping.Content, yamlString(name), yamlString(section.values[name]))
+		}
+		root.Content = append(root.Content, yamlString(section.name), mapping)
+	}
+
+	mac := &sopsMAC{}
+	err = walkSopsNode(root, nil, func(node *yaml.Node, path []string) error {
+		mac.add(node.Value)
+		encrypted, err := sopsEncryptValue(node.Value, dataKey, sopsAdditionalData(path), sopsScalarType(node))
+		if err != nil {
+			return err
+		}
+		node.Value = encrypted
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encryptedMAC, err := mac.encrypt(dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	ageList := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, key := range ageKeys {
+		enc := yamlString(key.Enc)
+		enc.Style = yaml.LiteralStyle
+		ageList.Content = append(ageList.Content, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			yamlString("recipient"), yamlString(key.Recipient),
+			yamlString("enc"), enc,
+		}})
+	}
+	metadata := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		yamlString("age"), ageList,
+		yamlString("lastmodified"), yamlString(lastModified),
+		yamlString("mac"), yamlString(encryptedMAC),
+		yamlString("unencrypted_suffix"), yamlString(sopsUnencryptedSuffix),
+		yamlString("version"), yamlString(sopsVersion),
+	}}
+	root.Content = append(root.Content, yamlString("sops"), metadata)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(4)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return out.Bytes(), nil
+}
+
+// decryptSopsDotenv decrypts a SOPS dotenv document. Metadata is stored flattened
+// in sops_* keys (e.g. sops_age__list_0__map_enc) with newlines escaped.
+func decryptSopsDotenv(content []byte, identity string, ignoreMAC bool) ([]SopsEntry, error) {
+	type line struct{ key, value string }
+	var lines []line
+	metadata := &sopsMetadata{}
+	ageKeys := map[int]*sopsAgeKey{}
+
+	for _, raw := range strings.Split(string(content), "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(raw, "=")
+		if !found {
+			continue
+		}
+		value = strings.ReplaceAll(value, `\n`, "\n")
+
+		if !strings.HasPrefix(key, "sops_") {
+			lines = append(lines, line{key, value})
+			continue
+		}
+		field := strings.TrimPrefix(key, "sops_")
+		switch {
+		case strings.HasPrefix(field, "age__list_"):
+			indexText, name, _ := strings.Cut(strings.TrimPrefix(field, "age__list_"), "__map_")
+			index, err := strconv.Atoi(indexText)
+			if err != nil {
+				continue
+			}
+			if ageKeys[index] == nil {
+				ageKeys[index] = &sopsAgeKey{}
+			}
+			if name == "recipient" {
+				ageKeys[index].Recipient = value
+			} else if name == "enc" {
+				ageKeys[index].Enc = value
+			}
+		case field == "lastmodified":
+			metadata.LastModified = value
+		case field == "mac":
+			metadata.MAC = value
+		case field == "unencrypted_suffix":
+			metadata.UnencryptedSuffix = value
+		case field == "version":
+			metadata.Version = value
+		}
+	}
+	if metadata.MAC == "" && len(ageKeys) == 0 {
+		return nil, fmt.Errorf("not a SOPS document: no sops metadata")
+	}
+
+	indexes := []int{}
+	for index := range ageKeys {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		metadata.Age = append(metadata.Age, *ageKeys[index])
+	}
+
+	dataKey, err := openSopsDataKey(metadata.Age, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := &sopsMAC{}
+	entries := []SopsEntry{}
+	for _, l := range lines {
+		value := l.value
+		if sopsValuePattern.MatchString(value) {
+			plaintext, _, err := sopsDecryptValue(value, dataKey, sopsAdditionalData([]string{l.key}))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", l.key, err)
+			}
+			value = plaintext
+		}
+		mac.add(value)
+		entries = append(entries, SopsEntry{Name: strings.TrimSuffix(l.key, metadata.UnencryptedSuffix), Value: value})
+	}
+	if !ignoreMAC {
+		if err := mac.verify(metadata.MAC, dataKey, metadata.LastModified); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// encryptSopsDotenv writes values as a SOPS dotenv document
+func encryptSopsDotenv(values map[string]string, recipients []string) ([]byte, error) {
+	dataKey, ageKeys, err := newSopsDataKey(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	escape := func(value string) string { return strings.ReplaceAll(value, "\n", `\n`) }
+
+	var out strings.Builder
+	mac := &sopsMAC{}
+	for _, name := range sortedKeys(values) {
+		mac.add(values[name])
+		encrypted, err := sopsEncryptValue(values[name], dataKey, sopsAdditionalData([]string{name}), "str")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&out, "%s=%s\n", name, encrypted)
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	encryptedMAC, err := mac.encrypt(dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range ageKeys {
+		fmt.Fprintf(&out, "sops_age__list_%d__map_enc=%s\n", i, escape(key.Enc))
+		fmt.Fprintf(&out, "sops_age__list_%d__map_recipient=%s\n", i, key.Recipient)
+	}
+	fmt.Fprintf(&out, "sops_lastmodified=%s\n", lastModified)
+	fmt.Fprintf(&out, "sops_mac=%s\n", encryptedMAC)
+	fmt.Fprintf(&out, "sops_unencrypted_suffix=%s\n", sopsUnencryptedSuffix)
+	fmt.Fprintf(&out, "sops_version=%s\n", sopsVersion)
+	return []byte(out.String()), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testdata/sops holds files encrypted by sops 3.13 to the age key in key.txt,
+// whose recipient is sopsTestRecipient
+const sopsTestRecipient = "age1snlz2lll8vp5h6y24c20c0xt63ahxfwwdygemwwhmsyyarzml3qst6van9"
+
+func sopsTestIdentity(t *testing.T) string {
+	t.Helper()
+	key, err := os.ReadFile(filepath.Join("testdata", "sops", "key.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(key)
+}
+
+func sopsEntryMap(entries []SopsEntry) map[string]string {
+	values := map[string]string{}
+	for _, entry := range entries {
+		values[strings.TrimPrefix(entry.Section+"/", "/")+entry.Name] = entry.Value
+	}
+	return values
+}
+
+func TestSopsOpensFilesWrittenBySops(t *testing.T) {
+	identity := sopsTestIdentity(t)
+
+	content, _ := os.ReadFile(filepath.Join("testdata", "sops", "app.sops.yaml"))
+	entries, _, err := decryptSopsYAML(content, identity, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"variables/LOG_LEVEL":       "debug",
+		"variables/REPLICAS":        "3",
+		"secrets/DATABASE_PASSWORD": "s3cr3t with spaces",
+		"secrets/API_TOKEN":         "tok_123",
+	}
+	if got := sopsEntryMap(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("YAML: got %v, want %v", got, want)
+	}
+
+	content, _ = os.ReadFile(filepath.Join("testdata", "sops", "app.sops.env"))
+	entries, err = decryptSopsDotenv(content, identity, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"DATABASE_URL": "postgres://db/app", "API_TOKEN": "tok_456"}
+	if got := sopsEntryMap(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("dotenv: got %v, want %v", got, want)
+	}
+}
+
+// sopsDecrypt runs the real sops binary on content, skipping when it isn't installed
+func sopsDecrypt(t *testing.T, content []byte, format string) string {
+	t.Helper()
+	binary, err := exec.LookPath("sops")
+	if err != nil {
+		t.Skip("sops is not installed")
+	}
+	file := filepath.Join(t.TempDir(), "export."+format)
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(binary, "--decrypt", "--input-type", format, "--output-type", format, file)
+	cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+filepath.Join("testdata", "sops", "key.txt"))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sops -d failed: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+func TestSopsExportOpensWithSops(t *testing.T) {
+	identity := sopsTestIdentity(t)
+	variables := map[string]string{"LOG_LEVEL": "info"}
+	secrets := map[string]string{"DATABASE_PASSWORD": "p@ss: word"}
+
+	content, err := encryptSopsYAML(variables, secrets, []string{sopsTestRecipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := decryptSopsYAML(content, identity, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"variables/LOG_LEVEL": "info", "secrets/DATABASE_PASSWORD": "p@ss: word"}
+	if got := sopsEntryMap(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if out := sopsDecrypt(t, content, "yaml"); !strings.Contains(out, "DATABASE_PASSWORD: 'p@ss: word'") {
+		t.Fatalf("unexpected sops output:\n%s", out)
+	}
+
+	content, err = encryptSopsDotenv(map[string]string{"API_TOKEN": "tok_789"}, []string{sopsTestRecipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := sopsDecrypt(t, content, "dotenv"); strings.TrimSpace(out) != "API_TOKEN=tok_789" {
+		t.Fatalf("unexpected sops output:\n%s", out)
+	}
+}
+
+func TestSopsServerKeyNeedsOptIn(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY", sopsTestIdentity(t))
+	content, _ := os.ReadFile(filepath.Join("testdata", "sops", "app.sops.env"))
+
+	if _, err := decryptSopsDotenv(content, "", false); err == nil {
+		t.Fatalf("expected the server key to be refused without --sops-server-key")
+	}
+
+	sopsServerKey = true
+	t.Cleanup(func() { sopsServerKey = false })
+	if _, err := decryptSopsDotenv(content, "", false); err != nil {
+		t.Fatalf("expected the server key to be used with --sops-server-key: %v", err)
+	}
+}
+
+func TestSopsIgnoreMACNeedsOptIn(t *testing.T) {
+	sessionID := testSession(t, "octocat", "token")
+	importFile := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/sops/import", strings.NewReader(`{"content": "not sops", "target": "acme/svc", "identity": "x", "ignore_mac": true}`))
+		c.Request.Header.Set("X-Session-ID", sessionID)
+		importSopsFile(c)
+		return w
+	}
+
+	if w := importFile(); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "--sops-allow-ignore-mac") {
+		t.Fatalf("expected ignore_mac to be refused without --sops-allow-ignore-mac, got %d %s", w.Code, w.Body)
+	}
+
+	sopsAllowIgnoreMAC = true
+	t.Cleanup(func() { sopsAllowIgnoreMAC = false })
+	if w := importFile(); strings.Contains(w.Body.String(), "--sops-allow-ignore-mac") {
+		t.Fatalf("expected ignore_mac to be accepted with --sops-allow-ignore-mac, got %d %s", w.Code, w.Body)
+	}
+}
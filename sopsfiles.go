@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Import and export of SOPS files, so an environment can be round-tripped to an
+// encrypted file committed next to the code. YAML documents keep keys under
+// "variables" and "secrets"; dotenv documents are flat, so the key types come
+// from the request on import.
+
+// isSopsDotenv reports whether content looks like a SOPS dotenv document
+func isSopsDotenv(content string) bool {
+	return strings.HasPrefix(content, "sops_") || strings.Contains(content, "\nsops_mac=")
+}
+
+func importSopsFile(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Content      string   `json:"content"`
+		Format       string   `json:"format"` // "yaml" or "dotenv", detected when empty
+		Identity     string   `json:"identity"`
+		Target       string   `json:"target"` // "owner/repo" or "owner/repo:env"
+		DefaultType  string   `json:"default_type"`
+		VariableKeys []string `json:"variable_keys"`
+		Overwrite    bool     `json:"overwrite"`
+		DryRun       bool     `json:"dry_run"`
+		IgnoreMAC    bool     `json:"ignore_mac"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, err := targetLocation(req.Target, "variable", ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DefaultType == "" {
+		req.DefaultType = "secret"
+	}
+	if req.DefaultType != "variable" && req.DefaultType != "secret" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "default_type must be 'variable' or 'secret'"})
+		return
+	}
+	if req.IgnoreMAC && !sopsAllowIgnoreMAC {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ignore_mac is only allowed when the server runs with --sops-allow-ignore-mac"})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "yaml"
+		if isSopsDotenv(req.Content) {
+			req.Format = "dotenv"
+		}
+	}
+
+	var entries []SopsEntry
+	warnings := []string{}
+	switch req.Format {
+	case "yaml":
+		entries, warnings, err = decryptSopsYAML([]byte(req.Content), req.Identity, req.IgnoreMAC)
+	case "dotenv":
+		entries, err = decryptSopsDotenv([]byte(req.Content), req.Identity, req.IgnoreMAC)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'yaml' or 'dotenv'"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to decrypt SOPS document: %v", err)})
+		return
+	}
+
+	variables := map[string]string{}
+	secrets := map[string]string{}
+	for _, entry := range entries {
+		switch {
+		case entry.Section == "variables":
+			variables[entry.Name] = entry.Value
+		case entry.Section == "secrets":
+			secrets[entry.Name] = entry.Value
+		case contains(req.VariableKeys, entry.Name) || req.DefaultType == "variable":
+			variables[entry.Name] = entry.Value
+		default:
+			secrets[entry.Name] = entry.Value
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	planned, violations, err := planKeyWrites(client, ctx, req.Target, variables, secrets, req.Overwrite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", req.Target, err)})
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"format":     req.Format,
+			"changes":    plannedChanges(planned),
+			"violations": violations,
+			"warnings":   warnings,
+		})
+		return
+	}
+
+	if rejectPolicyViolations(c, violations) {
+		return
+	}
+
+	changes, applied, errors := applyKeyWrites(client, ctx, planned)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("Imported %d keys from SOPS %s", applied, req.Format),
+		"applied_count": applied,
+		"changes":       changes,
+		"warnings":      warnings,
+		"errors":        errors,
+	})
+}
+
+func exportSopsFile(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Target     string            `json:"target"`
+		Format     string            `json:"format"`
+		Recipients []string          `json:"recipients"`
+		Secrets    map[string]string `json:"secrets"` // secret values, GitHub can't return them
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if _, err := targetLocation(req.Target, "variable", ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "yaml"
+	}
+	if req.Format != "yaml" && req.Format != "dotenv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'yaml' or 'dotenv'"})
+		return
+	}
+	if len(req.Recipients) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one age recipient is required"})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	existingVariables, existingSecrets, err := listTargetKeys(client, ctx, req.Target)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read %s: %v", req.Target, err)})
+		return
+	}
+
+	variables := map[string]string{}
+	for _, variable := range existingVariables {
+		variables[variable.Name] = variable.Value
+	}
+	secrets := map[string]string{}
+	withoutValue := []string{}
+	for _, secret := range existingSecrets {
+		if value, ok := req.Secrets[secret.Name]; ok {
+			secrets[secret.Name] = value
+		} else {
+			withoutValue = append(withoutValue, secret.Name)
+		}
+	}
+
+	var content []byte
+	extension := "yaml"
+	if req.Format == "yaml" {
+		content, err = encryptSopsYAML(variables, secrets, req.Recipients)
+	} else {
+		extension = "env"
+		flat := map[string]string{}
+		for name, value := range variables {
+			flat[name] = value
+		}
+		for name, value := range secrets {
+			flat[name] = value
+		}
+		content, err = encryptSopsDotenv(flat, req.Recipients)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to encrypt: %v", err)})
+		return
+	}
+
+	name := strings.NewReplacer("/", "-", ":", "-").Replace(req.Target)
+	c.JSON(http.StatusOK, gin.H{
+		"content":               string(content),
+		"filename":              fmt.Sprintf("%s.sops.%s", name, extension),
+		"format":                req.Format,
+		"variable_keys":         sortedKeys(variables),
+		"secrets_without_value": withoutValue,
+		"exported_at":           time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	})
+}
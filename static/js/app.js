@@ -9,10 +9,16 @@ class GitHubEnvManager {
     this.selectedEnvs = [];
     this.targetEnvs = [];
     this.metas = {};
+    this.staleSecrets = {};
     this.exporting = false;
     this.exportText = "";
     this.importTargets = [];
     this.importPreview = {};
+    this.kubeImport = null;
+    this.capabilities = null;
+    this.tokenInfo = null;
+    this.deviceLoginTimer = null;
+    this.notificationSocket = null;
     this.activeScopeTab = "repo"; // 'repo' | 'org'
 
     this.init();
@@ -54,6 +60,13 @@ class GitHubEnvManager {
     document
       .getElementById("addKeyForm")
       .addEventListener("submit", (e) => this.handleAddKey(e));
+    ["newKeyValue", "newKeyInterpolate"].forEach((id) =>
+      document
+        .getElementById(id)
+        .addEventListener(id === "newKeyValue" ? "input" : "change", () =>
+          this.previewResolvedValue()
+        )
+    );
 
     // Export/Import
     document
@@ -69,11 +82,27 @@ class GitHubEnvManager {
       .getElementById("clearImportBtn")
       .addEventListener("click", () => this.clearImport());
 
+    // Batch edit
+    document
+      .getElementById("batchPreviewBtn")
+      .addEventListener("click", () => this.runBatch(true));
+    document
+      .getElementById("batchApplyBtn")
+      .addEventListener("click", () => this.runBatch(false));
+    document
+      .getElementById("batchScheduleBtn")
+      .addEventListener("click", () => this.scheduleBatch());
+
     // Repository scope
     document
       .getElementById("repoScopeBtn")
       .addEventListener("click", () => this.loadRepoScopeData());
 
+    // Secret scan
+    document
+      .getElementById("secretScanBtn")
+      .addEventListener("click", () => this.scanForSecrets());
+
     // Repo scope
     document
       .getElementById("addRepoKeyBtn")
@@ -112,6 +141,8 @@ class GitHubEnvManager {
           this.user = JSON.parse(storedUser);
           this.showUserInfo();
           this.loadRepositories();
+          this.checkTokenInfo();
+          this.connectNotifications();
           return;
         }
       } catch (error) {
@@ -126,6 +157,24 @@ class GitHubEnvManager {
     this.showAuthSection();
   }
 
+  async checkTokenInfo() {
+    try {
+      const response = await fetch("/api/auth/token-info", {
+        headers: {
+          "X-Session-ID": this.sessionId || "",
+        },
+      });
+      if (!response.ok) return;
+      const info = await response.json();
+      this.tokenInfo = info;
+      (info.warnings || []).forEach((warning) =>
+        this.showToast(warning, "warning")
+      );
+    } catch (error) {
+      console.error("Token info error:", error);
+    }
+  }
+
   async loadRepositories(page = 1) {
     try {
       const response = await fetch(`/api/repos?page=${page}&per_page=25`, {
@@ -176,13 +225,94 @@ class GitHubEnvManager {
     this.showTokenModal();
   }
 
-  showTokenModal() {
+  async showTokenModal() {
     document.getElementById("tokenModal").classList.remove("hidden");
+
+    // Offer device flow login when the server has a client ID
+    try {
+      const response = await fetch("/api/auth/url");
+      const data = response.ok ? await response.json() : {};
+      document
+        .getElementById("deviceLogin")
+        .classList.toggle("hidden", data.type !== "device");
+    } catch (error) {
+      console.error("Auth URL error:", error);
+    }
   }
 
   closeTokenModal() {
     document.getElementById("tokenModal").classList.add("hidden");
     document.getElementById("tokenInput").value = "";
+    this.stopDeviceLogin();
+  }
+
+  async startDeviceLogin() {
+    this.stopDeviceLogin();
+    try {
+      const response = await fetch("/api/auth/device", { method: "POST" });
+      const data = await response.json();
+      if (!response.ok) {
+        this.showToast(data.error || "Failed to start login", "error");
+        return;
+      }
+
+      const link = document.getElementById("deviceVerificationUri");
+      link.textContent = data.verification_uri;
+      link.href = data.verification_uri;
+      document.getElementById("deviceUserCode").textContent = data.user_code;
+      document.getElementById("deviceCodeBox").classList.remove("hidden");
+      document.getElementById("deviceLoginBtn").disabled = true;
+      window.open(data.verification_uri, "_blank");
+
+      this.pollDeviceLogin(data.flow_id, data.interval);
+    } catch (error) {
+      this.showToast("Failed to start login", "error");
+      console.error("Device login error:", error);
+    }
+  }
+
+  pollDeviceLogin(flowId, interval) {
+    this.deviceLoginTimer = setTimeout(async () => {
+      try {
+        const response = await fetch(
+          `/api/auth/callback?flow_id=${encodeURIComponent(flowId)}`
+        );
+        const data = await response.json();
+        if (response.status === 202) {
+          this.pollDeviceLogin(flowId, data.interval || interval);
+          return;
+        }
+        this.stopDeviceLogin();
+        if (!response.ok) {
+          this.showToast(data.error || "Login failed", "error");
+          return;
+        }
+
+        this.sessionId = data.sessionId;
+        this.user = data.user;
+        // Device flow tokens live in the server session only
+        localStorage.removeItem("github_token");
+        localStorage.removeItem("github_user");
+        this.showToast("Authentication successful", "success");
+
+        this.closeTokenModal();
+        this.showUserInfo();
+        this.loadRepositories();
+        this.checkTokenInfo();
+        this.connectNotifications();
+      } catch (error) {
+        this.stopDeviceLogin();
+        this.showToast("Login failed", "error");
+        console.error("Device login poll error:", error);
+      }
+    }, interval * 1000);
+  }
+
+  stopDeviceLogin() {
+    clearTimeout(this.deviceLoginTimer);
+    this.deviceLoginTimer = null;
+    document.getElementById("deviceCodeBox").classList.add("hidden");
+    document.getElementById("deviceLoginBtn").disabled = false;
   }
 
   showCreateEnvModal() {
@@ -234,6 +364,8 @@ class GitHubEnvManager {
         this.closeTokenModal();
         this.showUserInfo();
         this.loadRepositories();
+        this.checkTokenInfo();
+        this.connectNotifications();
       } else {
         const error = await response.json();
         this.showToast(error.error || "Authentication failed", "error");
@@ -325,6 +457,11 @@ class GitHubEnvManager {
   logout() {
     this.user = null;
     this.sessionId = null;
+    if (this.notificationSocket) {
+      const socket = this.notificationSocket;
+      this.notificationSocket = null;
+      socket.close();
+    }
 
     // Clear stored authentication data
     localStorage.removeItem("github_token");
@@ -631,6 +768,7 @@ class GitHubEnvManager {
         this.updateContext();
         this.loadMeta();
         this.loadRepoScopeData(); // Also load repository variables and secrets
+        this.loadCapabilities();
       } else {
         const error = await response.json();
         this.showToast(error.error || "Failed to load environments", "error");
@@ -643,6 +781,53 @@ class GitHubEnvManager {
     }
   }
 
+  async loadCapabilities() {
+    const repo = `${this.ownerRepo.owner}/${this.ownerRepo.name}`;
+    try {
+      const response = await fetch(
+        `/api/repos/${this.ownerRepo.owner}/${this.ownerRepo.name}/capabilities`,
+        {
+          headers: {
+            "X-Session-ID": this.sessionId || "",
+          },
+        }
+      );
+      if (!response.ok) return;
+      const capabilities = await response.json();
+      // Ignore answers for a repository that is no longer selected
+      if (capabilities.repo !== repo) return;
+      this.capabilities = capabilities;
+      this.applyCapabilities();
+    } catch (error) {
+      console.error("Load capabilities error:", error);
+    }
+  }
+
+  applyCapabilities() {
+    const caps = (this.capabilities && this.capabilities.capabilities) || {};
+    const missing = [];
+    if (!caps.write_variables) missing.push("variables");
+    if (!caps.write_secrets) missing.push("secrets");
+
+    const submit = document.querySelector('#addKeyForm button[type="submit"]');
+    if (submit) {
+      const readOnly = missing.length === 2;
+      submit.disabled = readOnly;
+      submit.classList.toggle("opacity-50", readOnly);
+      submit.classList.toggle("cursor-not-allowed", readOnly);
+      submit.title = readOnly
+        ? `Your token has ${this.capabilities.permission} access and cannot write variables or secrets here`
+        : "";
+    }
+
+    if (missing.length) {
+      this.showToast(
+        `Read-only for ${missing.join(" and ")} in ${this.capabilities.repo} (${this.capabilities.permission} access)`,
+        "warning"
+      );
+    }
+  }
+
   renderEnvironmentPicker() {
     const envPicker = document.getElementById("envPicker");
     envPicker.innerHTML = "";
@@ -915,6 +1100,16 @@ class GitHubEnvManager {
       button.addEventListener("click", () => this.exportEnvironment(env));
       exportButtons.appendChild(button);
     });
+
+    const terraformButton = document.createElement("button");
+    terraformButton.className =
+      "flex items-center gap-2 px-4 py-2 rounded-lg text-sm font-medium shadow-sm border bg-white hover:bg-neutral-50 transition-colors";
+    terraformButton.innerHTML = `
+      <i class="fas fa-cubes text-xs"></i>
+      <span>Export Terraform</span>
+    `;
+    terraformButton.addEventListener("click", () => this.exportTerraform());
+    exportButtons.appendChild(terraformButton);
   }
 
   async loadMeta() {
@@ -960,6 +1155,7 @@ class GitHubEnvManager {
 
       const results = await Promise.all(promises);
       this.metas = Object.fromEntries(results);
+      await this.loadStaleSecrets();
 
       this.renderCompareTable();
     } catch (error) {
@@ -970,6 +1166,48 @@ class GitHubEnvManager {
     }
   }
 
+  async loadStaleSecrets() {
+    // Stale secrets are highlighted; a failure here only loses the highlighting
+    this.staleSecrets = {};
+    try {
+      const params = new URLSearchParams({
+        repos: `${this.ownerRepo.owner}/${this.ownerRepo.name}`,
+        org_scope: "false",
+      });
+      const response = await fetch(`/api/secrets/stale?${params}`, {
+        headers: {
+          "X-Session-ID": this.sessionId || "",
+        },
+      });
+      if (!response.ok) return;
+
+      const data = await response.json();
+      (data.secrets || []).forEach((entry) => {
+        const loc = entry.location;
+        this.staleSecrets[`${loc.environment || ""}|${loc.name}`] = entry;
+      });
+    } catch (error) {
+      console.error("Load stale secrets error:", error);
+    }
+  }
+
+  staleBadge(env, name) {
+    const entry = this.staleSecrets[`${env || ""}|${name}`];
+    if (!entry) return "";
+
+    const labels = {
+      rotation_overdue: `rotation overdue (every ${entry.rotation_interval_days} days)`,
+      expired: `expired on ${entry.expires_at}`,
+      expiring_soon: `expires on ${entry.expires_at}`,
+    };
+    const reasons = entry.reasons.map((reason) => labels[reason] || reason);
+    const owner = entry.owner ? ` · owner: ${entry.owner}` : "";
+    return `<span class="inline-flex items-center px-1.5 py-0.5 rounded bg-red-100 text-red-700 text-xs whitespace-nowrap"
+                  title="${(reasons.join(", ") + owner).replace(/"/g, "&quot;")}">
+              <i class="fas fa-clock text-xs mr-1"></i>${entry.age_days}d
+            </span>`;
+  }
+
   arrayToObject(arr) {
     const obj = {};
     if (!arr || !Array.isArray(arr)) {
@@ -1009,6 +1247,7 @@ class GitHubEnvManager {
         }
       );
       const secrets = secretsResponse.ok ? await secretsResponse.json() : [];
+      await this.loadStaleSecrets();
 
       this.renderRepoTable(variables, secrets);
     } catch (error) {
@@ -1131,7 +1370,10 @@ class GitHubEnvManager {
       secs.forEach((secret) => {
         html += `
           <tr class="border-t">
-            <td class="px-3 py-2 text-xs font-mono">${secret.name}</td>
+            <td class="px-3 py-2 text-xs font-mono">${secret.name} ${this.staleBadge(
+              "",
+              secret.name
+            )}</td>
             <td class="px-3 py-2 text-xs font-mono text-neutral-400 flex items-center gap-2">
               <span class="flex-1">••••••••</span>
               <button class="p-1 text-slate-400 hover:text-orange-600 hover:bg-orange-50 rounded transition-colors" 
@@ -1335,6 +1577,7 @@ class GitHubEnvManager {
               <span class="inline-flex items-center px-2 py-1 bg-slate-100 text-slate-600 rounded text-xs font-mono flex-1">
                 <i class="fas fa-eye-slash text-xs mr-1"></i>••••••••
               </span>
+              ${this.staleBadge(env, key)}
               <button class="p-1 text-slate-400 hover:text-orange-600 hover:bg-orange-50 rounded transition-colors" 
                       onclick="app.editKey('${env}', '${key}', 'secret')" 
                       title="Edit secret">
@@ -1409,6 +1652,9 @@ class GitHubEnvManager {
     const key = document.getElementById("newKey").value.trim();
     const type = document.getElementById("newKeyType").value;
     const value = document.getElementById("newKeyValue").value.trim();
+    const interpolate =
+      type === "variable" &&
+      document.getElementById("newKeyInterpolate").checked;
     const targets = this.targetEnvs;
 
     if (!key || !value || !targets.length) {
@@ -1422,7 +1668,7 @@ class GitHubEnvManager {
     this.showLoading(true);
     try {
       for (const env of targets) {
-        await this.upsertKey({ env, key, value, type });
+        await this.upsertKey({ env, key, value, type, interpolate });
       }
       await this.loadMeta();
       this.showToast(`Created ${key} in ${targets.join(", ")}`, "success");
@@ -1430,18 +1676,65 @@ class GitHubEnvManager {
       // Clear form
       document.getElementById("newKey").value = "";
       document.getElementById("newKeyValue").value = "";
+      document.getElementById("newKeyInterpolate").checked = false;
+      this.previewResolvedValue();
       // Clear target environments selection
       this.targetEnvs = [];
       this.renderTargetEnvs();
     } catch (error) {
-      this.showToast("Failed to create key", "error");
+      this.showToast(error.message || "Failed to create key", "error");
       console.error("Add key error:", error);
     } finally {
       this.showLoading(false);
     }
   }
 
-  async upsertKey({ env, key, value, type }) {
+  async previewResolvedValue() {
+    // Shows what a template resolves to in the first target environment
+    const resolvedEl = document.getElementById("newKeyResolved");
+    const value = document.getElementById("newKeyValue").value.trim();
+    const env = this.targetEnvs[0];
+    if (
+      !document.getElementById("newKeyInterpolate").checked ||
+      !value.includes("${") ||
+      !env
+    ) {
+      resolvedEl.classList.add("hidden");
+      return;
+    }
+
+    clearTimeout(this.resolvePreviewTimer);
+    this.resolvePreviewTimer = setTimeout(async () => {
+      try {
+        const response = await fetch("/api/value-templates", {
+          method: "PUT",
+          headers: {
+            "Content-Type": "application/json",
+            "X-Session-ID": this.sessionId || "",
+          },
+          body: JSON.stringify({
+            location: {
+              scope: "environment",
+              repo: `${this.ownerRepo.owner}/${this.ownerRepo.name}`,
+              environment: env,
+              name: document.getElementById("newKey").value.trim() || "PREVIEW",
+            },
+            template: value,
+            dry_run: true,
+          }),
+        });
+        const data = await response.json();
+        resolvedEl.textContent = response.ok
+          ? `${env}: ${data.resolved}`
+          : data.error;
+        resolvedEl.classList.remove("hidden");
+      } catch (error) {
+        console.error("Resolve preview error:", error);
+      }
+    }, 400);
+  }
+
+  async upsertKey({ env, key, value, type, interpolate = false }) {
     let method, url;
 
     if (env === null) {
@@ -1470,6 +1763,7 @@ class GitHubEnvManager {
     }
 
     const body = method === "PUT" ? { value } : { name: key, value };
+    if (interpolate) body.interpolate = true;
 
     const response = await fetch(url, {
       method,
@@ -1482,12 +1776,22 @@ class GitHubEnvManager {
 
     if (!response.ok) {
       const target = env === null ? "repository" : env;
+      if (response.status === 422) {
+        const data = await response.json().catch(() => ({}));
+        if (data.error) throw new Error(data.error);
+      }
       throw new Error(
         `Failed to ${
           method === "PUT" ? "update" : "create"
         } ${type} in ${target}`
       );
     }
+
+    // Protected environments stage the change for review instead
+    if (response.status === 202) {
+      const data = await response.json();
+      this.showToast(data.message, "info");
+    }
   }
 
   async copyToClipboard(text) {
@@ -1613,7 +1917,10 @@ class GitHubEnvManager {
         }
       );
 
-      if (response.ok) {
+      if (response.status === 202) {
+        const data = await response.json();
+        this.showToast(data.message, "info");
+      } else if (response.ok) {
         await this.loadMeta();
         this.showToast(`Deleted ${key} from ${env}`, "success");
       } else {
@@ -1754,6 +2061,48 @@ class GitHubEnvManager {
     }
   }
 
+  async exportTerraform() {
+    this.showLoading(true);
+    try {
+      const response = await fetch("/api/export/terraform", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Session-ID": this.sessionId || "",
+        },
+        body: JSON.stringify({
+          repos: [`${this.ownerRepo.owner}/${this.ownerRepo.name}`],
+          envs: this.selectedEnvs,
+        }),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to export Terraform");
+      }
+
+      this.exportText = data.content;
+      this.showExportPreview();
+
+      const blob = new Blob([data.content], { type: "text/plain" });
+      const url = URL.createObjectURL(blob);
+      const a = document.createElement("a");
+      a.href = url;
+      a.download = `${this.ownerRepo.name}-${data.filename}`;
+      a.click();
+      URL.revokeObjectURL(url);
+
+      this.showToast(
+        `Exported ${data.resources} Terraform resources (${data.secret_variables.length} secret inputs)`,
+        "success"
+      );
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Terraform export error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
   showExportPreview() {
     document.getElementById("exportText").value = this.exportText;
     document.getElementById("exportPreview").classList.remove("hidden");
@@ -1765,13 +2114,135 @@ class GitHubEnvManager {
 
     const reader = new FileReader();
     reader.onload = (e) => {
-      this.parseDotEnv(String(e.target?.result || ""));
+      this.parseImport(String(e.target?.result || ""));
     };
     reader.readAsText(file);
   }
 
   handleImportText(e) {
-    this.parseDotEnv(e.target.value);
+    this.parseImport(e.target.value);
+  }
+
+  parseImport(text) {
+    // Kubernetes manifests are parsed by the server; anything else is .env
+    clearTimeout(this.kubePreviewTimer);
+    if (/^\s*kind:\s*(ConfigMap|Secret|List)\b/m.test(text || "")) {
+      this.importPreview = {};
+      this.kubePreviewTimer = setTimeout(
+        () => this.previewKubernetesImport(text),
+        400
+      );
+      return;
+    }
+    this.kubeImport = null;
+    this.parseDotEnv(text);
+  }
+
+  kubeImportTarget(env) {
+    return `${this.ownerRepo.owner}/${this.ownerRepo.name}:${env}`;
+  }
+
+  async previewKubernetesImport(manifests) {
+    if (!this.importTargets.length) {
+      this.showToast("Select at least one environment", "error");
+      return;
+    }
+
+    try {
+      const changes = [];
+      let warnings = [];
+      for (const env of this.importTargets) {
+        const response = await fetch("/api/import/kubernetes", {
+          method: "POST",
+          headers: {
+            "Content-Type": "application/json",
+            "X-Session-ID": this.sessionId || "",
+          },
+          body: JSON.stringify({
+            manifests,
+            target: this.kubeImportTarget(env),
+            overwrite: true,
+            dry_run: true,
+          }),
+        });
+        const data = await response.json();
+        if (!response.ok) {
+          throw new Error(data.error || "Failed to read manifests");
+        }
+        changes.push(...(data.changes || []));
+        warnings = data.warnings || [];
+      }
+
+      this.kubeImport = { manifests, changes, warnings };
+      this.showKubernetesPreview();
+    } catch (error) {
+      this.kubeImport = null;
+      this.showImportPreview();
+      this.showToast(error.message, "error");
+      console.error("Kubernetes import preview error:", error);
+    }
+  }
+
+  showKubernetesPreview() {
+    const { changes, warnings } = this.kubeImport;
+    document.getElementById("importCount").textContent = changes.length;
+    document.getElementById("importPreviewContent").innerHTML =
+      changes
+        .map(
+          (change) =>
+            `<div class="flex items-center justify-between gap-2"><span>${
+              change.name
+            }</span><span class="truncate">${change.type} → ${
+              change.target.split(":")[1]
+            } (${change.action})</span></div>`
+        )
+        .join("") +
+      warnings
+        .map(
+          (warning) =>
+            `<div class="text-amber-600">${String(warning)
+              .replace(/&/g, "&amp;")
+              .replace(/</g, "&lt;")}</div>`
+        )
+        .join("");
+    document.getElementById("importPreview").classList.remove("hidden");
+  }
+
+  async applyKubernetesImport() {
+    this.showLoading(true);
+    try {
+      let applied = 0;
+      for (const env of this.importTargets) {
+        const response = await fetch("/api/import/kubernetes", {
+          method: "POST",
+          headers: {
+            "Content-Type": "application/json",
+            "X-Session-ID": this.sessionId || "",
+          },
+          body: JSON.stringify({
+            manifests: this.kubeImport.manifests,
+            target: this.kubeImportTarget(env),
+            overwrite: true,
+          }),
+        });
+        const data = await response.json();
+        if (!response.ok) {
+          throw new Error(data.error || `Failed to import into ${env}`);
+        }
+        applied += data.applied_count || 0;
+      }
+      await this.loadMeta();
+      this.showToast(
+        `Imported ${applied} keys into ${this.importTargets.join(", ")}`,
+        "success"
+      );
+      this.clearImport();
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Kubernetes import error:", error);
+    } finally {
+      this.showLoading(false);
+    }
   }
 
   parseDotEnv(text) {
@@ -1822,6 +2293,11 @@ class GitHubEnvManager {
   }
 
   async applyImport() {
+    if (this.kubeImport) {
+      await this.applyKubernetesImport();
+      return;
+    }
+
     if (!Object.keys(this.importPreview).length) {
       this.showToast("Nothing to import", "error");
       return;
@@ -1857,6 +2333,7 @@ class GitHubEnvManager {
 
   clearImport() {
     this.importPreview = {};
+    this.kubeImport = null;
     document.getElementById("importText").value = "";
     document.getElementById("importFile").value = "";
     this.showImportPreview();
@@ -1928,6 +2405,654 @@ class GitHubEnvManager {
     }
   }
 
+  toggleBatchPanel() {
+    const panel = document.getElementById("batchPanel");
+    panel.classList.toggle("hidden");
+    const targets = document.getElementById("batchTargets");
+    if (!panel.classList.contains("hidden") && !targets.value.trim()) {
+      // Start from the current repository and selected environments
+      targets.value = this.selectedEnvs
+        .map((env) => `${this.ownerRepo.owner}/${this.ownerRepo.name}:${env}`)
+        .join("\n");
+    }
+    if (!panel.classList.contains("hidden")) this.loadSchedules();
+  }
+
+  batchLines(id) {
+    return document
+      .getElementById(id)
+      .value.split(/\r?\n/)
+      .map((line) => line.trim())
+      .filter((line) => line && !line.startsWith("#"));
+  }
+
+  batchChangeSet() {
+    const parse = (id) => {
+      const values = {};
+      this.batchLines(id).forEach((line) => {
+        const idx = line.indexOf("=");
+        if (idx > 0) values[line.slice(0, idx).trim()] = line.slice(idx + 1);
+      });
+      return values;
+    };
+    return {
+      set_variables: parse("batchSetVariables"),
+      set_secrets: parse("batchSetSecrets"),
+      delete_variables: this.batchLines("batchDeleteVariables"),
+      delete_secrets: this.batchLines("batchDeleteSecrets"),
+    };
+  }
+
+  async runBatch(dryRun) {
+    const targets = this.batchLines("batchTargets");
+    if (!targets.length) {
+      this.showToast("Add at least one target", "error");
+      return;
+    }
+    if (
+      !dryRun &&
+      !confirm(`Apply this change set to ${targets.length} targets?`)
+    ) {
+      return;
+    }
+
+    this.showLoading(true);
+    try {
+      const response = await fetch("/api/batch", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Session-ID": this.sessionId || "",
+        },
+        body: JSON.stringify({
+          targets,
+          ...this.batchChangeSet(),
+          dry_run: dryRun,
+        }),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        const details = (data.violations || [])
+          .map((v) => v.message)
+          .join("; ");
+        throw new Error(
+          details ? `${data.error}: ${details}` : data.error || "Batch failed"
+        );
+      }
+
+      this.renderBatchReport(data.targets || []);
+      if (!dryRun) {
+        this.showToast(data.message, "success");
+        if (this.selectedEnvs.length) await this.loadMeta();
+      }
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Batch edit error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
+  async scheduleBatch() {
+    const targets = this.batchLines("batchTargets");
+    const applyAt = document.getElementById("batchApplyAt").value;
+    const revertAfter = document
+      .getElementById("batchRevertAfter")
+      .value.trim();
+    if (!targets.length) {
+      this.showToast("Add at least one target", "error");
+      return;
+    }
+    if (!applyAt && !revertAfter) {
+      this.showToast(
+        "Set a time to apply at, a duration to revert after, or both",
+        "error"
+      );
+      return;
+    }
+
+    this.showLoading(true);
+    try {
+      const response = await fetch("/api/schedules", {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Session-ID": this.sessionId || "",
+        },
+        body: JSON.stringify({
+          targets,
+          ...this.batchChangeSet(),
+          // datetime-local has no zone; send the browser's local time as UTC
+          apply_at: applyAt ? new Date(applyAt).toISOString() : "",
+          revert_after: revertAfter,
+        }),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        const details = (data.violations || [])
+          .map((v) => v.message)
+          .join("; ");
+        throw new Error(
+          details
+            ? `${data.error}: ${details}`
+            : data.error || "Scheduling failed"
+        );
+      }
+
+      const schedule = data.schedule;
+      this.showToast(
+        schedule.status === "scheduled"
+          ? `Scheduled for ${new Date(schedule.apply_at).toLocaleString()}`
+          : `Applied; reverts at ${new Date(schedule.revert_at).toLocaleString()}`,
+        "success"
+      );
+      await this.loadSchedules();
+      if (schedule.status !== "scheduled" && this.selectedEnvs.length) {
+        await this.loadMeta();
+      }
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Schedule error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
+  async loadSchedules() {
+    try {
+      const response = await fetch("/api/schedules", {
+        headers: {
+          "X-Session-ID": this.sessionId || "",
+        },
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to load scheduled changes");
+      }
+      this.renderSchedules(
+        (data.schedules || []).filter(
+          (s) =>
+            s.status === "scheduled" ||
+            s.status === "applying" ||
+            s.status === "active"
+        )
+      );
+    } catch (error) {
+      console.error("Schedules error:", error);
+    }
+  }
+
+  renderSchedules(schedules) {
+    document.getElementById("scheduleList").innerHTML = schedules
+      .map((schedule) => {
+        const keys = schedule.targets
+          .flatMap((target) => target.keys.map((key) => key.name))
+          .filter((name, i, all) => all.indexOf(name) === i)
+          .join(", ");
+        const when =
+          schedule.status === "applying"
+            ? "applying"
+            : schedule.status === "scheduled"
+            ? `applies ${new Date(schedule.apply_at).toLocaleString()}${
+                schedule.revert_after
+                  ? `, reverts ${schedule.revert_after} later`
+                  : ""
+              }`
+            : `reverts ${new Date(schedule.revert_at).toLocaleString()}`;
+        return `
+        <div class="flex items-center justify-between gap-2 rounded-xl border border-slate-200 p-3 text-xs">
+          <div>
+            <div class="font-mono text-slate-800">${keys}</div>
+            <div class="text-slate-500">${schedule.targets
+              .map((target) => target.target)
+              .join(", ")} · ${when} · by ${schedule.created_by}${
+              schedule.last_error
+                ? ` · <span class="text-red-600">${schedule.last_error}</span>`
+                : ""
+            }</div>
+          </div>
+          <div class="flex gap-2">
+            ${
+              schedule.status === "active"
+                ? `<button onclick="app.cancelSchedule('${schedule.id}', true)" class="px-3 py-1 rounded-lg font-medium bg-slate-50 text-slate-700 hover:bg-slate-100">Keep</button>`
+                : ""
+            }
+            ${
+              schedule.status === "applying"
+                ? ""
+                : `<button onclick="app.cancelSchedule('${schedule.id}', false)" class="px-3 py-1 rounded-lg font-medium bg-red-50 text-red-700 hover:bg-red-100">${
+                    schedule.status === "active" ? "Revert now" : "Cancel"
+                  }</button>`
+            }
+          </div>
+        </div>`;
+      })
+      .join("");
+  }
+
+  async cancelSchedule(id, keep) {
+    this.showLoading(true);
+    try {
+      const response = await fetch(`/api/schedules/${id}/cancel`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Session-ID": this.sessionId || "",
+        },
+        body: JSON.stringify({ keep }),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to cancel scheduled change");
+      }
+      this.showToast(data.message, "success");
+      await this.loadSchedules();
+      if (this.selectedEnvs.length) await this.loadMeta();
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Cancel schedule error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
+  renderBatchReport(reports) {
+    const colors = {
+      create: "text-green-700",
+      update: "text-blue-700",
+      delete: "text-red-700",
+      unchanged: "text-slate-400",
+      absent: "text-slate-400",
+    };
+    document.getElementById("batchReport").innerHTML = reports
+      .map(
+        (report) => `
+        <div class="rounded-xl border border-slate-200 p-3">
+          <div class="flex items-center justify-between text-sm font-semibold text-slate-800">
+            <span class="font-mono">${report.target}</span>
+            <span class="text-xs font-medium ${
+              report.status === "failed" || report.status === "partial"
+                ? "text-red-600"
+                : "text-slate-500"
+            }">${report.status}</span>
+          </div>
+          ${
+            report.error
+              ? `<div class="text-xs text-red-600 mt-1">${report.error}</div>`
+              : ""
+          }
+          <div class="mt-2 space-y-1 font-mono text-xs">
+            ${report.changes
+              .map(
+                (change) => `
+              <div class="flex items-center justify-between gap-2">
+                <span>${change.type === "secret" ? "🔒" : "🔧"} ${
+                  change.name
+                }</span>
+                <span class="${colors[change.action] || ""}">${change.action}${
+                  change.status ? ` · ${change.status}` : ""
+                }${change.error ? ` · ${change.error}` : ""}</span>
+              </div>`
+              )
+              .join("")}
+          </div>
+        </div>`
+      )
+      .join("");
+  }
+
+  connectNotifications() {
+    if (this.notificationSocket || !this.sessionId) return;
+    const protocol = window.location.protocol === "https:" ? "wss:" : "ws:";
+    const socket = new WebSocket(
+      `${protocol}//${window.location.host}/ws?session=${encodeURIComponent(
+        this.sessionId
+      )}`
+    );
+    socket.onmessage = (event) => {
+      const notification = JSON.parse(event.data);
+      if (notification.type !== "change_request") return;
+      const request = notification.change_request;
+      const target = `${request.repo}:${request.environment}`;
+      if (request.requested_by !== (this.user && this.user.login)) {
+        const actor =
+          notification.event === "created"
+            ? request.requested_by
+            : request.applied_by || request.reviewed_by;
+        this.showToast(
+          `Change request for ${target} ${notification.event} by ${actor}`,
+          "info"
+        );
+      } else if (notification.event !== "created") {
+        this.showToast(
+          `Your change request for ${target} was ${notification.event}`,
+          notification.event === "rejected" || notification.event === "failed"
+            ? "warning"
+            : "success"
+        );
+      }
+      const panel = document.getElementById("changeRequestsPanel");
+      if (!panel.classList.contains("hidden")) this.loadChangeRequests();
+    };
+    socket.onclose = () => {
+      // Reconnect while still logged in
+      if (this.notificationSocket === socket) {
+        this.notificationSocket = null;
+        setTimeout(() => this.connectNotifications(), 5000);
+      }
+    };
+    this.notificationSocket = socket;
+  }
+
+  toggleChangeRequestsPanel() {
+    const panel = document.getElementById("changeRequestsPanel");
+    panel.classList.toggle("hidden");
+    if (!panel.classList.contains("hidden")) this.loadChangeRequests();
+  }
+
+  async loadChangeRequests() {
+    const status = document.getElementById("changeRequestStatus").value;
+    try {
+      const response = await fetch(
+        `/api/change-requests${status ? `?status=${status}` : ""}`,
+        {
+          headers: {
+            "X-Session-ID": this.sessionId || "",
+          },
+        }
+      );
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || "Failed to load change requests");
+      }
+      this.renderChangeRequests(
+        data.change_requests || [],
+        data.protected_environments || []
+      );
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Change requests error:", error);
+    }
+  }
+
+  renderChangeRequests(requests, protectedEnvs) {
+    const colors = {
+      pending: "text-amber-600",
+      approved: "text-blue-600",
+      applied: "text-green-600",
+      rejected: "text-slate-500",
+      failed: "text-red-600",
+    };
+    document.getElementById("changeRequestsProtected").textContent =
+      protectedEnvs.length
+        ? `Protected environments: ${protectedEnvs.join(", ")}`
+        : "No environments are protected";
+
+    const login = this.user && this.user.login;
+    const button = (id, action, label, style) =>
+      `<button onclick="app.reviewChangeRequest('${id}', '${action}')" class="px-3 py-1 rounded-lg text-xs font-medium ${style}">${label}</button>`;
+
+    document.getElementById("changeRequestsList").innerHTML = requests.length
+      ? requests
+          .map((request) => {
+            const actions = [];
+            if (request.status === "pending" && request.requested_by !== login) {
+              actions.push(
+                button(
+                  request.id,
+                  "approve",
+                  "Approve",
+                  "bg-green-50 text-green-700 hover:bg-green-100"
+                )
+              );
+            }
+            if (request.status === "pending") {
+              actions.push(
+                button(
+                  request.id,
+                  "reject",
+                  request.requested_by === login ? "Withdraw" : "Reject",
+                  "bg-red-50 text-red-700 hover:bg-red-100"
+                )
+              );
+            }
+            if (request.status === "approved") {
+              actions.push(
+                button(
+                  request.id,
+                  "apply",
+                  "Apply",
+                  "bg-purple-50 text-purple-700 hover:bg-purple-100"
+                )
+              );
+            }
+            return `
+        <div class="rounded-xl border border-slate-200 p-3">
+          <div class="flex items-center justify-between text-sm font-semibold text-slate-800">
+            <span><span class="font-mono">${request.repo}:${
+              request.environment
+            }</span>${request.title ? ` · ${request.title}` : ""}</span>
+            <span class="text-xs font-medium ${colors[request.status] || ""}">${
+              request.status
+            }</span>
+          </div>
+          <div class="text-xs text-slate-500 mt-1">
+            by ${request.requested_by} · ${new Date(
+              request.requested_at
+            ).toLocaleString()}${
+              request.reviewed_by ? ` · reviewed by ${request.reviewed_by}` : ""
+            }${request.review_comment ? ` · “${request.review_comment}”` : ""}
+          </div>
+          <div class="mt-2 space-y-1 font-mono text-xs">
+            ${request.changes
+              .map(
+                (change) => `
+              <div class="flex items-center justify-between gap-2">
+                <span>${change.type === "secret" ? "🔒" : "🔧"} ${
+                  change.name
+                }</span>
+                <span>${change.action}${
+                  change.type === "variable" && change.action !== "delete"
+                    ? ` · ${change.current || "∅"} → ${change.value}`
+                    : ""
+                }${change.error ? ` · ${change.error}` : ""}</span>
+              </div>`
+              )
+              .join("")}
+          </div>
+          ${
+            (request.errors || []).length
+              ? `<div class="text-xs text-red-600 mt-1">${request.errors.join(
+                  "<br>"
+                )}</div>`
+              : ""
+          }
+          ${
+            actions.length
+              ? `<div class="flex gap-2 mt-3">${actions.join("")}</div>`
+              : ""
+          }
+        </div>`;
+          })
+          .join("")
+      : `<div class="text-sm text-slate-500">No change requests</div>`;
+  }
+
+  async reviewChangeRequest(id, action) {
+    let body = {};
+    if (action === "approve" || action === "reject") {
+      const comment = await this.showPrompt(
+        action === "approve" ? "Approve Change Request" : "Reject Change Request",
+        "Comment (optional):",
+        ""
+      );
+      if (comment === null) return;
+      body = { comment };
+    } else if (!confirm("Apply this change request now?")) {
+      return;
+    }
+
+    this.showLoading(true);
+    try {
+      const response = await fetch(`/api/change-requests/${id}/${action}`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Session-ID": this.sessionId || "",
+        },
+        body: JSON.stringify(body),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(data.error || `Failed to ${action} change request`);
+      }
+      this.showToast(
+        data.message || `Change request ${data.change_request.status}`,
+        "success"
+      );
+      await this.loadChangeRequests();
+      if (action === "apply" && this.selectedEnvs.length) await this.loadMeta();
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Change request error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
+  async scanForSecrets() {
+    if (!this.ownerRepo.owner || !this.ownerRepo.name) return;
+
+    this.showLoading(true);
+    try {
+      const response = await fetch(
+        `/api/repos/${this.ownerRepo.owner}/${this.ownerRepo.name}/secret-scan`,
+        {
+          headers: {
+            "X-Session-ID": this.sessionId || "",
+          },
+        }
+      );
+      if (!response.ok) {
+        throw new Error("Secret scan failed");
+      }
+      const data = await response.json();
+      this.renderSecretScan(data.findings || []);
+    } catch (error) {
+      this.showToast("Failed to scan variables", "error");
+      console.error("Secret scan error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
+  renderSecretScan(findings) {
+    const panel = document.getElementById("secretScanPanel");
+    const results = document.getElementById("secretScanResults");
+    panel.classList.remove("hidden");
+
+    if (findings.length === 0) {
+      results.innerHTML = `
+        <div class="text-sm text-green-700">
+          <i class="fas fa-check-circle mr-1"></i>
+          No variables look like credentials
+        </div>
+      `;
+      return;
+    }
+
+    let html = `
+      <table class="w-full text-left">
+        <thead class="bg-gray-50 text-xs">
+          <tr>
+            <th class="px-3 py-2">Name</th>
+            <th class="px-3 py-2">Scope</th>
+            <th class="px-3 py-2">Value</th>
+            <th class="px-3 py-2">Why</th>
+            <th class="px-3 py-2 text-right">Actions</th>
+          </tr>
+        </thead>
+        <tbody>
+    `;
+
+    findings.forEach((finding) => {
+      const env = finding.environment || "";
+      const badge =
+        finding.severity === "high"
+          ? "bg-red-100 text-red-700"
+          : "bg-amber-100 text-amber-700";
+      html += `
+        <tr class="border-t">
+          <td class="px-3 py-2 text-xs font-mono">${finding.name}</td>
+          <td class="px-3 py-2 text-xs">${env || "repository"}</td>
+          <td class="px-3 py-2 text-xs font-mono">${finding.preview}</td>
+          <td class="px-3 py-2 text-xs">
+            <span class="px-2 py-0.5 rounded ${badge}">${finding.severity}</span>
+            ${finding.reasons.join(", ")}
+          </td>
+          <td class="px-3 py-2 text-right">
+            <button class="px-2 py-1 rounded text-xs bg-amber-100 text-amber-800 hover:bg-amber-200"
+                    onclick="app.convertToSecret('${finding.name}', '${env}')">
+              Convert to secret
+            </button>
+          </td>
+        </tr>
+      `;
+    });
+
+    html += "</tbody></table>";
+    results.innerHTML = html;
+  }
+
+  async convertToSecret(name, env) {
+    const target = env || "the repository";
+    const confirmed = await this.showConfirm(
+      "Convert to secret",
+      `Create secret ${name} in ${target} with the current value and delete the variable?`
+    );
+    if (!confirmed) return;
+
+    this.showLoading(true);
+    try {
+      const convert = (overwrite) =>
+        fetch(
+          `/api/repos/${this.ownerRepo.owner}/${this.ownerRepo.name}/secret-scan/convert`,
+          {
+            method: "POST",
+            headers: {
+              "Content-Type": "application/json",
+              "X-Session-ID": this.sessionId || "",
+            },
+            body: JSON.stringify({ name, environment: env, overwrite }),
+          }
+        );
+      let response = await convert(false);
+      let data = await response.json();
+      if (response.status === 409) {
+        this.showLoading(false);
+        const replace = await this.showConfirm(
+          "Secret already exists",
+          `${data.error}. Replace secret ${name} in ${target} with the variable's value?`
+        );
+        if (!replace) return;
+        this.showLoading(true);
+        response = await convert(true);
+        data = await response.json();
+      }
+      if (!response.ok) {
+        throw new Error(data.error || "Conversion failed");
+      }
+      this.showToast(data.message, "success");
+      await this.scanForSecrets();
+      await this.loadMeta();
+    } catch (error) {
+      this.showToast(error.message, "error");
+      console.error("Convert to secret error:", error);
+    } finally {
+      this.showLoading(false);
+    }
+  }
+
   async refreshMeta() {
     await this.loadMeta();
   }
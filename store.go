@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Local store for state GitHub doesn't keep for us (rotation history, secret
+// metadata, ...). Everything lives in one JSON file under the data directory and
+// is rewritten atomically on each change.
+
+// dataDir is where the local store is kept
+var dataDir = "data"
+
+// StoreData is the content of the local store
+type StoreData struct {
+	Rotations      []RotationRecord    `json:"rotations"`
+	SecretMetadata []SecretMetadata    `json:"secret_metadata"`
+	ValueTemplates []ValueTemplate     `json:"value_templates"`
+	Layers         []EnvironmentLayers `json:"layers"`
+
+	EnvironmentTemplates []EnvironmentTemplate `json:"environment_templates"`
+	AppAudit             []AppAuditEntry       `json:"app_audit"`
+	ChangeRequests       []ChangeRequest       `json:"change_requests"`
+	ScheduledChanges     []ScheduledChange     `json:"scheduled_changes"`
+}
+
+type localStore struct {
+	mu   sync.Mutex
+	path string
+	data StoreData
+}
+
+var store *localStore
+
+// openStore loads the store from dir, creating the directory if needed
+func openStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	s := &localStore{path: filepath.Join(dir, "store.json")}
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %v", err)
+	}
+	if err := json.Unmarshal(content, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", s.path, err)
+	}
+	return s, nil
+}
+
+// view runs fn with read access to the store
+func (s *localStore) view(fn func(data *StoreData)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.data)
+}
+
+// update runs fn with write access to the store and persists the result. If fn
+// or the write fails the in-memory state is rolled back.
+func (s *localStore) update(fn func(data *StoreData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	restore := func() {
+		s.data = StoreData{}
+		json.Unmarshal(previous, &s.data)
+	}
+
+	if err := fn(&s.data); err != nil {
+		restore()
+		return err
+	}
+
+	content, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		restore()
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		restore()
+		return fmt.Errorf("failed to write store: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		restore()
+		return fmt.Errorf("failed to write store: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Terraform export. Renders the environments of the selected repositories as
+// resources of the integrations/github provider, with import blocks so existing
+// environments can be brought under management without recreating them. Secret
+// values are unknown to us and become sensitive input variables.
+
+// terraformWriter builds an HCL document with unique resource labels
+type terraformWriter struct {
+	body    strings.Builder
+	imports strings.Builder
+	inputs  strings.Builder
+	labels  map[string]int
+
+	resources       int
+	secretVariables []string
+}
+
+func newTerraformWriter() *terraformWriter {
+	return &terraformWriter{labels: make(map[string]int), secretVariables: []string{}}
+}
+
+// label turns parts into a unique Terraform identifier
+func (w *terraformWriter) label(parts ...string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.Join(parts, "_")) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	label := b.String()
+	if label == "" || (label[0] >= '0' && label[0] <= '9') {
+		label = "_" + label
+	}
+
+	// A numbered label may itself be taken ("a_b" then "a.b" then "a_b_2")
+	unique := label
+	for n := 2; w.labels[unique] > 0; n++ {
+		unique = fmt.Sprintf("%s_%d", label, n)
+	}
+	w.labels[unique]++
+	return unique
+}
+
+// hclString quotes s as an HCL string, escaping template sequences
+func hclString(s string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", "$${",
+		"%{", "%%{",
+	)
+	return `"` + replacer.Replace(s) + `"`
+}
+
+func hclNumbers(ids []int64) string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = fmt.Sprint(id)
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func (w *terraformWriter) importBlock(to, id string) {
+	fmt.Fprintf(&w.imports, "import {\n  to = %s\n  id = %s\n}\n\n", to, hclString(id))
+}
+
+// environment renders a github_repository_environment and returns its address
+func (w *terraformWriter) environment(repo, env string, settings *EnvironmentSettings) string {
+	label := w.label(repo, env)
+	address := "github_repository_environment." + label
+
+	fmt.Fprintf(&w.body, "resource \"github_repository_environment\" %q {\n", label)
+	fmt.Fprintf(&w.body, "  repository          = %s\n", hclString(repo))
+	fmt.Fprintf(&w.body, "  environment         = %s\n", hclString(env))
+	if settings != nil {
+		if settings.WaitTimer > 0 {
+			fmt.Fprintf(&w.body, "  wait_timer          = %d\n", settings.WaitTimer)
+		}
+		fmt.Fprintf(&w.body, "  can_admins_bypass   = %t\n", settings.CanAdminsBypass)
+		fmt.Fprintf(&w.body, "  prevent_self_review = %t\n", settings.PreventSelfReview)
+
+		var users, teams []int64
+		for _, reviewer := range settings.Reviewers {
+			if reviewer.Type == "Team" {
+				teams = append(teams, reviewer.ID)
+			} else {
+				users = append(users, reviewer.ID)
+			}
+		}
+		if len(users) > 0 || len(teams) > 0 {
+			w.body.WriteString("\n  reviewers {\n")
+			if len(users) > 0 {
+				fmt.Fprintf(&w.body, "    users = %s\n", hclNumbers(users))
+			}
+			if len(teams) > 0 {
+				fmt.Fprintf(&w.body, "    teams = %s\n", hclNumbers(teams))
+			}
+			w.body.WriteString("  }\n")
+		}
+
+		if settings.ProtectedBranches || settings.CustomBranchPolicies {
+			w.body.WriteString("\n  deployment_branch_policy {\n")
+			fmt.Fprintf(&w.body, "    protected_branches     = %t\n", settings.ProtectedBranches)
+			fmt.Fprintf(&w.body, "    custom_branch_policies = %t\n", settings.CustomBranchPolicies)
+			w.body.WriteString("  }\n")
+		}
+		for _, policy := range settings.BranchPolicies {
+			fmt.Fprintf(&w.body, "  # %s policy %q is managed by github_repository_environment_deployment_policy\n", policy.Type, policy.Name)
+		}
+	}
+	w.body.WriteString("}\n\n")
+
+	w.importBlock(address, repo+":"+env)
+	w.resources++
+	return address
+}
+
+// variable renders a github_actions_environment_variable of an environment resource
+func (w *terraformWriter) variable(repo, env, envAddress string, variable Variable) {
+	label := w.label(repo, env, variable.Name)
+
+	fmt.Fprintf(&w.body, "resource \"github_actions_environment_variable\" %q {\n", label)
+	fmt.Fprintf(&w.body, "  repository    = %s.repository\n", envAddress)
+	fmt.Fprintf(&w.body, "  environment   = %s.environment\n", envAddress)
+	fmt.Fprintf(&w.body, "  variable_name = %s\n", hclString(variable.Name))
+	fmt.Fprintf(&w.body, "  value         = %s\n", hclString(variable.Value))
+	w.body.WriteString("}\n\n")
+
+	w.importBlock("github_actions_environment_variable."+label, repo+":"+url.PathEscape(env)+":"+variable.Name)
+	w.resources++
+}
+
+// secret renders a github_actions_environment_secret whose value comes from a
+// sensitive input variable of the same label
+func (w *terraformWriter) secret(repo, env, envAddress string, secret Secret) {
+	label := w.label(repo, env, secret.Name)
+
+	fmt.Fprintf(&w.inputs, "variable %q {\n  type      = string\n  sensitive = true\n}\n\n", label)
+
+	fmt.Fprintf(&w.body, "resource \"github_actions_environment_secret\" %q {\n", label)
+	fmt.Fprintf(&w.body, "  repository      = %s.repository\n", envAddress)
+	fmt.Fprintf(&w.body, "  environment     = %s.environment\n", envAddress)
+	fmt.Fprintf(&w.body, "  secret_name     = %s\n", hclString(secret.Name))
+	fmt.Fprintf(&w.body, "  plaintext_value = var.%s\n", label)
+	w.body.WriteString("}\n\n")
+
+	w.importBlock("github_actions_environment_secret."+label, repo+":"+url.PathEscape(env)+":"+secret.Name)
+	w.resources++
+	w.secretVariables = append(w.secretVariables, label)
+}
+
+// render returns the complete document
+func (w *terraformWriter) render(owners []string) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Generated by GitHub Environment Manager on %s\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	fmt.Fprintf(&out, "# Configure the github provider with owner = %s\n", hclString(strings.Join(owners, ", ")))
+	if len(owners) > 1 {
+		out.WriteString("# Repositories of different owners need one provider alias each.\n")
+	}
+	out.WriteString("\n")
+
+	if w.inputs.Len() > 0 {
+		out.WriteString("# Secret values are never returned by GitHub; set these before applying.\n\n")
+		out.WriteString(w.inputs.String())
+	}
+	out.WriteString(w.body.String())
+	out.WriteString(w.imports.String())
+	return strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+func exportTerraform(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	var req struct {
+		Repos []string `json:"repos"`
+		Envs  []string `json:"envs"` // default all environments
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Repos) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one repository is required"})
+		return
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	w := newTerraformWriter()
+	owners := []string{}
+	environments := 0
+	errors := []string{}
+
+	for _, fullName := range req.Repos {
+		owner, repo, err := parseRepo(fullName)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+
+		snap, err := fetchRepoSnapshot(client, ctx, owner, repo)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		if !contains(owners, owner) {
+			owners = append(owners, owner)
+		}
+
+		for _, env := range snap.Environments {
+			if len(req.Envs) > 0 && !contains(req.Envs, env.Name) {
+				continue
+			}
+
+			settings, err := fetchEnvironmentSettings(client, ctx, owner, repo, env.Name)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("%s:%s: %v", fullName, env.Name, err))
+			}
+			envAddress := w.environment(repo, env.Name, settings)
+			environments++
+
+			sort.Slice(env.Variables, func(i, j int) bool { return env.Variables[i].Name < env.Variables[j].Name })
+			for _, variable := range env.Variables {
+				w.variable(repo, env.Name, envAddress, variable)
+			}
+			sort.Slice(env.Secrets, func(i, j int) bool { return env.Secrets[i].Name < env.Secrets[j].Name })
+			for _, secret := range env.Secrets {
+				w.secret(repo, env.Name, envAddress, secret)
+			}
+		}
+	}
+
+	if environments == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No environments to export", "errors": errors})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"content":          w.render(owners),
+		"filename":         "github-environments.tf",
+		"environments":     environments,
+		"resources":        w.resources,
+		"secret_variables": w.secretVariables,
+		"errors":           errors,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHCLString(t *testing.T) {
+	tests := map[string]string{
+		"plain":                `"plain"`,
+		`say "hi"`:             `"say \"hi\""`,
+		`C:\path`:              `"C:\\path"`,
+		"line\nbreak\ttab\r":   `"line\nbreak\ttab\r"`,
+		"https://${HOST}/api":  `"https://$${HOST}/api"`,
+		"%{ if x }y%{ endif }": `"%%{ if x }y%%{ endif }"`,
+		"$5 and 100%":          `"$5 and 100%"`,
+	}
+	for in, want := range tests {
+		if got := hclString(in); got != want {
+			t.Errorf("hclString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestTerraformLabelsAreUnique(t *testing.T) {
+	w := newTerraformWriter()
+	got := []string{
+		w.label("acme/svc", "production"),
+		w.label("acme/svc", "Production"),
+		w.label("acme_svc_production_2"),
+		w.label("acme-svc", "production"),
+		w.label("1st"),
+		w.label(""),
+	}
+	want := []string{
+		"acme_svc_production",
+		"acme_svc_production_2",
+		"acme_svc_production_2_2",
+		"acme_svc_production_3",
+		"_1st",
+		"_",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+}
+
+func TestTerraformImportIDs(t *testing.T) {
+	w := newTerraformWriter()
+	env := w.environment("svc", "prod/eu", nil)
+	w.variable("svc", "prod/eu", env, Variable{Name: "API_URL", Value: "https://${HOST}"})
+	w.secret("svc", "prod/eu", env, Secret{Name: "DB_PASSWORD"})
+	doc := w.render([]string{"acme"})
+
+	for _, want := range []string{
+		"import {\n  to = github_repository_environment.svc_prod_eu\n",
+		"import {\n  to = github_actions_environment_variable.svc_prod_eu_api_url\n  id = \"svc:prod%2Feu:API_URL\"\n}",
+		"import {\n  to = github_actions_environment_secret.svc_prod_eu_db_password\n  id = \"svc:prod%2Feu:DB_PASSWORD\"\n}",
+		`value         = "https://$${HOST}"`,
+		"variable \"svc_prod_eu_db_password\" {\n  type      = string\n  sensitive = true\n}",
+		"plaintext_value = var.svc_prod_eu_db_password",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("expected the document to contain\n%s\ngot\n%s", want, doc)
+		}
+	}
+	if w.resources != 3 || len(w.secretVariables) != 1 {
+		t.Errorf("got %d resources and secret variables %v", w.resources, w.secretVariables)
+	}
+}
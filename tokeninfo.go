@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+)
+
+// Token introspection. GitHub reports a token's scopes and expiry in response
+// headers of any authenticated request; the token type is readable from its
+// prefix. Fine-grained tokens have no scopes header and are limited to one
+// resource owner, which is inferred from the repositories they can see.
+
+// tokenExpiryWarningDays is how close to expiry a token gets a warning
+var tokenExpiryWarningDays = 7
+
+// requiredTokenScopes are the classic scopes the tool needs, with what each is for
+var requiredTokenScopes = []struct {
+	Scope   string
+	Purpose string
+}{
+	{"repo", "read and write repository variables, secrets and environments"},
+	{"admin:org", "manage organization variables and secrets"},
+}
+
+// tokenScopeImplies lists the scopes a broader classic scope includes
+var tokenScopeImplies = map[string][]string{
+	"repo":      {"public_repo", "repo:status", "repo_deployment", "repo:invite", "security_events"},
+	"admin:org": {"write:org", "read:org"},
+	"write:org": {"read:org"},
+}
+
+// TokenInfo describes the token of a session
+type TokenInfo struct {
+	Type          string   `json:"type"` // "classic", "fine-grained", "oauth", "github-app-user", "github-app-installation" or "unknown"
+	Scopes        []string `json:"scopes,omitempty"`
+	MissingScopes []string `json:"missing_scopes,omitempty"`
+	ResourceOwner string   `json:"resource_owner,omitempty"`
+	ExpiresAt     string   `json:"expires_at,omitempty"`
+	ExpiresInDays *int     `json:"expires_in_days,omitempty"`
+	Warnings      []string `json:"warnings"`
+}
+
+// tokenType reads the type of a token from its prefix
+func tokenType(token string) string {
+	switch {
+	case strings.HasPrefix(token, "ghp_"):
+		return "classic"
+	case strings.HasPrefix(token, "github_pat_"):
+		return "fine-grained"
+	case strings.HasPrefix(token, "gho_"):
+		return "oauth"
+	case strings.HasPrefix(token, "ghu_"):
+		return "github-app-user"
+	case strings.HasPrefix(token, "ghs_"):
+		return "github-app-installation"
+	}
+	return "unknown"
+}
+
+// parseTokenExpiration parses the github-authentication-token-expiration header,
+// e.g. "2024-06-22 18:50:57 UTC" or "2024-06-22 18:50:57 -0700"
+func parseTokenExpiration(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized expiration %q", value)
+}
+
+// hasTokenScope reports whether scopes grant scope, directly or through a broader scope
+func hasTokenScope(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope || contains(tokenScopeImplies[granted], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenInfoFromHeader builds the token info GitHub reports in response headers
+func tokenInfoFromHeader(token string, header http.Header, warnDays int, now time.Time) *TokenInfo {
+	info := &TokenInfo{Type: tokenType(token), Warnings: []string{}}
+
+	// Only classic and OAuth tokens have scopes; the header is absent otherwise
+	if values, ok := header["X-Oauth-Scopes"]; ok {
+		if info.Type == "unknown" {
+			info.Type = "classic"
+		}
+		info.Scopes = []string{}
+		for _, scope := range strings.Split(strings.Join(values, ","), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				info.Scopes = append(info.Scopes, scope)
+			}
+		}
+		for _, required := range requiredTokenScopes {
+			if !hasTokenScope(info.Scopes, required.Scope) {
+				info.MissingScopes = append(info.MissingScopes, required.Scope)
+				info.Warnings = append(info.Warnings, fmt.Sprintf("The token lacks the %s scope needed to %s", required.Scope, required.Purpose))
+			}
+		}
+	}
+
+	if value := header.Get("Github-Authentication-Token-Expiration"); value != "" {
+		expires, err := parseTokenExpiration(value)
+		if err != nil {
+			info.Warnings = append(info.Warnings, err.Error())
+		} else {
+			info.ExpiresAt = expires.UTC().Format("2006-01-02T15:04:05Z")
+			days := int(math.Floor(expires.Sub(now).Hours() / 24))
+			info.ExpiresInDays = &days
+			if days < warnDays {
+				info.Warnings = append(info.Warnings, fmt.Sprintf("The token expires on %s", expires.UTC().Format("2006-01-02")))
+			}
+		}
+	}
+
+	return info
+}
+
+// inspectToken asks GitHub about a token
+func inspectToken(client *github.Client, ctx context.Context, token string, warnDays int) (*TokenInfo, error) {
+	_, resp, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the authenticated user: %v", err)
+	}
+	info := tokenInfoFromHeader(token, resp.Header, warnDays, time.Now())
+
+	// A fine-grained token only sees the repositories of its resource owner
+	if info.Type == "fine-grained" {
+		repos, _, err := client.Repositories.ListByAuthenticatedUser(ctx, &github.RepositoryListByAuthenticatedUserOptions{
+			ListOptions: github.ListOptions{PerPage: 100},
+		})
+		if err != nil {
+			info.Warnings = append(info.Warnings, fmt.Sprintf("Failed to determine the resource owner: %v", err))
+		} else {
+			owners := []string{}
+			for _, repo := range repos {
+				if owner := repo.GetOwner().GetLogin(); !contains(owners, owner) {
+					owners = append(owners, owner)
+				}
+			}
+			if len(owners) == 1 {
+				info.ResourceOwner = owners[0]
+			} else if len(owners) == 0 {
+				info.Warnings = append(info.Warnings, "The token can't see any repositories")
+			}
+		}
+	}
+
+	return info, nil
+}
+
+func getTokenInfo(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	warnDays := tokenExpiryWarningDays
+	if value := c.Query("warn_days"); value != "" {
+		if warnDays, err = strconv.Atoi(value); err != nil || warnDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "warn_days must be a non-negative number"})
+			return
+		}
+	}
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	info, err := inspectToken(client, ctx, user.Token, warnDays)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
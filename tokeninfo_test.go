@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTokenExpiration(t *testing.T) {
+	want := time.Date(2024, 6, 23, 1, 50, 57, 0, time.UTC)
+	for _, value := range []string{"2024-06-23 01:50:57 UTC", "2024-06-22 18:50:57 -0700", "2024-06-23T01:50:57Z"} {
+		got, err := parseTokenExpiration(value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTokenExpiration(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := parseTokenExpiration("next tuesday"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestTokenInfoFromHeader(t *testing.T) {
+	now := time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		token    string
+		header   http.Header
+		kind     string
+		scopes   []string
+		missing  []string
+		expires  *int
+		warnings int
+	}{
+		{
+			name:   "classic with every scope",
+			token:  "ghp_abc",
+			header: http.Header{"X-Oauth-Scopes": {"repo, admin:org, workflow"}},
+			kind:   "classic",
+			scopes: []string{"repo", "admin:org", "workflow"},
+		},
+		{
+			name:     "write:org doesn't imply admin:org",
+			token:    "gho_abc",
+			header:   http.Header{"X-Oauth-Scopes": {"repo, write:org"}},
+			kind:     "oauth",
+			scopes:   []string{"repo", "write:org"},
+			missing:  []string{"admin:org"},
+			warnings: 1,
+		},
+		{
+			name:     "public_repo doesn't imply repo",
+			token:    "ghp_abc",
+			header:   http.Header{"X-Oauth-Scopes": {"public_repo"}},
+			kind:     "classic",
+			scopes:   []string{"public_repo"},
+			missing:  []string{"repo", "admin:org"},
+			warnings: 2,
+		},
+		{
+			name:     "empty scopes header",
+			token:    "token-without-prefix",
+			header:   http.Header{"X-Oauth-Scopes": {""}},
+			kind:     "classic",
+			scopes:   []string{},
+			missing:  []string{"repo", "admin:org"},
+			warnings: 2,
+		},
+		{
+			name:     "fine-grained expiring soon",
+			token:    "github_pat_abc",
+			header:   http.Header{"Github-Authentication-Token-Expiration": {"2024-06-23 12:00:00 UTC"}},
+			kind:     "fine-grained",
+			expires:  intPtr(3),
+			warnings: 1,
+		},
+		{
+			name:    "fine-grained expiring later",
+			token:   "github_pat_abc",
+			header:  http.Header{"Github-Authentication-Token-Expiration": {"2024-09-01 00:00:00 +0200"}},
+			kind:    "fine-grained",
+			expires: intPtr(72),
+		},
+		{
+			name:     "unreadable expiry",
+			token:    "github_pat_abc",
+			header:   http.Header{"Github-Authentication-Token-Expiration": {"soon"}},
+			kind:     "fine-grained",
+			warnings: 1,
+		},
+		{
+			name:   "installation token",
+			token:  "ghs_abc",
+			header: http.Header{},
+			kind:   "github-app-installation",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := tokenInfoFromHeader(tt.token, tt.header, 7, now)
+			if info.Type != tt.kind {
+				t.Errorf("type = %q, want %q", info.Type, tt.kind)
+			}
+			if !reflect.DeepEqual(info.Scopes, tt.scopes) || !reflect.DeepEqual(info.MissingScopes, tt.missing) {
+				t.Errorf("scopes = %v missing %v, want %v missing %v", info.Scopes, info.MissingScopes, tt.scopes, tt.missing)
+			}
+			if (info.ExpiresInDays == nil) != (tt.expires == nil) || (tt.expires != nil && *info.ExpiresInDays != *tt.expires) {
+				t.Errorf("expires in %v days, want %v", deref(info.ExpiresInDays), deref(tt.expires))
+			}
+			if len(info.Warnings) != tt.warnings {
+				t.Errorf("warnings = %v, want %d", info.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestHasTokenScope(t *testing.T) {
+	scopes := []string{"repo", "admin:org"}
+	for scope, want := range map[string]bool{"repo": true, "public_repo": true, "read:org": true, "write:org": true, "workflow": false, "admin:repo_hook": false} {
+		if got := hasTokenScope(scopes, scope); got != want {
+			t.Errorf("hasTokenScope(%v, %q) = %t, want %t", scopes, scope, got, want)
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+func deref(n *int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v74/github"
+	"gopkg.in/yaml.v3"
+)
+
+// Workflow reference scanner. Reads .github/workflows/*.yml through the contents
+// API, extracts vars.X / secrets.X references per job together with the
+// environment the job runs in and cross-checks them against what is defined.
+
+const workflowsDir = ".github/workflows"
+
+var (
+	workflowRefDot     = regexp.MustCompile(`\b(vars|secrets)\.([A-Za-z_][A-Za-z0-9_]*)`)
+	workflowRefBracket = regexp.MustCompile(`\b(vars|secrets)\[\s*['"]([A-Za-z_][A-Za-z0-9_]*)['"]\s*\]`)
+)
+
+// Secrets provided by the Actions runtime, never defined by users
+var builtinSecrets = []string{"GITHUB_TOKEN"}
+
+// WorkflowJob holds the references found in a single job
+type WorkflowJob struct {
+	ID          string `json:"id"`
+	Environment string `json:"environment,omitempty"`
+	// Dynamic is set when the environment is an expression such as ${{ inputs.env }}
+	Dynamic   bool     `json:"dynamic,omitempty"`
+	Variables []string `json:"variables"`
+	Secrets   []string `json:"secrets"`
+}
+
+// WorkflowFile holds the jobs of one workflow file
+type WorkflowFile struct {
+	Path  string        `json:"path"`
+	Jobs  []WorkflowJob `json:"jobs"`
+	Error string        `json:"error,omitempty"`
+}
+
+// MissingReference is a key referenced by workflows but not defined where the job can see it
+type MissingReference struct {
+	Environment  string   `json:"environment,omitempty"`
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	ReferencedBy []string `json:"referenced_by"`
+}
+
+// UnusedKey is a key defined at some scope but never referenced by a workflow that could use it
+type UnusedKey struct {
+	Scope       string `json:"scope"` // "org", "repository" or "environment"
+	Environment string `json:"environment,omitempty"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+}
+
+// WorkflowReferenceReport is the result of cross-checking workflows against defined keys
+type WorkflowReferenceReport struct {
+	Workflows []WorkflowFile     `json:"workflows"`
+	Missing   []MissingReference `json:"missing"`
+	Unused    []UnusedKey        `json:"unused"`
+}
+
+// extractReferences returns the variable and secret names referenced in a chunk of YAML text
+func extractReferences(text string) ([]string, []string) {
+	var variables, secrets []string
+	for _, re := range []*regexp.Regexp{workflowRefDot, workflowRefBracket} {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			name := strings.ToUpper(match[2])
+			if match[1] == "vars" {
+				if !contains(variables, name) {
+					variables = append(variables, name)
+				}
+			} else if !contains(secrets, name) && !contains(builtinSecrets, name) {
+				secrets = append(secrets, name)
+			}
+		}
+	}
+	sort.Strings(variables)
+	sort.Strings(secrets)
+	return variables, secrets
+}
+
+func mergeNames(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, name := range b {
+		if !contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// parseWorkflow extracts the per-job references of a workflow file. References
+// outside of jobs (workflow-level env, defaults, ...) are attributed to every job.
+func parseWorkflow(filePath string, content []byte) (WorkflowFile, error) {
+	workflow := WorkflowFile{Path: filePath, Jobs: []WorkflowJob{}}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return workflow, fmt.Errorf("failed to parse %s: %v", filePath, err)
+	}
+
+	jobs, _ := doc["jobs"].(map[string]interface{})
+	delete(doc, "jobs")
+
+	topLevel, err := yaml.Marshal(doc)
+	if err != nil {
+		return workflow, err
+	}
+	topVars, topSecrets := extractReferences(string(topLevel))
+
+	jobIDs := make([]string, 0, len(jobs))
+	for id := range jobs {
+		jobIDs = append(jobIDs, id)
+	}
+	sort.Strings(jobIDs)
+
+	for _, id := range jobIDs {
+		job := WorkflowJob{ID: id}
+
+		if jobDef, ok := jobs[id].(map[string]interface{}); ok {
+			switch env := jobDef["environment"].(type) {
+			case string:
+				job.Environment = env
+			case map[string]interface{}:
+				job.Environment, _ = env["name"].(string)
+			}
+		}
+		job.Dynamic = strings.Contains(job.Environment, "${{")
+
+		jobText, err := yaml.Marshal(jobs[id])
+		if err != nil {
+			return workflow, err
+		}
+		jobVars, jobSecrets := extractReferences(string(jobText))
+		job.Variables = mergeNames(topVars, jobVars)
+		job.Secrets = mergeNames(topSecrets, jobSecrets)
+
+		workflow.Jobs = append(workflow.Jobs, job)
+	}
+
+	return workflow, nil
+}
+
+// fetchWorkflows downloads and parses every workflow file of a repository.
+// Files that fail to parse are returned with their error rather than aborting the scan.
+func fetchWorkflows(client *github.Client, ctx context.Context, owner, repo string) ([]WorkflowFile, error) {
+	_, entries, _, err := client.Repositories.GetContents(ctx, owner, repo, workflowsDir, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return []WorkflowFile{}, nil
+		}
+		return nil, err
+	}
+
+	workflows := []WorkflowFile{}
+	for _, entry := range entries {
+		ext := path.Ext(entry.GetName())
+		if entry.GetType() != "file" || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, entry.GetPath(), nil)
+		if err != nil {
+			workflows = append(workflows, WorkflowFile{Path: entry.GetPath(), Jobs: []WorkflowJob{}, Error: err.Error()})
+			continue
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			workflows = append(workflows, WorkflowFile{Path: entry.GetPath(), Jobs: []WorkflowJob{}, Error: err.Error()})
+			continue
+		}
+
+		workflow, err := parseWorkflow(entry.GetPath(), []byte(content))
+		if err != nil {
+			workflow.Error = err.Error()
+		}
+		workflows = append(workflows, workflow)
+	}
+
+	return workflows, nil
+}
+
+func variableNames(variables []Variable) []string {
+	names := make([]string, 0, len(variables))
+	for _, v := range variables {
+		names = append(names, strings.ToUpper(v.Name))
+	}
+	return names
+}
+
+func secretNames(secrets []Secret) []string {
+	names := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		names = append(names, strings.ToUpper(s.Name))
+	}
+	return names
+}
+
+// analyzeWorkflowReferences cross-checks workflow references against the keys defined
+// at environment, repository and organization level. A job without an environment
+// only sees repository and org keys; a job with a dynamic environment is checked
+// against every environment of the repository, or skipped when there are none.
+// Environment names are matched case-insensitively, like GitHub does.
+func analyzeWorkflowReferences(workflows []WorkflowFile, snap *RepoSnapshot, orgVars []Variable, orgSecrets []Secret) WorkflowReferenceReport {
+	report := WorkflowReferenceReport{Workflows: workflows, Missing: []MissingReference{}, Unused: []UnusedKey{}}
+
+	allEnvs := make([]string, 0, len(snap.Environments))
+	for _, env := range snap.Environments {
+		allEnvs = append(allEnvs, env.Name)
+	}
+
+	repoVars, repoSecrets := variableNames(snap.Variables), secretNames(snap.Secrets)
+	sharedVars, sharedSecrets := variableNames(orgVars), secretNames(orgSecrets)
+
+	missing := make(map[string]*MissingReference)
+	var missingOrder []string
+	addMissing := func(env, keyType, name, ref string) {
+		key := env + "|" + keyType + "|" + name
+		entry, exists := missing[key]
+		if !exists {
+			entry = &MissingReference{Environment: env, Type: keyType, Name: name}
+			missing[key] = entry
+			missingOrder = append(missingOrder, key)
+		}
+		if !contains(entry.ReferencedBy, ref) {
+			entry.ReferencedBy = append(entry.ReferencedBy, ref)
+		}
+	}
+
+	usedVars, usedSecrets := []string{}, []string{}
+	envUsedVars, envUsedSecrets := map[string][]string{}, map[string][]string{}
+
+	for _, workflow := range workflows {
+		for _, job := range workflow.Jobs {
+			ref := workflow.Path + "#" + job.ID
+			usedVars = mergeNames(usedVars, job.Variables)
+			usedSecrets = mergeNames(usedSecrets, job.Secrets)
+
+			// The literal ${{ ... }} of a dynamic environment names nothing
+			targets := []string{job.Environment}
+			if job.Dynamic {
+				targets = allEnvs
+			}
+
+			for _, env := range targets {
+				envVars, envSecrets := []string{}, []string{}
+				if env != "" {
+					if envSnap := snap.Environment(env); envSnap != nil {
+						env = envSnap.Name
+						envVars, envSecrets = variableNames(envSnap.Variables), secretNames(envSnap.Secrets)
+					}
+					envUsedVars[env] = mergeNames(envUsedVars[env], job.Variables)
+					envUsedSecrets[env] = mergeNames(envUsedSecrets[env], job.Secrets)
+				}
+
+				for _, name := range job.Variables {
+					if !contains(envVars, name) && !contains(repoVars, name) && !contains(sharedVars, name) {
+						addMissing(env, "variable", name, ref)
+					}
+				}
+				for _, name := range job.Secrets {
+					if !contains(envSecrets, name) && !contains(repoSecrets, name) && !contains(sharedSecrets, name) {
+						addMissing(env, "secret", name, ref)
+					}
+				}
+			}
+		}
+	}
+
+	for _, key := range missingOrder {
+		report.Missing = append(report.Missing, *missing[key])
+	}
+
+	for _, name := range sharedVars {
+		if !contains(usedVars, name) {
+			report.Unused = append(report.Unused, UnusedKey{Scope: "org", Type: "variable", Name: name})
+		}
+	}
+	for _, name := range sharedSecrets {
+		if !contains(usedSecrets, name) {
+			report.Unused = append(report.Unused, UnusedKey{Scope: "org", Type: "secret", Name: name})
+		}
+	}
+	for _, name := range repoVars {
+		if !contains(usedVars, name) {
+			report.Unused = append(report.Unused, UnusedKey{Scope: "repository", Type: "variable", Name: name})
+		}
+	}
+	for _, name := range repoSecrets {
+		if !contains(usedSecrets, name) {
+			report.Unused = append(report.Unused, UnusedKey{Scope: "repository", Type: "secret", Name: name})
+		}
+	}
+	for _, env := range snap.Environments {
+		for _, name := range variableNames(env.Variables) {
+			if !contains(envUsedVars[env.Name], name) {
+				report.Unused = append(report.Unused, UnusedKey{Scope: "environment", Environment: env.Name, Type: "variable", Name: name})
+			}
+		}
+		for _, name := range secretNames(env.Secrets) {
+			if !contains(envUsedSecrets[env.Name], name) {
+				report.Unused = append(report.Unused, UnusedKey{Scope: "environment", Environment: env.Name, Type: "secret", Name: name})
+			}
+		}
+	}
+
+	return report
+}
+
+func getWorkflowReferences(c *gin.Context) {
+	// Get authenticated user
+	user, err := getAuthenticatedUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	owner := c.Param("owner")
+	repo := c.Param("repo")
+
+	// Create GitHub client
+	ctx := context.Background()
+	client := github.NewClient(nil).WithAuthToken(user.Token)
+
+	workflows, err := fetchWorkflows(client, ctx, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch workflows: %v", err)})
+		return
+	}
+
+	snap, err := fetchRepoSnapshot(client, ctx, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgVars, err := listRepoOrgVariables(client, ctx, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch organization variables: %v", err)})
+		return
+	}
+	orgSecrets, err := listRepoOrgSecrets(client, ctx, owner, repo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to fetch organization secrets: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, analyzeWorkflowReferences(workflows, snap, orgVars, orgSecrets))
+}
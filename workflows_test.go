@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const deployWorkflow = `
+name: deploy
+on: push
+env:
+  REGION: ${{ vars.REGION }}
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    steps:
+      - run: make test
+        env:
+          TOKEN: ${{ secrets.GITHUB_TOKEN }}
+  deploy:
+    runs-on: ubuntu-latest
+    environment:
+      name: Production
+      url: ${{ vars.app_url }}
+    steps:
+      - run: ./deploy
+        env:
+          KEY: ${{ secrets['DEPLOY_KEY'] }}
+  preview:
+    runs-on: ubuntu-latest
+    environment: ${{ inputs.target }}
+    steps:
+      - run: echo ${{ vars.PREVIEW_HOST }}
+`
+
+func TestParseWorkflow(t *testing.T) {
+	workflow, err := parseWorkflow(".github/workflows/deploy.yml", []byte(deployWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []WorkflowJob{
+		{ID: "deploy", Environment: "Production", Variables: []string{"APP_URL", "REGION"}, Secrets: []string{"DEPLOY_KEY"}},
+		{ID: "preview", Environment: "${{ inputs.target }}", Dynamic: true, Variables: []string{"PREVIEW_HOST", "REGION"}, Secrets: []string{}},
+		{ID: "test", Variables: []string{"REGION"}, Secrets: []string{}},
+	}
+	if !reflect.DeepEqual(workflow.Jobs, want) {
+		t.Errorf("jobs = %+v\nwant %+v", workflow.Jobs, want)
+	}
+
+	if _, err := parseWorkflow("broken.yml", []byte("jobs: [")); err == nil {
+		t.Error("expected invalid YAML to fail")
+	}
+}
+
+func TestAnalyzeWorkflowReferences(t *testing.T) {
+	workflows := []WorkflowFile{{Path: "deploy.yml", Jobs: []WorkflowJob{
+		{ID: "deploy", Environment: "Production", Variables: []string{"APP_URL", "REGION"}, Secrets: []string{"DEPLOY_KEY"}},
+		{ID: "test", Variables: []string{"REGION"}, Secrets: []string{"NPM_TOKEN"}},
+	}}}
+	snap := &RepoSnapshot{
+		Variables: []Variable{{Name: "REGION"}, {Name: "OLD_FLAG"}},
+		Environments: []EnvironmentSnapshot{
+			{Name: "production", Variables: []Variable{{Name: "APP_URL"}}, Secrets: []Secret{{Name: "DEPLOY_KEY"}}},
+			{Name: "staging", Variables: []Variable{{Name: "APP_URL"}}},
+		},
+	}
+
+	report := analyzeWorkflowReferences(workflows, snap, nil, []Secret{{Name: "SHARED_KEY"}})
+
+	wantMissing := []MissingReference{{Type: "secret", Name: "NPM_TOKEN", ReferencedBy: []string{"deploy.yml#test"}}}
+	if !reflect.DeepEqual(report.Missing, wantMissing) {
+		t.Errorf("missing = %+v\nwant %+v", report.Missing, wantMissing)
+	}
+	wantUnused := []UnusedKey{
+		{Scope: "org", Type: "secret", Name: "SHARED_KEY"},
+		{Scope: "repository", Type: "variable", Name: "OLD_FLAG"},
+		{Scope: "environment", Environment: "staging", Type: "variable", Name: "APP_URL"},
+	}
+	if !reflect.DeepEqual(report.Unused, wantUnused) {
+		t.Errorf("unused = %+v\nwant %+v", report.Unused, wantUnused)
+	}
+}
+
+func TestAnalyzeWorkflowReferencesWithDynamicEnvironments(t *testing.T) {
+	workflows := []WorkflowFile{{Path: "preview.yml", Jobs: []WorkflowJob{
+		{ID: "preview", Environment: "${{ inputs.target }}", Dynamic: true, Variables: []string{"PREVIEW_HOST"}, Secrets: []string{}},
+	}}}
+
+	// Without environments there is nothing to check the job against
+	report := analyzeWorkflowReferences(workflows, &RepoSnapshot{}, nil, nil)
+	if len(report.Missing) != 0 {
+		t.Errorf("expected no missing keys, got %+v", report.Missing)
+	}
+
+	// Otherwise it's checked against every environment
+	snap := &RepoSnapshot{Environments: []EnvironmentSnapshot{
+		{Name: "pr-1", Variables: []Variable{{Name: "PREVIEW_HOST"}}},
+		{Name: "pr-2"},
+	}}
+	report = analyzeWorkflowReferences(workflows, snap, nil, nil)
+	want := []MissingReference{{Environment: "pr-2", Type: "variable", Name: "PREVIEW_HOST", ReferencedBy: []string{"preview.yml#preview"}}}
+	if !reflect.DeepEqual(report.Missing, want) || len(report.Unused) != 0 {
+		t.Errorf("missing = %+v, unused = %+v\nwant missing %+v", report.Missing, report.Unused, want)
+	}
+}